@@ -2,23 +2,35 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/cranej/ticktock/remote"
 	"github.com/cranej/ticktock/server"
 	"github.com/cranej/ticktock/store"
 	"github.com/cranej/ticktock/utils"
 	"github.com/cranej/ticktock/view"
 	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/term"
+	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type StartCmd struct {
-	Wait  bool     `short:"w" help:"If set, wait for notes input until Ctrl-D, then close the activity"`
-	Title string   `arg:"" optional:"" name:"title" help:"Title of the activity. Choose from recent titles interactively if not given"`
-	Notes []string `help:"Notes of the activity, each input as a line. If a single '-' is given, read from stdin"`
+	Wait     bool              `short:"w" help:"If set, wait for notes input until Ctrl-D, then close the activity"`
+	Title    string            `arg:"" optional:"" name:"title" help:"Title of the activity. Choose from recent titles interactively if not given"`
+	Notes    []string          `help:"Notes of the activity, each input as a line. If a single '-' is given, read from stdin"`
+	Billable *bool             `negatable:"" help:"Whether the activity is billable, default to the billable default of its tag"`
+	Meta     map[string]string `placeholder:"KEY=VALUE" help:"Metadata of the activity, repeatable"`
+	Tags     []string          `name:"tag" help:"Tags of the activity in addition to the one derived from title, repeatable"`
 }
 
 func (c *StartCmd) Run(ss store.Store) error {
@@ -32,10 +44,17 @@ func (c *StartCmd) Run(ss store.Store) error {
 		return err
 	}
 
-	if err := ss.StartTitle(title, notes); err != nil {
+	activity := store.OpenActivity{Title: title, Start: time.Now().UTC(), Notes: notes, Meta: c.Meta, Tags: c.Tags}
+	if activity.Billable, err = billableOrDefault(ss, c.Billable, &activity); err != nil {
+		return err
+	}
+	if err := ss.Start(&activity); err != nil {
 		return err
 	}
 	fmt.Printf("(Started: %s)\n", c.Title)
+	if err := printBudgetWarnings(ss, &store.ClosedActivity{OpenActivity: &activity}); err != nil {
+		return err
+	}
 
 	if c.Wait {
 		fmt.Println("Waiting for notes input, Ctrl-D ends the input and close the activity:")
@@ -50,14 +69,16 @@ func (c *StartCmd) Run(ss store.Store) error {
 		}
 
 		fmt.Printf("(Closed: %s)\n", r)
-		return nil
+		return printClosedBudgetWarnings(ss, r)
 	} else {
 		return nil
 	}
 }
 
 type CloseCmd struct {
-	Notes []string `help:"Notes to appends, each input as a line. If a single '-' is given, read from stdin"`
+	Notes    []string          `help:"Notes to appends, each input as a line. If a single '-' is given, read from stdin"`
+	Billable *bool             `negatable:"" help:"If set, change whether the activity is billable"`
+	Meta     map[string]string `placeholder:"KEY=VALUE" help:"Metadata to add to the activity, repeatable"`
 }
 
 func (c *CloseCmd) Run(ss store.Store) error {
@@ -66,6 +87,28 @@ func (c *CloseCmd) Run(ss store.Store) error {
 		return err
 	}
 
+	if c.Billable != nil || len(c.Meta) > 0 {
+		ongoing, err := ss.Ongoing()
+		if err != nil {
+			return err
+		}
+
+		if ongoing != nil {
+			if c.Billable != nil {
+				ongoing.Billable = *c.Billable
+			}
+			if ongoing.Meta == nil {
+				ongoing.Meta = make(map[string]string)
+			}
+			for key, value := range c.Meta {
+				ongoing.Meta[key] = value
+			}
+			if err := ss.Update(&store.ClosedActivity{OpenActivity: ongoing}); err != nil {
+				return err
+			}
+		}
+	}
+
 	r, err := ss.CloseActivity(notes)
 	if err != nil {
 		return err
@@ -73,15 +116,47 @@ func (c *CloseCmd) Run(ss store.Store) error {
 
 	if len(r) != 0 {
 		fmt.Printf("(Closed: %s)\n", r)
+		return printClosedBudgetWarnings(ss, r)
 	} else {
 		fmt.Println("(NothingToClose)")
 	}
 	return nil
 }
 
+func printClosedBudgetWarnings(ss store.Store, title string) error {
+	activity, err := ss.LastClosed(title)
+	if errors.Is(err, remote.ErrUnreachable) {
+		return nil
+	}
+	if err != nil || activity == nil {
+		return err
+	}
+
+	return printBudgetWarnings(ss, activity)
+}
+
+// printBudgetWarnings prints warnings of budgets of the activity, see store.BudgetWarnings.
+func printBudgetWarnings(ss store.Store, activity *store.ClosedActivity) error {
+	warnings, err := store.BudgetWarnings(ss, activity, time.Now())
+	// budgets are unknown while the remote server is unreachable
+	if errors.Is(err, remote.ErrUnreachable) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		fmt.Printf("(Warning: %s)\n", w)
+	}
+	return nil
+}
+
 type TitlesCmd struct {
-	Limit uint8 `short:"n" default:"5" help:"Number of titles to display, default 5"`
-	Index bool  `short:"i" help:"If set, prefix titles with index starts from 1"`
+	Filter string `arg:"" optional:"" help:"Only show titles starting with it, or containing its characters in order"`
+	Limit  uint8  `short:"n" default:"5" help:"Number of titles to display, default 5"`
+	Index  bool   `short:"i" help:"If set, prefix titles with index starts from 1"`
+	Rank   string `default:"recent" enum:"recent,frecency" help:"Order of titles, valid values are: recent (latest used first), frecency (frequently and recently used first, and those usually started around this time of day or on this weekday)"`
 }
 
 func (c *TitlesCmd) Run(ss store.Store) error {
@@ -90,7 +165,7 @@ func (c *TitlesCmd) Run(ss store.Store) error {
 		limit = c.Limit
 	}
 
-	titles, err := ss.RecentTitles(limit)
+	titles, err := ss.Titles(store.TitleRank(c.Rank), c.Filter, time.Now(), int(limit))
 	if err != nil {
 		return err
 	}
@@ -158,9 +233,158 @@ func (c *OngoingCmd) Run(ss store.Store) error {
 
 	duration := time.Since(activity.Start)
 	fmt.Printf("%s: %.0f minutes\n", activity.Title, duration.Minutes())
+	// goals are unknown while the remote server is unreachable
+	if err := reportGoalProgress(ss, activity); err != nil && !errors.Is(err, remote.ErrUnreachable) {
+		return err
+	}
+	return printBudgetWarnings(ss, &store.ClosedActivity{OpenActivity: activity})
+}
+
+// reportGoalProgress prints progress of goals of the ongoing activity's tags, including the ongoing time.
+func reportGoalProgress(ss store.Store, activity *store.OpenActivity) error {
+	goals, err := ss.Goals()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, goal := range goals {
+		if !activity.HasTag(goal.Tag) {
+			continue
+		}
+
+		periodStart := goal.Period.Start(now)
+		activities, err := ss.Closed(periodStart.UTC(), now.UTC(), store.NewTagArg([]string{goal.Tag}))
+		if err != nil {
+			return err
+		}
+
+		done := view.Progress(&goals[i], activities, now)
+		if activity.Start.After(periodStart) {
+			done += now.Sub(activity.Start)
+		} else {
+			done += now.Sub(periodStart)
+		}
+
+		fmt.Printf("Goal %s (per %s): %s / %s %s\n",
+			goal.Tag,
+			goal.Period,
+			view.DurS(done),
+			view.DurS(goal.Target),
+			view.ProgressBar(done, goal.Target))
+	}
+
 	return nil
 }
 
+type StatusCmd struct {
+	Format   string        `default:"plain" enum:"waybar,i3blocks,plain,json" help:"Output format, valid values are: waybar, i3blocks, plain, json"`
+	Watch    bool          `help:"Keep running, and print the status again in one line when it changes. For i3blocks, use it with 'interval=persist' and 'format=json'"`
+	Interval time.Duration `default:"1s" help:"With --watch, how often to check for changes"`
+}
+
+func (c *StatusCmd) Run(ss store.Store) error {
+	if !c.Watch {
+		status, err := loadStatus(ss, time.Now())
+		if err != nil {
+			return err
+		}
+		out, err := status.Format(c.Format, false)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+		return nil
+	}
+
+	// The status is only loaded again when the snapshot changes, and advanced in time otherwise.
+	var snapshot *statusSnapshot
+	var status *view.Status
+	last := ""
+	for ; ; time.Sleep(c.Interval) {
+		now := time.Now()
+		current, err := loadStatusSnapshot(ss, now)
+		if err == nil && (status == nil || !reflect.DeepEqual(current, snapshot)) {
+			snapshot = current
+			status, err = loadStatus(ss, now)
+		}
+		// keep watching on errors, for example, db locked by another process
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			continue
+		}
+
+		out, err := status.Advance(now).Format(c.Format, true)
+		if err != nil {
+			return err
+		}
+		if out != last {
+			fmt.Println(out)
+			last = out
+		}
+	}
+}
+
+// statusSnapshot is what changes of the status are detected from: the ongoing activity, the last
+// closed one, and the day.
+type statusSnapshot struct {
+	ongoing *store.OpenActivity
+	last    *store.ClosedActivity
+	day     time.Time
+}
+
+func loadStatusSnapshot(ss store.Store, now time.Time) (*statusSnapshot, error) {
+	ongoing, err := ss.Ongoing()
+	if err != nil {
+		return nil, err
+	}
+	last, err := ss.LastClosed("")
+	if err != nil {
+		return nil, err
+	}
+	return &statusSnapshot{ongoing, last, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)}, nil
+}
+
+func loadStatus(ss store.Store, now time.Time) (*view.Status, error) {
+	ongoing, err := ss.Ongoing()
+	if err != nil {
+		return nil, err
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	activities, err := ss.Closed(dayStart.UTC(), now.UTC(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := view.Status{Ongoing: ongoing, At: now}
+	for _, activity := range activities {
+		status.Today += activity.End.Sub(activity.Start)
+	}
+
+	if ongoing != nil {
+		start := ongoing.Start
+		if start.Before(dayStart) {
+			start = dayStart
+		}
+		status.Today += now.Sub(start)
+
+		if status.Budgets, err = store.ActivityBudgets(ss, ongoing, now.Sub(ongoing.Start)); err != nil {
+			return nil, err
+		}
+	} else {
+		last, err := ss.LastClosed("")
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			status.IdleSince = last.End
+		}
+	}
+
+	return &status, nil
+}
+
 type LastCmd struct {
 	Title string `arg:"" optional:"" help:"Title of the activity. Choose interactively if not given"`
 }
@@ -184,14 +408,200 @@ func (c *LastCmd) Run(ss store.Store) error {
 	return nil
 }
 
+type SearchCmd struct {
+	Query []string `arg:"" help:"Words to search in titles and notes, an activity matches if it contains all of them"`
+	Since string   `help:"Search activities from the day, in format 'yyyy-MM-dd'"`
+	Until string   `help:"Search activities to the end of the day, in format 'yyyy-MM-dd'"`
+	Title []string `xor:"filter" help:"Only search activities of these titles"`
+	Tag   []string `xor:"filter" help:"Only search activities of these tags"`
+	Match string   `default:"any" enum:"any,all,none" help:"With '--tag', search activities with any, all, or none of the tags"`
+	Limit int      `default:"20" help:"Show at most this number of activities, 0 means no limit"`
+}
+
+func (c *SearchCmd) Run(ss store.Store) error {
+	since, err := parseDate(c.Since)
+	if err != nil {
+		return err
+	}
+	until, err := parseDate(c.Until)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		until = until.Add(24*time.Hour - time.Second)
+	}
+
+	arg := store.NewTitleArg(c.Title)
+	if len(c.Tag) > 0 {
+		arg = store.NewTagMatchArg(c.Tag, tagMatches[c.Match])
+	}
+
+	results, err := ss.Search(strings.Join(c.Query, " "), since.UTC(), until.UTC(), arg, c.Limit)
+	if err != nil {
+		return err
+	}
+
+	// highlight with bold on terminal
+	open, close := "[", "]"
+	if stat, err := os.Stdout.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		open, close = "\x1b[1m", "\x1b[0m"
+	}
+	for _, r := range results {
+		fmt.Printf("%s\n%s ~ %s | #%d\n    %s\n",
+			r.Title,
+			r.Start.Local().Format(time.DateTime),
+			r.End.Local().Format(time.DateTime),
+			r.Id,
+			strings.ReplaceAll(r.Highlight(open, close), "\n", " "))
+	}
+	if len(results) == 0 {
+		fmt.Println("No matched activity.")
+	}
+	return nil
+}
+
+type LogCmd struct {
+	Limit  int      `short:"n" default:"20" help:"Show at most this number of activities, 0 means no limit"`
+	Before string   `help:"Show activities before this one, given as '#id', 'yyyy-MM-dd' or time in the formats accepted by 'add --start'"`
+	After  string   `help:"Show activities after this one, accepts the same formats as --before"`
+	Title  []string `xor:"filter" help:"Only show activities of these titles"`
+	Tag    []string `xor:"filter" help:"Only show activities of these tags"`
+	Match  string   `default:"any" enum:"any,all,none" help:"With '--tag', show activities with any, all, or none of the tags"`
+}
+
+func (c *LogCmd) Run(ss store.Store) error {
+	before, err := parseCursor(c.Before, ss)
+	if err != nil {
+		return err
+	}
+	after, err := parseCursor(c.After, ss)
+	if err != nil {
+		return err
+	}
+
+	arg := store.NewTitleArg(c.Title)
+	if len(c.Tag) > 0 {
+		arg = store.NewTagMatchArg(c.Tag, tagMatches[c.Match])
+	}
+
+	activities, err := ss.History(before, after, arg, c.Limit)
+	if err != nil {
+		return err
+	}
+
+	for i := range activities {
+		fmt.Println(logLine(&activities[i]))
+	}
+
+	if len(activities) == 0 {
+		fmt.Println("No activity.")
+	} else if c.Limit > 0 && len(activities) == c.Limit {
+		fmt.Printf("(More: --before #%d, or --after #%d for newer)\n",
+			activities[len(activities)-1].Id, activities[0].Id)
+	}
+	return nil
+}
+
+// logLine returns the activity in one line, as "#id start ~ end duration  title | first line of notes".
+func logLine(activity *store.ClosedActivity) string {
+	start, end := activity.Start.Local(), activity.End.Local()
+	endFormat := "15:04"
+	if start.Format(time.DateOnly) != end.Format(time.DateOnly) {
+		endFormat = IMPORT_FULL_DT
+	}
+
+	line := fmt.Sprintf("#%d %s ~ %s %6s  %s",
+		activity.Id,
+		start.Format(IMPORT_FULL_DT),
+		end.Format(endFormat),
+		view.DurS(activity.End.Sub(activity.Start)),
+		activity.Title)
+	if note, _, _ := strings.Cut(strings.TrimSpace(activity.Notes), "\n"); note != "" {
+		line += " | " + note
+	}
+	return line
+}
+
+// parseCursor parses '#id', 'yyyy-MM-dd' or time accepted by parseImportTime into cursor, empty
+// string is parsed as zero cursor.
+func parseCursor(value string, ss store.Store) (store.Cursor, error) {
+	if value == "" {
+		return store.Cursor{}, nil
+	}
+
+	if strings.HasPrefix(value, "#") {
+		id, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return store.Cursor{}, err
+		}
+
+		activity, err := ss.Get(id)
+		if err != nil {
+			return store.Cursor{}, fmt.Errorf("activity %s: %w", value, err)
+		}
+		return store.CursorOf(activity.OpenActivity), nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		if t, err = parseImportTime(value); err != nil {
+			return store.Cursor{}, err
+		}
+	}
+	return store.Cursor{Start: t.UTC()}, nil
+}
+
+type TuiCmd struct{}
+
+func (c *TuiCmd) Run(ss store.Store) error {
+	t := tui{ss: ss}
+	return t.run()
+}
+
+type CompletionCmd struct {
+	Shell string `arg:"" enum:"bash,zsh,fish" help:"Shell of the completion script, valid values are: bash, zsh, fish"`
+}
+
+func (c *CompletionCmd) Run(ctx *kong.Context) error {
+	script, err := completionScript(c.Shell, ctx.Model.Name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(script)
+	return nil
+}
+
+// CompleteCmd is called by completion scripts.
+type CompleteCmd struct {
+	Words []string `arg:"" optional:"" help:"Words of the command line after the program name, the last one is to complete"`
+}
+
+func (c *CompleteCmd) Run(ctx *kong.Context, ss store.Store) error {
+	candidates, err := complete(ctx.Model.Node, c.Words, ss)
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
 type ReportCmd struct {
-	Type  string   `default:"summary" enum:"summary,detail,dist,efforts" help:"Type of the report to show, valid values are: summary, detail, dist (distribution), and efforts"`
-	From  uint16   `short:"f" default:"0" help:"Show report of activities from '@today - From'. For example, '--from 1' shows report from yesterday 00:00:00"`
-	To    uint16   `short:"t" default:"0" help:"Show report of activities to @today - To. For example, '--to 1' shows report to yesterday 23:59:59"`
-	Week  bool     `short:"w" default:"false" help:"Show report from Monday 0:00:00, ignored if '--from/-f' or '--to/-t' is given"`
-	Month bool     `short:"m" default:"false" help:"Show report from the 1st day 0:00:00 of this month , ignored if '--from/-f' or '--to/-t' or '--week/-w' is given"`
-	Title []string `help:"filter by titles"`
-	Tag   bool     `default:"false" help:"if set, --title 'book' queries all activities with title starts with 'book: ' (here, book is the tag of the activity). Also, activities will be aggregated by tag instead of by title"`
+	Type     string            `default:"summary" enum:"summary,detail,dist,efforts,goals,budget,billing" help:"Type of the report to show, valid values are: summary, detail, dist (distribution), efforts, goals, budget, and billing"`
+	From     uint16            `short:"f" default:"0" help:"Show report of activities from '@today - From'. For example, '--from 1' shows report from yesterday 00:00:00"`
+	To       uint16            `short:"t" default:"0" help:"Show report of activities to @today - To. For example, '--to 1' shows report to yesterday 23:59:59"`
+	Week     bool              `short:"w" default:"false" help:"Show report from Monday 0:00:00, ignored if '--from/-f' or '--to/-t' is given"`
+	Month    bool              `short:"m" default:"false" help:"Show report from the 1st day 0:00:00 of this month , ignored if '--from/-f' or '--to/-t' or '--week/-w' is given"`
+	Title    []string          `help:"filter by titles"`
+	Tag      bool              `default:"false" help:"if set, --title 'book' queries all activities with tag 'book', including activities with title starts with 'book: ' (here, book is the tag derived from title). Also, activities will be aggregated by tag instead of by title"`
+	Match    string            `default:"any" enum:"any,all,none" help:"With '--tag', query activities with any, all, or none of the tags given by --title"`
+	Round    time.Duration     `default:"15m" help:"Billing report only, round up duration of each activity to multiple of it before multiplied by rate"`
+	Billable *bool             `negatable:"" help:"If set, only report billable activities, or only non-billable ones if '--no-billable'"`
+	Where    map[string]string `placeholder:"KEY=VALUE" help:"Only report activities with the metadata, repeatable"`
+	ByMeta   string            `help:"Aggregate activities by value of the metadata key instead of by title"`
 }
 
 func (c *ReportCmd) Run(ss store.Store) error {
@@ -207,19 +617,48 @@ func (c *ReportCmd) Run(ss store.Store) error {
 	start := time.Date(now.Year(), now.Month(), now.Day()-int(c.From), 0, 0, 0, 0, time.Local).UTC()
 	end := time.Date(now.Year(), now.Month(), now.Day()-int(c.To), 23, 59, 59, 0, time.Local).UTC()
 
+	if c.Type == "goals" {
+		// goals are always reported at now, or at the end of '@today - To'
+		at := now
+		if c.To > 0 {
+			at = end
+		}
+		return reportGoals(ss, at)
+	} else if c.Type == "budget" {
+		return reportBudgets(ss)
+	}
+
 	var arg *store.QueryArg
 	if c.Tag {
-		arg = store.NewTagArg(c.Title)
+		arg = store.NewTagMatchArg(c.Title, tagMatches[c.Match])
 	} else {
 		arg = store.NewTitleArg(c.Title)
 	}
+	if c.Billable != nil {
+		arg = arg.WithBillable(*c.Billable)
+	}
+	for key, value := range c.Where {
+		arg = arg.WithMeta(key, value)
+	}
 	activities, err := ss.Closed(start, end, arg)
 	if err != nil {
 		return err
 	}
 
-	var keyF func(*store.ClosedActivity) string
-	if c.Tag {
+	if c.Type == "billing" {
+		rates, err := ss.Rates()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(view.NewBilling(activities, rates, nil, c.Round))
+		return nil
+	}
+
+	var keyF view.KeyFunc
+	if c.ByMeta != "" {
+		keyF = view.MetaKey(c.ByMeta)
+	} else if c.Tag {
 		keyF = (*store.ClosedActivity).Tag
 	}
 	view, err := view.Render(activities, c.Type, keyF)
@@ -233,80 +672,439 @@ func (c *ReportCmd) Run(ss store.Store) error {
 	return nil
 }
 
-type ServerCmd struct {
-	Addr string `arg:"" help:"Address to which the server listens"`
-}
-
-func (c *ServerCmd) Run(ss store.Store) error {
-	env := server.Env{Store: ss}
-	return env.Run(c.Addr)
-}
-
-type AddCmd struct {
-	Title string   `arg:"" optional:"" name:"title" help:"Title of the activity. Choose from recent titles interactively if not given"`
-	Start string   `required:"" help:"Start time of activity, accpets 'HH:mm', 'dd HH:mm', 'MM-dd HH:mm' or 'yyyy-MM-dd HH:mm'"`
-	End   string   `required:"" help:"End time of activity, accepts the same formats as Start"`
-	Notes []string `help:"Notes of the activity, each input as a line. If a single '-' is given, read from stdin"`
+var tagMatches = map[string]store.TagMatch{
+	"any":  store.AnyTag,
+	"all":  store.AllTags,
+	"none": store.NoneTags,
 }
 
-func (c *AddCmd) Run(ss store.Store) error {
-	title, err := chooseTitleAsNeed(c.Title, ss)
+func reportGoals(ss store.Store, at time.Time) error {
+	goals, err := ss.Goals()
 	if err != nil {
 		return err
 	}
 
-	notes, err := getNotes(c.Notes)
-	if err != nil {
-		return err
+	if len(goals) == 0 {
+		fmt.Println("No goals.")
+		return nil
 	}
 
-	start, err := parseImportTime(c.Start)
-	if err != nil {
-		return err
+	tags := make([]string, 0, len(goals))
+	for _, goal := range goals {
+		tags = append(tags, goal.Tag)
 	}
 
-	end, err := parseImportTime(c.End)
+	activities, err := ss.Closed(at.Add(-view.GoalLookback).UTC(), at.UTC(), store.NewTagArg(tags))
 	if err != nil {
 		return err
 	}
 
-	activity := store.ClosedActivity{&store.OpenActivity{title, start.UTC(), notes}, end.UTC()}
-	return ss.Add(&activity)
+	fmt.Println(view.NewGoals(goals, activities, at))
+	return nil
 }
 
-// helper functions
-var errCannotReadIndex error = errors.New("cannot read index")
-var errInvalidIndex error = errors.New("invalid index")
-var errNothingToChoose error = errors.New("candidates is empty")
-
-const DEFAULT_LIMIT uint8 = 5
+func reportBudgets(ss store.Store) error {
+	budgets, err := ss.Budgets()
+	if err != nil {
+		return err
+	}
 
-func chooseTitleAsNeed(title string, ss store.Store) (string, error) {
-	if title != "" {
-		return title, nil
+	if len(budgets) == 0 {
+		fmt.Println("No budgets.")
+		return nil
 	}
 
-	titles, err := ss.RecentTitles(DEFAULT_LIMIT)
-	if err != nil {
-		return "", nil
+	statuses := make(view.Budgets, 0, len(budgets))
+	for i := range budgets {
+		used, err := ss.BudgetUsage(&budgets[i])
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, store.BudgetStatus{Budget: budgets[i], Used: used})
 	}
 
-	return chooseString(titles)
+	fmt.Println(statuses)
+	return nil
 }
 
-func readToEOF() (string, error) {
-	var b strings.Builder
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		fmt.Fprintln(&b, scanner.Text())
+type TagsCmd struct{}
+
+func (c *TagsCmd) Run(ss store.Store) error {
+	tags, err := ss.Tags()
+	if err != nil {
+		return err
 	}
 
-	return b.String(), scanner.Err()
+	for _, tag := range tags {
+		fmt.Printf("%s: %s (%d activities)\n", tag.Name, view.DurS(tag.Total), tag.Count)
+	}
+	return nil
 }
 
-func chooseString(candidates []string) (string, error) {
-	if len(candidates) == 0 {
-		return "", errNothingToChoose
+type ServerCmd struct {
+	Addr          string   `arg:"" help:"Address to which the server listens, or path of a unix socket prefixed by 'unix:', for example 'unix:/run/user/1000/ticktock.sock'"`
+	Webhook       []string `placeholder:"URL" help:"URL to which events of activities started, closed or added are POSTed as JSON, repeatable"`
+	WebhookSecret string   `env:"TICKTOCK_WEBHOOK_SECRET" help:"Secret to sign webhook requests with HMAC-SHA256 in header X-Ticktock-Signature"`
+	Auth          bool     `help:"Require API requests to be authenticated by tokens, see 'token create'. The web interface requires them as well unless --basic-auth or --multi-user is given, so it is for API clients only"`
+	BasicAuth     string   `env:"TICKTOCK_BASIC_AUTH" placeholder:"USER:PASSWORD" help:"Require HTTP basic auth for the web interface, which also grants read-write access to the API. Implies --auth"`
+	MultiUser     bool     `help:"Serve users added by 'user add', each with their own activities. They sign in to the web interface, and the API requires their tokens, see 'token create --user'. Implies --auth"`
+	TlsCert       string   `type:"path" placeholder:"FILE" help:"Certificate file to serve HTTPS, requires --tls-key"`
+	TlsKey        string   `type:"path" placeholder:"FILE" help:"Private key file of the certificate"`
+	TlsSelfSigned bool     `help:"Generate a self-signed certificate and its key if they don't exist, at --tls-cert and --tls-key, default to server.crt and server.key in the directory of the db file"`
+	SocketMode    string   `default:"0600" help:"Permissions of the unix socket in octal"`
+	LogLevel      string   `default:"info" enum:"debug,info,warn,error" env:"TICKTOCK_LOG_LEVEL" help:"Least level of messages logged to stderr as JSON lines, valid values are: debug, info, warn, error. Requests are logged at info, those of /healthz, /readyz and /metrics at debug"`
+}
+
+func (c *ServerCmd) Run(ss store.Store) error {
+	if c.BasicAuth != "" && !strings.Contains(c.BasicAuth, ":") {
+		return errors.New("basic auth should be in format 'user:password'")
+	}
+	if c.BasicAuth != "" && c.MultiUser {
+		return errors.New("--basic-auth can not be used with --multi-user, users sign in with their passwords")
+	}
+
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode %s: %w", c.SocketMode, err)
+	}
+	level, err := server.ParseLogLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	if c.TlsSelfSigned {
+		if err := c.selfSigned(); err != nil {
+			return err
+		}
+	}
+	if (c.TlsCert == "") != (c.TlsKey == "") {
+		return errors.New("both --tls-cert and --tls-key are required to serve HTTPS")
+	}
+
+	env := server.Env{
+		Store:      ss,
+		Auth:       c.Auth,
+		BasicAuth:  c.BasicAuth,
+		MultiUser:  c.MultiUser,
+		TLSCert:    c.TlsCert,
+		TLSKey:     c.TlsKey,
+		SocketMode: fs.FileMode(mode),
+		LogLevel:   level,
+	}
+	for _, url := range c.Webhook {
+		env.Webhooks = append(env.Webhooks, server.Webhook{URL: url, Secret: c.WebhookSecret})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return env.Run(ctx, c.Addr)
+}
+
+// selfSigned generates the self-signed certificate and its key if both of them do not exist.
+func (c *ServerCmd) selfSigned() error {
+	dir := filepath.Dir(Cli.Db)
+	if c.TlsCert == "" {
+		c.TlsCert = filepath.Join(dir, "server.crt")
+	}
+	if c.TlsKey == "" {
+		c.TlsKey = filepath.Join(dir, "server.key")
+	}
+
+	_, certErr := os.Stat(c.TlsCert)
+	_, keyErr := os.Stat(c.TlsKey)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+	if certErr == nil || keyErr == nil {
+		return fmt.Errorf("only one of %s and %s exists, remove it to generate both, or give the other one", c.TlsCert, c.TlsKey)
+	}
+
+	hosts := server.CertHosts(c.Addr)
+	if err := server.GenerateCert(c.TlsCert, c.TlsKey, hosts); err != nil {
+		return fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Generated self-signed certificate %s for %s\n", c.TlsCert, strings.Join(hosts, ", "))
+	return nil
+}
+
+type AddCmd struct {
+	Title    string            `arg:"" optional:"" name:"title" help:"Title of the activity. Choose from recent titles interactively if not given"`
+	Start    string            `required:"" help:"Start time of activity, accpets 'HH:mm', 'dd HH:mm', 'MM-dd HH:mm' or 'yyyy-MM-dd HH:mm'"`
+	End      string            `required:"" help:"End time of activity, accepts the same formats as Start"`
+	Notes    []string          `help:"Notes of the activity, each input as a line. If a single '-' is given, read from stdin"`
+	Billable *bool             `negatable:"" help:"Whether the activity is billable, default to the billable default of its tag"`
+	Meta     map[string]string `placeholder:"KEY=VALUE" help:"Metadata of the activity, repeatable"`
+	Tags     []string          `name:"tag" help:"Tags of the activity in addition to the one derived from title, repeatable"`
+}
+
+func (c *AddCmd) Run(ss store.Store) error {
+	title, err := chooseTitleAsNeed(c.Title, ss)
+	if err != nil {
+		return err
+	}
+
+	notes, err := getNotes(c.Notes)
+	if err != nil {
+		return err
+	}
+
+	start, err := parseImportTime(c.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseImportTime(c.End)
+	if err != nil {
+		return err
+	}
+
+	activity := store.ClosedActivity{
+		OpenActivity: &store.OpenActivity{Title: title, Start: start.UTC(), Notes: notes, Meta: c.Meta, Tags: c.Tags},
+		End:          end.UTC(),
+	}
+	if activity.Billable, err = billableOrDefault(ss, c.Billable, activity.OpenActivity); err != nil {
+		return err
+	}
+	return ss.Add(&activity)
+}
+
+// billableOrDefault returns *billable if not nil, otherwise the billable default of the activity.
+func billableOrDefault(ss store.Store, billable *bool, activity *store.OpenActivity) (bool, error) {
+	if billable != nil {
+		return *billable, nil
+	}
+
+	defaults, err := ss.BillableDefaults()
+	if err != nil {
+		return false, err
+	}
+
+	return store.BillableDefault(defaults, activity), nil
+}
+
+type DeleteCmd struct {
+	Id int64 `arg:"" help:"Id of the activity, shown by 'last' or 'log'"`
+}
+
+func (c *DeleteCmd) Run(ss store.Store) error {
+	activity, err := ss.Get(c.Id)
+	if err != nil {
+		return err
+	}
+
+	if err := ss.Delete(c.Id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted: %s\n", activity.Title)
+	return nil
+}
+
+type EditCmd struct {
+	Id       int64             `arg:"" optional:"" help:"Id of the activity, shown by 'last'. Edit the latest closed activity if not given"`
+	Title    string            `help:"New title of the activity"`
+	Start    string            `help:"New start time of the activity, accepts the same formats as 'add --start'"`
+	End      string            `help:"New end time of the activity, accepts the same formats as 'add --start'"`
+	Notes    []string          `help:"New notes of the activity, replaces the existing ones, each input as a line. If a single '-' is given, read from stdin"`
+	Billable *bool             `negatable:"" help:"Whether the activity is billable"`
+	Meta     map[string]string `placeholder:"KEY=VALUE" help:"Metadata to set, repeatable. An empty value removes the key"`
+	Tags     []string          `name:"tag" help:"Tags to add, repeatable"`
+	Untag    []string          `help:"Tags to remove, repeatable. The tag derived from title can not be removed"`
+}
+
+func (c *EditCmd) Run(ss store.Store) error {
+	var activity *store.ClosedActivity
+	var err error
+	if c.Id == 0 {
+		activity, err = ss.LastClosed("")
+		if err == nil && activity == nil {
+			err = store.ErrNotFound
+		}
+	} else {
+		activity, err = ss.Get(c.Id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Title != "" {
+		activity.Title = c.Title
+	}
+	if c.Start != "" {
+		start, err := parseImportTime(c.Start)
+		if err != nil {
+			return err
+		}
+		activity.Start = start.UTC()
+	}
+	if c.End != "" {
+		if activity.End.IsZero() {
+			return errors.New("activity is ongoing, use 'close' to end it")
+		}
+		end, err := parseImportTime(c.End)
+		if err != nil {
+			return err
+		}
+		activity.End = end.UTC()
+	}
+	if c.Notes != nil {
+		if activity.Notes, err = getNotes(c.Notes); err != nil {
+			return err
+		}
+	}
+	if c.Billable != nil {
+		activity.Billable = *c.Billable
+	}
+	activity.Tags = append(activity.Tags, c.Tags...)
+	for _, untag := range c.Untag {
+		tags := make([]string, 0, len(activity.Tags))
+		for _, tag := range activity.Tags {
+			if tag != untag {
+				tags = append(tags, tag)
+			}
+		}
+		activity.Tags = tags
+	}
+	for key, value := range c.Meta {
+		if value == "" {
+			delete(activity.Meta, key)
+		} else {
+			if activity.Meta == nil {
+				activity.Meta = make(map[string]string)
+			}
+			activity.Meta[key] = value
+		}
+	}
+
+	if !activity.End.IsZero() && activity.End.Before(activity.Start) {
+		return errors.New("end time is before start time")
+	}
+
+	if err := ss.Update(activity); err != nil {
+		return err
+	}
+
+	if activity.End.IsZero() {
+		fmt.Printf("(Edited: %s)\n", activity.Title)
+	} else {
+		fmt.Println(activity)
+	}
+	return nil
+}
+
+type BillableCmd struct {
+	Set  BillableSetCmd  `cmd:"" help:"Set whether activities of a tag are billable by default"`
+	List BillableListCmd `cmd:"" help:"List billable defaults of tags"`
+}
+
+type BillableSetCmd struct {
+	Tag      string `arg:"" help:"Tag of activities"`
+	Billable string `arg:"" enum:"true,false" help:"true or false"`
+}
+
+func (c *BillableSetCmd) Run(ss store.Store) error {
+	return ss.SetBillableDefault(c.Tag, c.Billable == "true")
+}
+
+type BillableListCmd struct{}
+
+func (c *BillableListCmd) Run(ss store.Store) error {
+	defaults, err := ss.BillableDefaults()
+	if err != nil {
+		return err
+	}
+
+	for tag, billable := range defaults {
+		fmt.Printf("%s: %t\n", tag, billable)
+	}
+	return nil
+}
+
+type GoalCmd struct {
+	Set  GoalSetCmd  `cmd:"" help:"Set the goal of a tag, replaces the existing one of the same period"`
+	List GoalListCmd `cmd:"" help:"List goals"`
+	Rm   GoalRmCmd   `cmd:"" help:"Remove the goal of a tag"`
+}
+
+type GoalSetCmd struct {
+	Tag    string        `arg:"" help:"Tag of the goal"`
+	Target time.Duration `arg:"" help:"Time to spend in each period, for example '30m', '3h'"`
+	Per    string        `default:"day" enum:"day,week" help:"Period of the goal, valid values are: day, week"`
+}
+
+func (c *GoalSetCmd) Run(ss store.Store) error {
+	if c.Target <= 0 {
+		return errors.New("target should be positive")
+	}
+
+	return ss.SetGoal(&store.Goal{Tag: c.Tag, Period: store.GoalPeriod(c.Per), Target: c.Target})
+}
+
+type GoalListCmd struct{}
+
+func (c *GoalListCmd) Run(ss store.Store) error {
+	goals, err := ss.Goals()
+	if err != nil {
+		return err
+	}
+
+	for _, goal := range goals {
+		fmt.Printf("%s: %s per %s\n", goal.Tag, view.DurS(goal.Target), goal.Period)
+	}
+	return nil
+}
+
+type GoalRmCmd struct {
+	Tag string `arg:"" help:"Tag of the goal"`
+	Per string `default:"day" enum:"day,week" help:"Period of the goal, valid values are: day, week"`
+}
+
+func (c *GoalRmCmd) Run(ss store.Store) error {
+	return ss.RemoveGoal(c.Tag, store.GoalPeriod(c.Per))
+}
+
+// helper functions
+var errCannotReadIndex error = errors.New("cannot read index")
+var errInvalidIndex error = errors.New("invalid index")
+var errNothingToChoose error = errors.New("candidates is empty")
+
+const DEFAULT_LIMIT = 5
+
+func chooseTitleAsNeed(title string, ss store.Store) (string, error) {
+	if title != "" {
+		return title, nil
+	}
+
+	// fuzzy finder over all titles, or a numbered list of a few when not interactive
+	limit := DEFAULT_LIMIT
+	if isTerminal() {
+		limit = 0
+	}
+
+	titles, err := ss.Titles(store.RankFrecency, "", time.Now(), limit)
+	if err != nil {
+		return "", nil
+	}
+
+	if isTerminal() {
+		return pickTitle(titles)
+	}
+	return chooseString(titles)
+}
+
+func readToEOF() (string, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fmt.Fprintln(&b, scanner.Text())
+	}
+
+	return b.String(), scanner.Err()
+}
+
+func chooseString(candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		return "", errNothingToChoose
 	}
 
 	for i, s := range candidates {
@@ -344,6 +1142,432 @@ func getNotes(input []string) (string, error) {
 	}
 }
 
+type BudgetCmd struct {
+	Set  BudgetSetCmd  `cmd:"" help:"Set the budget of a title or tag, replaces the existing one"`
+	List BudgetListCmd `cmd:"" help:"List budgets"`
+	Rm   BudgetRmCmd   `cmd:"" help:"Remove the budget of a title or tag"`
+}
+
+type BudgetSetCmd struct {
+	Name  string        `arg:"" help:"Title, or tag if '--tag' is set, of the budget"`
+	Limit time.Duration `arg:"" help:"Total time of the budget, for example '40h'"`
+	Tag   bool          `default:"false" help:"If set, the budget counts all activities of tag 'name'"`
+	Since string        `help:"Only count activities from the day, in format 'yyyy-MM-dd'"`
+	Until string        `help:"Only count activities to the end of the day, in format 'yyyy-MM-dd'"`
+}
+
+func (c *BudgetSetCmd) Run(ss store.Store) error {
+	if c.Limit <= 0 {
+		return errors.New("limit should be positive")
+	}
+
+	since, err := parseDate(c.Since)
+	if err != nil {
+		return err
+	}
+	until, err := parseDate(c.Until)
+	if err != nil {
+		return err
+	}
+
+	budget := store.Budget{Name: c.Name, IsTag: c.Tag, Limit: c.Limit, From: since}
+	if !until.IsZero() {
+		budget.To = until.Add(24*time.Hour - time.Second)
+	}
+
+	return ss.SetBudget(&budget)
+}
+
+type BudgetListCmd struct{}
+
+func (c *BudgetListCmd) Run(ss store.Store) error {
+	budgets, err := ss.Budgets()
+	if err != nil {
+		return err
+	}
+
+	for i := range budgets {
+		fmt.Printf("%s: %s\n", &budgets[i], store.Hours(budgets[i].Limit))
+	}
+	return nil
+}
+
+type BudgetRmCmd struct {
+	Name string `arg:"" help:"Title, or tag if '--tag' is set, of the budget"`
+	Tag  bool   `default:"false" help:"If set, remove the budget of tag 'name'"`
+}
+
+func (c *BudgetRmCmd) Run(ss store.Store) error {
+	return ss.RemoveBudget(c.Name, c.Tag)
+}
+
+type RateCmd struct {
+	Set  RateSetCmd  `cmd:"" help:"Set the hourly rate of a title or tag"`
+	List RateListCmd `cmd:"" help:"List rates"`
+	Rm   RateRmCmd   `cmd:"" help:"Remove the rate of a title or tag"`
+}
+
+type RateSetCmd struct {
+	Name     string `arg:"" help:"Title, or tag if '--tag' is set, the rate applies to"`
+	Amount   string `arg:"" help:"Amount per hour, at most 2 decimals, for example '120.50'"`
+	Currency string `required:"" help:"Currency of the amount, for example 'USD'"`
+	Tag      bool   `default:"false" help:"If set, the rate applies to all activities of tag 'name', unless there is a rate of the activity's title"`
+	Since    string `help:"The rate is effective from the day, in format 'yyyy-MM-dd'. If not given, effective since ever"`
+}
+
+func (c *RateSetCmd) Run(ss store.Store) error {
+	amount, err := store.ParseAmount(c.Amount)
+	if err != nil {
+		return err
+	}
+
+	since, err := parseDate(c.Since)
+	if err != nil {
+		return err
+	}
+
+	return ss.SetRate(&store.Rate{
+		Name:     c.Name,
+		IsTag:    c.Tag,
+		Amount:   amount,
+		Currency: c.Currency,
+		Since:    since,
+	})
+}
+
+type RateListCmd struct{}
+
+func (c *RateListCmd) Run(ss store.Store) error {
+	rates, err := ss.Rates()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rates {
+		kind := "title"
+		if r.IsTag {
+			kind = "tag"
+		}
+		since := "ever"
+		if !r.Since.IsZero() {
+			since = r.Since.Local().Format(time.DateOnly)
+		}
+
+		fmt.Printf("%s (%s): %s %s/h since %s\n", r.Name, kind, store.FormatAmount(r.Amount), r.Currency, since)
+	}
+	return nil
+}
+
+type RateRmCmd struct {
+	Name  string `arg:"" help:"Title, or tag if '--tag' is set, of the rate"`
+	Tag   bool   `default:"false" help:"If set, remove the rate of tag 'name'"`
+	Since string `help:"The day the rate is effective from, in format 'yyyy-MM-dd'"`
+}
+
+func (c *RateRmCmd) Run(ss store.Store) error {
+	since, err := parseDate(c.Since)
+	if err != nil {
+		return err
+	}
+
+	return ss.RemoveRate(c.Name, c.Tag, since)
+}
+
+type TokenCmd struct {
+	Create TokenCreateCmd `cmd:"" help:"Create an API token of the server, the secret is shown only once"`
+	List   TokenListCmd   `cmd:"" help:"List API tokens"`
+	Revoke TokenRevokeCmd `cmd:"" help:"Revoke an API token"`
+}
+
+type TokenCreateCmd struct {
+	Name  string `arg:"" help:"Name of the token, for example the device or service using it"`
+	Scope string `default:"read" enum:"read,write" help:"Scope of the token, 'read' allows reading only, 'write' allows changing activities as well"`
+	User  string `help:"Create the token of the user, for the server in multi-user mode"`
+}
+
+func (c *TokenCreateCmd) Run(ss store.Store) error {
+	ss, err := storeOf(ss, c.User)
+	if err != nil {
+		return err
+	}
+	secret, err := store.NewTokenSecret()
+	if err != nil {
+		return err
+	}
+
+	token := store.Token{Name: c.Name, Scope: store.TokenScope(c.Scope), Created: time.Now().UTC()}
+	if err := ss.AddToken(&token, store.HashToken(secret)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Token %s (%s) created, it is not shown again:\n%s\n", token.Name, token.Scope, secret)
+	return nil
+}
+
+type TokenListCmd struct {
+	User string `help:"List tokens of the user, for the server in multi-user mode"`
+}
+
+func (c *TokenListCmd) Run(ss store.Store) error {
+	ss, err := storeOf(ss, c.User)
+	if err != nil {
+		return err
+	}
+	tokens, err := ss.Tokens()
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		fmt.Printf("%s (%s), created at %s\n", token.Name, token.Scope, token.Created.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+type TokenRevokeCmd struct {
+	Name string `arg:"" help:"Name of the token"`
+	User string `help:"Revoke the token of the user, for the server in multi-user mode"`
+}
+
+func (c *TokenRevokeCmd) Run(ss store.Store) error {
+	ss, err := storeOf(ss, c.User)
+	if err != nil {
+		return err
+	}
+	return ss.RevokeToken(c.Name)
+}
+
+// storeOf returns the Store of the user of given name, or ss itself if name is empty.
+func storeOf(ss store.Store, name string) (store.Store, error) {
+	if name == "" {
+		return ss, nil
+	}
+
+	users, err := ss.Users()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Name == name {
+			return ss.ForUser(user.Id), nil
+		}
+	}
+	return nil, fmt.Errorf("user %s: %w", name, store.ErrNotFound)
+}
+
+type UserCmd struct {
+	Add    UserAddCmd    `cmd:"" help:"Add a user, the password is read from the terminal, or from stdin if it is not a terminal"`
+	List   UserListCmd   `cmd:"" help:"List users"`
+	Passwd UserPasswdCmd `cmd:"" help:"Change the password of a user"`
+	Rm     UserRmCmd     `cmd:"" help:"Remove a user and its tokens, activities of the user are kept"`
+}
+
+type UserAddCmd struct {
+	Name  string `arg:"" help:"Name of the user to sign in with"`
+	Admin bool   `help:"Allow the user to see the team report of all users"`
+}
+
+func (c *UserAddCmd) Run(ss store.Store) error {
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	user := store.User{Name: c.Name, Admin: c.Admin}
+	if err := ss.AddUser(&user, password); err != nil {
+		return err
+	}
+	fmt.Printf("User %s added\n", user.Name)
+	return nil
+}
+
+type UserListCmd struct{}
+
+func (c *UserListCmd) Run(ss store.Store) error {
+	users, err := ss.Users()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		admin := ""
+		if user.Admin {
+			admin = " (admin)"
+		}
+		fmt.Printf("%s%s, created at %s\n", user.Name, admin, user.Created.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+type UserPasswdCmd struct {
+	Name string `arg:"" help:"Name of the user"`
+}
+
+func (c *UserPasswdCmd) Run(ss store.Store) error {
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	return ss.SetPassword(c.Name, password)
+}
+
+type UserRmCmd struct {
+	Name string `arg:"" help:"Name of the user"`
+}
+
+func (c *UserRmCmd) Run(ss store.Store) error {
+	return ss.RemoveUser(c.Name)
+}
+
+// readPassword reads a new password from the terminal twice, or the first line of stdin if it is
+// not a terminal.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", errors.New("no password in stdin")
+		}
+		if scanner.Text() == "" {
+			return "", errors.New("empty password")
+		}
+		return scanner.Text(), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return "", errors.New("empty password")
+	}
+	fmt.Fprint(os.Stderr, "Password again: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(again) != string(password) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(password), nil
+}
+
+type MergeCmd struct {
+	Other       string `arg:"" type:"existingfile" help:"Path of the db file of another device, for example, a copy synced by a file share"`
+	NewDeviceId bool   `help:"Assign a new device id to this db file first, which is needed once if it is a copy of the other one"`
+}
+
+func (c *MergeCmd) Run(ss store.Store) error {
+	if c.NewDeviceId {
+		if err := ss.NewDeviceId(); err != nil {
+			return err
+		}
+	}
+
+	report, err := ss.Merge(c.Other)
+	if err != nil {
+		return err
+	}
+
+	printMergeReport(c.Other, Cli.Db, report)
+	return nil
+}
+
+type SyncCmd struct {
+	Other       string `arg:"" type:"existingfile" help:"Path of the db file of another device, for example, a copy synced by a file share"`
+	NewDeviceId bool   `help:"Assign a new device id to this db file first, which is needed once if it is a copy of the other one"`
+}
+
+func (c *SyncCmd) Run(ss store.Store) error {
+	if err := (&MergeCmd{c.Other, c.NewDeviceId}).Run(ss); err != nil {
+		return err
+	}
+
+	// The other db file is written but not migrated, merging into this one already checked its version.
+	other, err := store.OpenSqliteStore(c.Other)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", c.Other, err)
+	}
+	defer other.Close()
+
+	report, err := other.Merge(Cli.Db)
+	if err != nil {
+		return err
+	}
+	printMergeReport(Cli.Db, c.Other, report)
+	return nil
+}
+
+// printMergeReport prints the result of merging db 'from' into db 'to'.
+func printMergeReport(from, to string, report *store.MergeReport) {
+	last := "first merge"
+	if !report.LastSynced.IsZero() {
+		last = "last merged at " + report.LastSynced.Local().Format(time.DateTime)
+	}
+	fmt.Printf("Merged %s into %s: %d added, %d updated, %d unchanged (%s)\n",
+		from, to, len(report.Added), len(report.Updated), report.Unchanged, last)
+
+	for i := range report.Conflicts {
+		conflict := &report.Conflicts[i]
+		fmt.Printf("Conflicting edits, kept the one in %s:\n    %s\n  instead of:\n    %s\n",
+			to, logLine(&conflict.Here), logLine(&conflict.Other))
+	}
+	for i := range report.Overlaps {
+		overlap := &report.Overlaps[i]
+		fmt.Printf("Overlapping:\n    %s\n    %s\n", logLine(&overlap.Activity), logLine(&overlap.Existing))
+	}
+}
+
+type InvoiceCmd struct {
+	Since  string        `required:"" help:"Invoice activities from the day, in format 'yyyy-MM-dd'"`
+	Until  string        `required:"" help:"Invoice activities to the end of the day, in format 'yyyy-MM-dd'"`
+	Client []string      `help:"Only invoice activities of these clients (tags)"`
+	Format string        `default:"csv" enum:"csv,markdown" help:"Format of the line items, valid values are: csv, markdown"`
+	Round  time.Duration `default:"15m" help:"Round up duration of each activity to multiple of it before multiplied by rate"`
+}
+
+func (c *InvoiceCmd) Run(ss store.Store) error {
+	since, err := parseDate(c.Since)
+	if err != nil {
+		return err
+	}
+	until, err := parseDate(c.Until)
+	if err != nil {
+		return err
+	}
+
+	activities, err := ss.Closed(since.UTC(), until.Add(24*time.Hour-time.Second).UTC(), store.NewTagArg(c.Client))
+	if err != nil {
+		return err
+	}
+
+	rates, err := ss.Rates()
+	if err != nil {
+		return err
+	}
+
+	billing := view.NewBilling(activities, rates, c.Client, c.Round)
+	if c.Format == "markdown" {
+		return billing.WriteMarkdown(os.Stdout)
+	}
+	return billing.WriteCSV(os.Stdout)
+}
+
+// parseDate parses local date in format 'yyyy-MM-dd', empty string is parsed as zero time.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
+
 const IMPORT_FULL_DT string = "2006-01-02 15:04"
 
 // parseImportTime accepts time in all the following formats:
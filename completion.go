@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/cranej/ticktock/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+// titleValues and tagValues are names of flags and arguments completed by titles or tags.
+var (
+	titleValues = map[string]bool{"title": true}
+	tagValues   = map[string]bool{"tag": true, "untag": true, "client": true}
+)
+
+// dequote removes shell quoting of a partial word, as "'work: cl" or work:\ cl.
+func dequote(word string) string {
+	if strings.HasPrefix(word, "'") || strings.HasPrefix(word, `"`) {
+		return strings.Trim(word, word[:1])
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range word {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// findFlag finds flag by '--name', '--no-name' or '-s' in node and its ancestors.
+func findFlag(node *kong.Node, word string) *kong.Flag {
+	name, _, _ := strings.Cut(word, "=")
+	for n := node; n != nil; n = n.Parent {
+		for _, flag := range n.Flags {
+			if name == "--"+flag.Name ||
+				(flag.Tag.Negatable && name == "--no-"+flag.Name) ||
+				(flag.Short != 0 && name == "-"+string(flag.Short)) {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
+// completeValue returns candidates of the flag or argument value.
+func completeValue(value *kong.Value, ss store.Store) ([]string, error) {
+	if value.Enum != "" {
+		candidates := make([]string, 0)
+		for _, e := range strings.Split(value.Enum, ",") {
+			candidates = append(candidates, strings.TrimSpace(e))
+		}
+		return candidates, nil
+	}
+
+	if titleValues[value.Name] {
+		return ss.Titles(store.RankFrecency, "", time.Now(), 0)
+	}
+
+	if tagValues[value.Name] {
+		usages, err := ss.Tags()
+		if err != nil {
+			return nil, err
+		}
+
+		tags := make([]string, 0, len(usages))
+		for _, usage := range usages {
+			tags = append(tags, usage.Name)
+		}
+		return tags, nil
+	}
+
+	return nil, nil
+}
+
+// complete returns candidates of the last word of command line 'words' (without program name).
+func complete(root *kong.Node, words []string, ss store.Store) ([]string, error) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := dequote(words[len(words)-1])
+
+	node := root
+	positional := 0
+	var pending *kong.Flag // flag waiting for its value
+	for _, word := range words[:len(words)-1] {
+		if pending != nil {
+			pending = nil
+			continue
+		}
+
+		if strings.HasPrefix(word, "-") && len(word) > 1 {
+			if flag := findFlag(node, word); flag != nil && !flag.IsBool() && !flag.IsCounter() && !strings.Contains(word, "=") {
+				pending = flag
+			}
+			continue
+		}
+
+		child := (*kong.Node)(nil)
+		if positional == 0 {
+			for _, c := range node.Children {
+				if c.Type == kong.CommandNode && c.Name == word {
+					child = c
+				}
+			}
+		}
+		if child != nil {
+			node = child
+		} else {
+			positional++
+		}
+	}
+
+	var candidates []string
+	var err error
+	switch {
+	case pending != nil:
+		candidates, err = completeValue(pending.Value, ss)
+	case strings.HasPrefix(current, "--") && strings.Contains(current, "="):
+		name, value, _ := strings.Cut(current, "=")
+		if flag := findFlag(node, name); flag != nil {
+			var values []string
+			values, err = completeValue(flag.Value, ss)
+			for _, v := range values {
+				candidates = append(candidates, name+"="+v)
+			}
+		}
+		current = name + "=" + value
+	case strings.HasPrefix(current, "-"):
+		for n := node; n != nil; n = n.Parent {
+			for _, flag := range n.Flags {
+				if flag.Hidden {
+					continue
+				}
+				candidates = append(candidates, "--"+flag.Name)
+				if flag.Tag.Negatable {
+					candidates = append(candidates, "--no-"+flag.Name)
+				}
+			}
+		}
+		sort.Strings(candidates)
+	case positional == 0 && len(node.Children) > 0:
+		for _, c := range node.Children {
+			if c.Type == kong.CommandNode && !c.Hidden {
+				candidates = append(candidates, c.Name)
+			}
+		}
+	default:
+		if positional < len(node.Positional) {
+			candidates, err = completeValue(node.Positional[positional], ss)
+		} else if n := len(node.Positional); n > 0 && node.Positional[n-1].IsSlice() {
+			candidates, err = completeValue(node.Positional[n-1], ss)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if strings.HasPrefix(c, current) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// completionScript returns the completion script of the shell, which calls '__complete' with
+// words of the command line.
+func completionScript(shell, program string) (string, error) {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return "", fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	return strings.ReplaceAll(script, "PROGRAM", program), nil
+}
+
+const bashCompletion = `# bash completion of PROGRAM, source it in ~/.bashrc:
+#   source <(PROGRAM completion bash)
+_PROGRAM() {
+    local cur words cword
+    if declare -F _get_comp_words_by_ref >/dev/null; then
+        # titles like 'work: clientA' have colons
+        _get_comp_words_by_ref -n : cur words cword
+    else
+        cur=${COMP_WORDS[COMP_CWORD]} words=("${COMP_WORDS[@]}") cword=$COMP_CWORD
+    fi
+
+    local IFS=$'\n'
+    local candidates=($(PROGRAM __complete -- "${words[@]:1:cword}" 2>/dev/null))
+    COMPREPLY=()
+    local c
+    for c in "${candidates[@]}"; do
+        if [[ $c == --*= ]]; then
+            COMPREPLY+=("$c")
+        else
+            COMPREPLY+=("$(printf '%q' "$c")")
+        fi
+    done
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == --*= ]]; then
+        compopt -o nospace
+    fi
+    if declare -F __ltrim_colon_completions >/dev/null; then
+        __ltrim_colon_completions "$cur"
+    fi
+}
+complete -F _PROGRAM PROGRAM
+`
+
+const zshCompletion = `#compdef PROGRAM
+# zsh completion of PROGRAM, save it as _PROGRAM in a directory of $fpath, or:
+#   source <(PROGRAM completion zsh)
+_PROGRAM() {
+    local -a candidates
+    candidates=("${(@f)$(PROGRAM __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    compadd -a candidates
+}
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _PROGRAM "$@"
+else
+    compdef _PROGRAM PROGRAM
+fi
+`
+
+const fishCompletion = `# fish completion of PROGRAM, save it in ~/.config/fish/completions/PROGRAM.fish, or:
+#   PROGRAM completion fish | source
+function __PROGRAM_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    PROGRAM __complete -- $tokens[2..-1] 2>/dev/null
+end
+complete -c PROGRAM -f -a '(__PROGRAM_complete)'
+`
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/alecthomas/kong"
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComplete(t *testing.T) {
+	parser, err := kong.New(&Cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := store.NewSqliteStore("file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, title := range []string{"work: clientA: standup", "gym"} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		activity := store.ClosedActivity{OpenActivity: &store.OpenActivity{Title: title, Start: s}, End: s.Add(time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// words are separated by '|'
+	for _, c := range []struct {
+		words string
+		want  string
+	}{
+		{"sta", "start,status"},
+		{"goal|", "set,list,rm"},
+		{"start|", "gym,work: clientA: standup"},
+		{`start|work:\ c`, "work: clientA: standup"},
+		{"start|'work: c", "work: clientA: standup"},
+		{"start|--tag|", "gym,work"},
+		{"start|--ti", ""},
+		{"start|--no-bi", "--no-billable"},
+		{"report|--title|g", "gym"},
+		{"report|--type|d", "detail,dist"},
+		{"report|--type=d", "--type=detail,--type=dist"},
+		{"report|--tag|--title|g", "gym"},
+		{"edit|--untag|", "gym,work"},
+		{"start|gym|", ""},
+		{"__comp", ""},
+	} {
+		words := strings.Split(c.words, "|")
+		candidates, err := complete(parser.Model.Node, words, ss)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(candidates, ","); got != c.want {
+			t.Errorf("%q: got %s, want %s", c.words, got, c.want)
+		}
+	}
+}
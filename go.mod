@@ -6,4 +6,7 @@ require (
 	github.com/alecthomas/kong v0.7.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/term v0.15.0
 )
+
+require golang.org/x/sys v0.15.0 // indirect
@@ -0,0 +1,153 @@
+// Package hook executes user scripts on changes of activities.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Runner executes hooks in Dir: for event 'start', executable '<Dir>/start' and executables in
+// '<Dir>/start.d/' in order of name. Same for other events.
+type Runner struct {
+	Dir string
+	// Timeout of each script, scripts are killed after it.
+	Timeout time.Duration
+}
+
+// payload is the JSON passed to scripts through stdin.
+type payload struct {
+	Event    store.EventType
+	Time     time.Time
+	Activity *store.ClosedActivity
+}
+
+// Error is the failure of a script.
+type Error struct {
+	Script string
+	Err    error
+	Stderr string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("hook %s: %v", e.Script, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Scripts returns paths of executables to run on the event.
+func (r *Runner) Scripts(event store.EventType) ([]string, error) {
+	scripts := make([]string, 0)
+	if r.Dir == "" {
+		return scripts, nil
+	}
+
+	name := filepath.Join(r.Dir, string(event))
+	if executable(name) {
+		scripts = append(scripts, name)
+	}
+
+	entries, err := os.ReadDir(name + ".d")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if path := filepath.Join(name+".d", entry.Name()); executable(path) {
+			names = append(names, path)
+		}
+	}
+	sort.Strings(names)
+
+	return append(scripts, names...), nil
+}
+
+func executable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// Run executes scripts of the event one by one, returns errors of failed ones.
+// Scripts get the event as JSON from stdin, and environment variables TICKTOCK_EVENT,
+// TICKTOCK_ID, TICKTOCK_TITLE, TICKTOCK_START and TICKTOCK_END (empty if open).
+func (r *Runner) Run(e *store.Event) []error {
+	scripts, err := r.Scripts(e.Type)
+	if err != nil {
+		return []error{err}
+	}
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	input, err := json.Marshal(payload{e.Type, e.Time, e.Activity})
+	if err != nil {
+		return []error{err}
+	}
+
+	env := append(os.Environ(), "TICKTOCK_EVENT="+string(e.Type))
+	if a := e.Activity; a != nil {
+		end := ""
+		if !a.End.IsZero() {
+			end = a.End.Format(time.RFC3339)
+		}
+		env = append(env,
+			"TICKTOCK_ID="+strconv.FormatInt(a.Id, 10),
+			"TICKTOCK_TITLE="+a.Title,
+			"TICKTOCK_START="+a.Start.Format(time.RFC3339),
+			"TICKTOCK_END="+end)
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	errs := make([]error, 0)
+	for _, script := range scripts {
+		if err := run(script, input, env, timeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func run(script string, input []byte, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = env
+	cmd.Stderr = &stderr
+	// in case a child process of the script holds stderr after the script is killed
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return &Error{script, err, strings.TrimSpace(stderr.String())}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package hook
+
+import (
+	"errors"
+	"github.com/cranej/ticktock/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, path, script string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0750); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEvent() *store.Event {
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	return &store.Event{
+		Type:     store.EventClose,
+		Activity: &store.ClosedActivity{OpenActivity: &store.OpenActivity{Id: 3, Title: "work: a", Start: start}, End: start.Add(time.Hour)},
+		Time:     start.Add(time.Hour),
+	}
+}
+
+func TestScripts(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, filepath.Join(dir, "close"), "")
+	writeScript(t, filepath.Join(dir, "close.d/20-b"), "")
+	writeScript(t, filepath.Join(dir, "close.d/10-a"), "")
+	if err := os.WriteFile(filepath.Join(dir, "close.d/30-not-executable"), nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{Dir: dir}
+	scripts, err := r.Scripts(store.EventClose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range scripts {
+		scripts[i], _ = filepath.Rel(dir, scripts[i])
+	}
+	if got, want := strings.Join(scripts, ","), "close,close.d/10-a,close.d/20-b"; got != want {
+		t.Fatalf("Got scripts %s, want %s", got, want)
+	}
+
+	if scripts, err := r.Scripts(store.EventStart); err != nil || len(scripts) != 0 {
+		t.Fatalf("Expects no scripts, got: (%v, %v)", scripts, err)
+	}
+	if scripts, err := (&Runner{}).Scripts(store.EventStart); err != nil || len(scripts) != 0 {
+		t.Fatalf("Expects no scripts, got: (%v, %v)", scripts, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	writeScript(t, filepath.Join(dir, "close"),
+		`echo "$TICKTOCK_EVENT|$TICKTOCK_ID|$TICKTOCK_TITLE|$TICKTOCK_START|$TICKTOCK_END" > `+out+`
+cat >> `+out+`
+`)
+
+	if errs := (&Runner{Dir: dir}).Run(testEvent()); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, input, _ := strings.Cut(string(data), "\n")
+	if want := "close|3|work: a|2023-03-01T09:00:00Z|2023-03-01T10:00:00Z"; env != want {
+		t.Fatalf("Got environment %q, want %q", env, want)
+	}
+	for _, s := range []string{`"Event":"close"`, `"Title":"work: a"`, `"End":"2023-03-01T10:00:00Z"`} {
+		if !strings.Contains(input, s) {
+			t.Fatalf("Expects %s in input, got: %s", s, input)
+		}
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, filepath.Join(dir, "close.d/1"), "echo oops >&2; exit 3")
+	writeScript(t, filepath.Join(dir, "close.d/2"), "sleep 5")
+	writeScript(t, filepath.Join(dir, "close.d/3"), "exit 0")
+
+	errs := (&Runner{Dir: dir, Timeout: 200 * time.Millisecond}).Run(testEvent())
+	if len(errs) != 2 {
+		t.Fatalf("Expects 2 errors, got: %v", errs)
+	}
+
+	var e *Error
+	if !errors.As(errs[0], &e) || e.Stderr != "oops" || !strings.Contains(e.Error(), "exit status 3") {
+		t.Fatalf("Expects error of exit status 3 with stderr, got: %v", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), "timed out") {
+		t.Fatalf("Expects timed out, got: %v", errs[1])
+	}
+}
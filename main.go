@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/alecthomas/kong"
+	"github.com/cranej/ticktock/hook"
+	"github.com/cranej/ticktock/remote"
 	"github.com/cranej/ticktock/store"
 	"github.com/cranej/ticktock/version"
 	"os"
@@ -10,35 +13,117 @@ import (
 )
 
 var Cli struct {
-	Db      string           `type:"path" help:"Path of the db file, if not specified, try environment $TICKTOCK_DB, then default to $XDG_DATA_HOME/ticktock/db. $XDG_DATA_HOME default to $HOME/.local/share if not set."`
-	Version kong.VersionFlag `help:"Show version"`
-	Start   StartCmd         `cmd:"" help:"Start an activity"`
-	Close   CloseCmd         `cmd:"" help:"Close the ongoing activity"`
-	Titles  TitlesCmd        `cmd:"" help:"Print titles of recent closed activities"`
-	Ongoing OngoingCmd       `cmd:"" help:"Show currently ongoing activity"`
-	Last    LastCmd          `cmd:"" help:"Show details of the latest closed activity with given title"`
-	Report  ReportCmd        `cmd:"" help:"Show time usage report"`
-	Server  ServerCmd        `cmd:"" help:"Start a server"`
-	Add     AddCmd           `cmd:"" help:"Add an closed activity"`
+	Db       string           `type:"path" help:"Path of the db file, if not specified, try environment $TICKTOCK_DB, then default to $XDG_DATA_HOME/ticktock/db. $XDG_DATA_HOME default to $HOME/.local/share if not set."`
+	Hooks    string           `type:"path" help:"Directory of hook scripts, if not specified, try environment $TICKTOCK_HOOKS, then default to $XDG_CONFIG_HOME/ticktock/hooks. $XDG_CONFIG_HOME default to $HOME/.config if not set."`
+	Version  kong.VersionFlag `help:"Show version"`
+	Start    StartCmd         `cmd:"" help:"Start an activity"`
+	Close    CloseCmd         `cmd:"" help:"Close the ongoing activity"`
+	Titles   TitlesCmd        `cmd:"" help:"Print titles of recent closed activities"`
+	Tags     TagsCmd          `cmd:"" help:"Print tags of closed activities with total time"`
+	Status   StatusCmd        `cmd:"" help:"Show status of time tracking for status bars"`
+	Ongoing  OngoingCmd       `cmd:"" help:"Show currently ongoing activity"`
+	Last     LastCmd          `cmd:"" help:"Show details of the latest closed activity with given title"`
+	Log      LogCmd           `cmd:"" help:"List closed activities, newest first"`
+	Search   SearchCmd        `cmd:"" help:"Search titles and notes of closed activities"`
+	Report   ReportCmd        `cmd:"" help:"Show time usage report"`
+	Tui      TuiCmd           `cmd:"" help:"Start the full-screen terminal interface"`
+	Server   ServerCmd        `cmd:"" help:"Start a server"`
+	Token    TokenCmd         `cmd:"" help:"Manage API tokens of the server"`
+	User     UserCmd          `cmd:"" help:"Manage users of the server in multi-user mode"`
+	Add      AddCmd           `cmd:"" help:"Add an closed activity"`
+	Goal     GoalCmd          `cmd:"" help:"Manage daily or weekly goals of tags"`
+	Budget   BudgetCmd        `cmd:"" help:"Manage time budgets of titles or tags"`
+	Rate     RateCmd          `cmd:"" help:"Manage hourly rates of titles or tags for billing"`
+	Invoice  InvoiceCmd       `cmd:"" help:"Export invoice line items of billed activities"`
+	Delete   DeleteCmd        `cmd:"" help:"Delete an activity"`
+	Edit     EditCmd          `cmd:"" help:"Edit an activity"`
+	Billable BillableCmd      `cmd:"" help:"Manage whether activities of tags are billable by default"`
+	Sync     SyncCmd          `cmd:"" help:"Merge activities with the db file of another device, both ways"`
+	Merge    MergeCmd         `cmd:"" help:"Merge activities from the db file of another device into this one"`
+
+	Completion CompletionCmd `cmd:"" help:"Print shell completion script"`
+	Complete   CompleteCmd   `cmd:"" name:"__complete" hidden:"" help:"Print completion candidates of a command line"`
+
+	Remote      string `env:"TICKTOCK_REMOTE" placeholder:"URL" help:"URL of a ticktock server to use instead of the db file, for example 'https://host:9000'. Starts and closes are queued while it is unreachable. Flags can also be set in $XDG_CONFIG_HOME/ticktock/config.json, as {\"remote\": \"https://host:9000\"}."`
+	RemoteToken string `env:"TICKTOCK_REMOTE_TOKEN" help:"API token of the remote server"`
 }
 
 func main() {
-	ctx := kong.Parse(&Cli,
+	options := []kong.Option{
 		kong.Description("Ticktock is a tool for better tracking time usage. "),
 		kong.Vars{
 			"version": version.Version,
-		})
+		},
+	}
+	if dir := configDir(); dir != "" {
+		options = append(options, kong.Configuration(kong.JSON, filepath.Join(dir, "config.json")))
+	}
+	ctx := kong.Parse(&Cli, options...)
 	dbPath, err := dbPath(Cli.Db)
 	ctx.FatalIfErrorf(err)
 	Cli.Db = dbPath
-	db, err := store.NewSqliteStore(Cli.Db)
+	ss, err := openStore()
 	ctx.FatalIfErrorf(err)
 
+	runner := hook.Runner{Dir: hooksDir(Cli.Hooks)}
+	onError := func(err error) { fmt.Fprintln(os.Stderr, "Hooks not run:", err) }
+	db := store.WithEvents(ss, onError, func(e *store.Event) {
+		for _, err := range runner.Run(e) {
+			fmt.Fprintln(os.Stderr, "Hook failed:", err)
+		}
+	})
+
 	ctx.BindTo(db, (*store.Store)(nil))
 	err = ctx.Run(db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
 	ctx.FatalIfErrorf(err)
 }
 
+// openStore opens the remote store if Cli.Remote is set, otherwise the db file.
+func openStore() (store.Store, error) {
+	if Cli.Remote == "" {
+		return store.NewSqliteStore(Cli.Db)
+	}
+
+	rs, err := remote.NewStore(Cli.Remote, Cli.RemoteToken, filepath.Join(filepath.Dir(Cli.Db), "remote.json"))
+	if err != nil {
+		return nil, err
+	}
+	rs.Notify = func(message string) {
+		fmt.Fprintf(os.Stderr, "(%s)\n", message)
+	}
+	return rs, nil
+}
+
+// configDir returns the directory of configurations, empty if it could not be determined.
+func configDir() string {
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return filepath.Join(xdgConfigHome, "ticktock")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config/ticktock")
+	}
+	return ""
+}
+
+// hooksDir returns the directory of hooks, empty if it could not be determined.
+func hooksDir(fromCmd string) string {
+	if fromCmd != "" {
+		return fromCmd
+	}
+
+	if hooksEnv := os.Getenv("TICKTOCK_HOOKS"); hooksEnv != "" {
+		return hooksEnv
+	}
+
+	if dir := configDir(); dir != "" {
+		return filepath.Join(dir, "hooks")
+	}
+	return ""
+}
+
 func dbPath(fromCmd string) (string, error) {
 	if fromCmd != "" {
 		return fromCmd, nil
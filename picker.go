@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var errCancelled = errors.New("cancelled")
+
+// pickerRows is the max number of candidates shown by the picker.
+const pickerRows = 10
+
+const pickerPrompt = "Title: "
+
+type key int
+
+const (
+	keyNone key = iota
+	keyRune
+	keyEnter
+	keyCancel
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyBackspace
+	keyDelete
+	keyDeleteWord
+	keyClear
+	keySave
+)
+
+type keyEvent struct {
+	key  key
+	rune rune
+}
+
+// parseKeys parses input read from a raw mode terminal into key events.
+func parseKeys(input []byte) []keyEvent {
+	events := make([]keyEvent, 0)
+	for len(input) > 0 {
+		if input[0] == 0x1b {
+			if len(input) == 1 {
+				events = append(events, keyEvent{key: keyCancel})
+				break
+			}
+
+			// CSI or SS3 sequences, for example "\x1b[A" or "\x1bOA"
+			n := 2
+			for n < len(input) && (input[n] < 0x40 || input[n] > 0x7e) {
+				n++
+			}
+			if n < len(input) {
+				n++
+			}
+			seq := string(input[1:n])
+			input = input[n:]
+
+			switch seq {
+			case "[A", "OA":
+				events = append(events, keyEvent{key: keyUp})
+			case "[B", "OB":
+				events = append(events, keyEvent{key: keyDown})
+			case "[C", "OC":
+				events = append(events, keyEvent{key: keyRight})
+			case "[D", "OD":
+				events = append(events, keyEvent{key: keyLeft})
+			case "[H", "OH", "[1~":
+				events = append(events, keyEvent{key: keyHome})
+			case "[F", "OF", "[4~":
+				events = append(events, keyEvent{key: keyEnd})
+			case "[3~":
+				events = append(events, keyEvent{key: keyDelete})
+			}
+			continue
+		}
+
+		r, size := utf8.DecodeRune(input)
+		input = input[size:]
+		switch r {
+		case '\r', '\n':
+			events = append(events, keyEvent{key: keyEnter})
+		case 0x03, 0x04, 0x07: // Ctrl-C, Ctrl-D, Ctrl-G
+			events = append(events, keyEvent{key: keyCancel})
+		case 0x10: // Ctrl-P
+			events = append(events, keyEvent{key: keyUp})
+		case 0x0e: // Ctrl-N
+			events = append(events, keyEvent{key: keyDown})
+		case 0x02: // Ctrl-B
+			events = append(events, keyEvent{key: keyLeft})
+		case 0x06: // Ctrl-F
+			events = append(events, keyEvent{key: keyRight})
+		case 0x01: // Ctrl-A
+			events = append(events, keyEvent{key: keyHome})
+		case 0x05: // Ctrl-E
+			events = append(events, keyEvent{key: keyEnd})
+		case 0x7f, 0x08: // Backspace, Ctrl-H
+			events = append(events, keyEvent{key: keyBackspace})
+		case 0x17: // Ctrl-W
+			events = append(events, keyEvent{key: keyDeleteWord})
+		case 0x15: // Ctrl-U
+			events = append(events, keyEvent{key: keyClear})
+		case 0x13: // Ctrl-S
+			events = append(events, keyEvent{key: keySave})
+		default:
+			if unicode.IsPrint(r) {
+				events = append(events, keyEvent{key: keyRune, rune: r})
+			}
+		}
+	}
+
+	return events
+}
+
+// picker is the state of the fuzzy finder. Candidates are titles matching the query, followed by
+// the query itself as a new title if it is not one of titles.
+type picker struct {
+	titles     []string
+	query      []rune
+	cursor     int
+	candidates []string
+	selected   int
+	// isNew is whether the last candidate is the query as a new title
+	isNew bool
+}
+
+func newPicker(titles []string) *picker {
+	p := &picker{titles: titles}
+	p.filter()
+	return p
+}
+
+func (p *picker) filter() {
+	query := strings.TrimSpace(string(p.query))
+	p.candidates = store.FilterTitles(p.titles, query, 0)
+	p.isNew = false
+	if query != "" {
+		exists := false
+		for _, title := range p.candidates {
+			if title == query {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			p.candidates = append(p.candidates, query)
+			p.isNew = true
+		}
+	}
+	p.selected = 0
+}
+
+// handle updates the state by the key event, returns the chosen title when done.
+func (p *picker) handle(e keyEvent) (done bool, title string, err error) {
+	switch e.key {
+	case keyRune:
+		p.query = append(p.query[:p.cursor], append([]rune{e.rune}, p.query[p.cursor:]...)...)
+		p.cursor++
+		p.filter()
+	case keyBackspace:
+		if p.cursor > 0 {
+			p.query = append(p.query[:p.cursor-1], p.query[p.cursor:]...)
+			p.cursor--
+			p.filter()
+		}
+	case keyDelete:
+		if p.cursor < len(p.query) {
+			p.query = append(p.query[:p.cursor], p.query[p.cursor+1:]...)
+			p.filter()
+		}
+	case keyDeleteWord:
+		i := p.cursor
+		for i > 0 && p.query[i-1] == ' ' {
+			i--
+		}
+		for i > 0 && p.query[i-1] != ' ' {
+			i--
+		}
+		p.query = append(p.query[:i], p.query[p.cursor:]...)
+		p.cursor = i
+		p.filter()
+	case keyClear:
+		p.query = p.query[p.cursor:]
+		p.cursor = 0
+		p.filter()
+	case keyLeft:
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case keyRight:
+		if p.cursor < len(p.query) {
+			p.cursor++
+		}
+	case keyHome:
+		p.cursor = 0
+	case keyEnd:
+		p.cursor = len(p.query)
+	case keyUp:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyDown:
+		if p.selected < len(p.candidates)-1 {
+			p.selected++
+		}
+	case keyEnter:
+		if len(p.candidates) > 0 {
+			return true, p.candidates[p.selected], nil
+		}
+	case keyCancel:
+		return true, "", errCancelled
+	}
+
+	return false, "", nil
+}
+
+// lines returns at most 'rows' candidates, scrolled to keep the selected one visible.
+func (p *picker) lines(rows int) []string {
+	first := 0
+	if p.selected >= rows {
+		first = p.selected - rows + 1
+	}
+
+	lines := make([]string, 0, rows)
+	for i := first; i < len(p.candidates) && len(lines) < rows; i++ {
+		line := p.candidates[i]
+		if p.isNew && i == len(p.candidates)-1 {
+			line = "(new) " + line
+		}
+		if i == p.selected {
+			line = "\x1b[7m> " + line + "\x1b[0m"
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// render draws the query line and at most 'rows' candidates, leaving the terminal cursor in the
+// query line. Lines drawn are cleared by the next render.
+func (p *picker) render(w io.Writer, rows int) {
+	var b strings.Builder
+	b.WriteString("\r\x1b[J")
+	fmt.Fprintf(&b, "%s%s", pickerPrompt, string(p.query))
+
+	lines := p.lines(rows)
+	for _, line := range lines {
+		b.WriteString("\r\n" + line)
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", len(lines))
+	}
+	fmt.Fprintf(&b, "\r\x1b[%dC", utf8.RuneCountInString(pickerPrompt)+p.cursor)
+	io.WriteString(w, b.String())
+}
+
+// isTerminal returns whether both stdin and stdout are terminals.
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// pickTitle lets user choose one of titles, or enter a new title, with a fuzzy finder.
+func pickTitle(titles []string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+
+	rows := pickerRows
+	if _, height, err := term.GetSize(fd); err == nil && height > 1 && height-1 < rows {
+		rows = height - 1
+	}
+
+	p := newPicker(titles)
+	p.render(os.Stdout, rows)
+	defer io.WriteString(os.Stdout, "\r\x1b[J")
+
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return "", err
+		}
+
+		for _, e := range parseKeys(buf[:n]) {
+			if done, title, err := p.handle(e); done {
+				return title, err
+			}
+		}
+		p.render(os.Stdout, rows)
+	}
+}
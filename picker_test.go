@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func pick(t *testing.T, titles []string, input string) (string, error) {
+	t.Helper()
+
+	p := newPicker(titles)
+	for _, e := range parseKeys([]byte(input)) {
+		if done, title, err := p.handle(e); done {
+			return title, err
+		}
+	}
+
+	t.Fatalf("Input %q does not finish picking", input)
+	return "", nil
+}
+
+func TestPicker(t *testing.T) {
+	titles := []string{"en: grammar", "gym", "clientA: standup"}
+	for _, c := range []struct {
+		input string
+		want  string
+	}{
+		{"\r", "en: grammar"},
+		{"\x1b[B\x1b[B\r", "clientA: standup"},
+		{"\x0e\x0e\x0e\x0e\x10\r", "gym"},
+		{"gy\r", "gym"},
+		{"std\r", "clientA: standup"},
+		{"std\x1b[B\r", "std"},
+		{"new\r", "new"},
+		{"gyx\x7f\r", "gym"},
+		{"gymx\x1b[D\x1b[3~\r", "gym"},
+		{"en: x\x17\x01g\x05\r", "gen:"},
+		{"xyz\x15gym\r", "gym"},
+		{"ソ\r", "ソ"},
+	} {
+		got, err := pick(t, titles, c.input)
+		if err != nil || got != c.want {
+			t.Errorf("Input %q: got (%q, %v), want %q", c.input, got, err, c.want)
+		}
+	}
+
+	if _, err := pick(t, titles, "gy\x03"); !errors.Is(err, errCancelled) {
+		t.Errorf("Expects errCancelled, got %v", err)
+	}
+	if _, err := pick(t, titles, "\x1b"); !errors.Is(err, errCancelled) {
+		t.Errorf("Expects errCancelled, got %v", err)
+	}
+}
+
+func TestPickerRender(t *testing.T) {
+	p := newPicker([]string{"a1", "a2", "a3"})
+	p.handle(keyEvent{key: keyRune, rune: 'x'})
+
+	var b strings.Builder
+	p.render(&b, 2)
+	want := "\r\x1b[JTitle: x\r\n\x1b[7m> (new) x\x1b[0m\x1b[1A\r\x1b[8C"
+	if b.String() != want {
+		t.Errorf("Got %q, want %q", b.String(), want)
+	}
+
+	p = newPicker([]string{"a1", "a2", "a3"})
+	p.handle(keyEvent{key: keyDown})
+	p.handle(keyEvent{key: keyDown})
+	b.Reset()
+	p.render(&b, 2)
+	want = "\r\x1b[JTitle: \r\n  a2\r\n\x1b[7m> a3\x1b[0m\x1b[2A\r\x1b[7C"
+	if b.String() != want {
+		t.Errorf("Got %q, want %q", b.String(), want)
+	}
+}
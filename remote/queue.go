@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/server"
+	"github.com/cranej/ticktock/store"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type opType string
+
+const (
+	opStart opType = "start"
+	opClose opType = "close"
+)
+
+// op is a change queued while the server is unreachable.
+type op struct {
+	Type opType
+	// Time is when the activity is started or closed.
+	Time time.Time
+	// Activity is the activity to start.
+	Activity *store.OpenActivity `json:",omitempty"`
+	// Notes are appended to notes of the activity to close.
+	Notes string `json:",omitempty"`
+	// Id is the id of the activity to close, 0 if it is started by a queued start not replayed.
+	Id int64 `json:",omitempty"`
+}
+
+// state is saved in the state file, for working while the server is unreachable.
+type state struct {
+	// Ongoing is the ongoing activity last known, with queued changes applied.
+	Ongoing *store.OpenActivity
+	// BillableDefaults are the ones last known, nil if unknown.
+	BillableDefaults map[string]bool
+	Queue            []op
+}
+
+// loadState returns the state in the file, and the content of the file.
+func loadState(path string) (*state, []byte, error) {
+	var st state
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &st, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	return &st, data, nil
+}
+
+// saveState writes the state file if the state is changed.
+func (s *Store) saveState() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.saved == nil && s.state.Ongoing == nil && s.state.BillableDefaults == nil && len(s.state.Queue) == 0 ||
+		bytes.Equal(data, s.saved) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.statePath, data, 0600); err != nil {
+		return err
+	}
+	s.saved = data
+	return nil
+}
+
+func (s *Store) setOngoing(activity *store.OpenActivity) {
+	s.state.Ongoing = activity
+}
+
+func (s *Store) setBillableDefaults(defaults map[string]bool) {
+	s.state.BillableDefaults = defaults
+}
+
+// queueStart queues start of the activity, unless there is an ongoing activity as far as known.
+func (s *Store) queueStart(activity *store.OpenActivity) error {
+	if s.state.Ongoing != nil {
+		return fmt.Errorf("%w: %s, as last known from the unreachable server", store.ErrOngoingExists, s.state.Ongoing.Title)
+	}
+
+	activity.Id = 0
+	queued := *activity
+	s.state.Queue = append(s.state.Queue, op{Type: opStart, Time: activity.Start, Activity: &queued})
+	s.state.Ongoing = &queued
+	s.notify("Server is unreachable, queued start of %s", activity.Title)
+	return s.saveState()
+}
+
+// queueClose queues close of the ongoing activity, which must be known.
+func (s *Store) queueClose(notes string) (string, error) {
+	ongoing := s.state.Ongoing
+	if ongoing == nil {
+		return "", fmt.Errorf("%w, and no activity is known to be ongoing", ErrUnreachable)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s.state.Queue = append(s.state.Queue, op{Type: opClose, Time: now, Notes: notes, Id: ongoing.Id})
+	s.state.Ongoing = nil
+
+	closed := *ongoing
+	closed.Notes += notes
+	s.closed = &store.ClosedActivity{OpenActivity: &closed, End: now}
+	s.notify("Server is unreachable, queued close of %s", ongoing.Title)
+	return ongoing.Title, s.saveState()
+}
+
+// replay sends queued changes in order. Changes rejected by the server are dropped, and it stops at
+// the first change failed because the server is unreachable.
+func (s *Store) replay() error {
+	for len(s.state.Queue) > 0 {
+		o := s.state.Queue[0]
+		var err error
+		switch o.Type {
+		case opStart:
+			err = s.replayStart(&o)
+		case opClose:
+			err = s.replayClose(&o)
+		default:
+			err = fmt.Errorf("unknown type %s", o.Type)
+		}
+		if errors.Is(err, ErrUnreachable) {
+			return err
+		}
+
+		s.state.Queue = s.state.Queue[1:]
+		if err != nil {
+			s.notify("Dropped queued %s at %s: %v", o.Type, o.Time.Local().Format(time.DateTime), err)
+		} else {
+			s.notify("Replayed queued %s at %s", o.Type, o.Time.Local().Format(time.DateTime))
+		}
+		if err := s.saveState(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayStart starts the activity, and sets the id of the queued close of it if any. If the server
+// conflicts as the activity is ongoing already, which is started by a replay whose response is
+// lost, the ongoing one is taken as started.
+func (s *Store) replayStart(o *op) error {
+	if o.Activity == nil {
+		return errors.New("no activity to start")
+	}
+	var started server.Activity
+	err := s.send(http.MethodPost, "/api/v1/activities", nil, toInput(o.Activity, time.Time{}), &started)
+	if errors.Is(err, store.ErrOngoingExists) || errors.Is(err, store.ErrDuplicateActivity) {
+		var ongoing *server.Activity
+		if err := s.send(http.MethodGet, "/api/v1/ongoing", nil, nil, &ongoing); err != nil {
+			return err
+		}
+		if ongoing != nil && ongoing.Title == o.Activity.Title && ongoing.Start.Equal(o.Activity.Start.Truncate(time.Second)) {
+			started, err = *ongoing, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i < len(s.state.Queue) && s.state.Queue[i].Type != opStart; i++ {
+		if s.state.Queue[i].Type == opClose {
+			s.state.Queue[i].Id = started.Id
+			break
+		}
+	}
+	return nil
+}
+
+// replayClose closes the activity at the time it was closed offline, if it is still ongoing.
+// Another activity ongoing on the server, e.g. started from another device while the start of
+// this one was rejected, is not closed.
+func (s *Store) replayClose(o *op) error {
+	if o.Id == 0 {
+		return errors.New("the activity to close is not started")
+	}
+	var ongoing *server.Activity
+	if err := s.send(http.MethodGet, "/api/v1/ongoing", nil, nil, &ongoing); err != nil {
+		return err
+	}
+	if ongoing == nil || ongoing.Id != o.Id {
+		return errors.New("the activity to close is not ongoing any more")
+	}
+
+	activity := toClosed(ongoing)
+	activity.Notes += o.Notes
+	path := "/api/v1/activities/" + strconv.FormatInt(activity.Id, 10)
+	return s.send(http.MethodPut, path, nil, toInput(activity.OpenActivity, o.Time), nil)
+}
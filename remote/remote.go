@@ -0,0 +1,657 @@
+// Package remote implements store.Store by the API of a ticktock server.
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/server"
+	"github.com/cranej/ticktock/store"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// requestTimeout is the timeout of each request to the server.
+	requestTimeout = 10 * time.Second
+	// offlineTimeout is how long requests fail fast after the server is found unreachable.
+	offlineTimeout = 5 * time.Second
+)
+
+// ErrUnreachable is returned if the server could not be reached.
+var ErrUnreachable = errors.New("server is unreachable")
+
+// ErrUnsupported is returned by methods which are not provided by the server API, such as managing tokens and users.
+var ErrUnsupported = errors.New("not supported by remote store")
+
+// Error is an error response of the server.
+type Error struct {
+	Status  int
+	Message string
+	// Code is the code of the store error, see server.ErrorCodes.
+	Code string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the store error of the code of the response, so that errors.Is works as with local
+// stores.
+func (e *Error) Unwrap() error {
+	return server.ErrorCodes[e.Code]
+}
+
+// Store is a store.Store of a ticktock server. Starts and closes are queued in the state file if the
+// server is unreachable, and replayed in order once it is reachable again.
+type Store struct {
+	url    *url.URL
+	token  string
+	client *http.Client
+	// Notify is called with messages of queued or replayed changes, if not nil.
+	Notify func(message string)
+
+	state     *state
+	statePath string
+	// saved is the content of the state file
+	saved []byte
+	// offlineUntil is set when the server is found unreachable, requests fail fast until then.
+	offlineUntil time.Time
+	// closed is the activity closed while offline, so that Get of it works.
+	closed *store.ClosedActivity
+	// usages caches time used of budgets returned by Budgets.
+	usages map[budgetKey]time.Duration
+}
+
+type budgetKey struct {
+	name     string
+	isTag    bool
+	from, to time.Time
+}
+
+// NewStore returns a Store of the server at baseUrl, as 'https://host:port'. token is sent as bearer
+// token if not empty, and user info of baseUrl is sent by basic auth. statePath is the file of queued
+// changes and cached data for working offline.
+func NewStore(baseUrl, token, statePath string) (*Store, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseUrl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid remote %s, expects http or https URL", baseUrl)
+	}
+
+	s := &Store{
+		url:       u,
+		token:     token,
+		client:    &http.Client{Timeout: requestTimeout},
+		statePath: statePath,
+	}
+	if s.state, s.saved, err = loadState(statePath); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) notify(format string, a ...any) {
+	if s.Notify != nil {
+		s.Notify(fmt.Sprintf(format, a...))
+	}
+}
+
+// do sends the request with body encoded as JSON if not nil, and decodes the response into out if not
+// nil. Queued changes are replayed before it.
+func (s *Store) do(method, path string, query url.Values, body, out any) error {
+	if err := s.replay(); err != nil {
+		return err
+	}
+	return s.send(method, path, query, body, out)
+}
+
+func (s *Store) send(method, path string, query url.Values, body, out any) error {
+	if time.Now().Before(s.offlineUntil) {
+		return ErrUnreachable
+	}
+
+	u := *s.url
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.offlineUntil = time.Now().Add(offlineTimeout)
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e server.ErrorBody
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return &Error{resp.StatusCode, e.Error, e.Code}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func toClosed(a *server.Activity) *store.ClosedActivity {
+	activity := store.ClosedActivity{OpenActivity: &store.OpenActivity{
+		Id:       a.Id,
+		Title:    a.Title,
+		Start:    a.Start.UTC(),
+		Notes:    a.Notes,
+		Billable: a.Billable,
+		Meta:     a.Meta,
+	}}
+	// Tags of the API include the one derived from Title, which changes with it
+	for _, tag := range a.Tags {
+		if tag != activity.Tag() {
+			activity.Tags = append(activity.Tags, tag)
+		}
+	}
+	if a.End != nil {
+		activity.End = a.End.UTC()
+	}
+	return &activity
+}
+
+func toInput(a *store.OpenActivity, end time.Time) *server.ActivityInput {
+	billable := a.Billable
+	input := server.ActivityInput{
+		Title:    a.Title,
+		Start:    a.Start,
+		Notes:    a.Notes,
+		Billable: &billable,
+		Meta:     a.Meta,
+		Tags:     a.Tags,
+	}
+	if !end.IsZero() {
+		input.End = &end
+	}
+	return &input
+}
+
+func toClosedList(activities []server.Activity) []store.ClosedActivity {
+	result := make([]store.ClosedActivity, 0, len(activities))
+	for i := range activities {
+		result = append(result, *toClosed(&activities[i]))
+	}
+	return result
+}
+
+// filterQuery returns query parameters of the filter.
+func filterQuery(filter *store.QueryArg) url.Values {
+	query := url.Values{}
+	if filter == nil {
+		return query
+	}
+
+	if filter.IsTag() {
+		query["tag"] = filter.Values()
+		query.Set("match", map[store.TagMatch]string{store.AnyTag: "any", store.AllTags: "all", store.NoneTags: "none"}[filter.Match()])
+	} else if !filter.Empty() {
+		query["title"] = filter.Values()
+	}
+	if billable, ok := filter.Billable(); ok {
+		query.Set("billable", strconv.FormatBool(billable))
+	}
+	for _, kv := range filter.Meta() {
+		query.Add("meta", kv[0]+"="+kv[1])
+	}
+	return query
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (s *Store) Start(activity *store.OpenActivity) error {
+	var created server.Activity
+	err := s.do(http.MethodPost, "/api/v1/activities", nil, toInput(activity, time.Time{}), &created)
+	if errors.Is(err, ErrUnreachable) {
+		return s.queueStart(activity)
+	}
+	if err != nil {
+		return err
+	}
+
+	activity.Id = created.Id
+	s.setOngoing(toClosed(&created).OpenActivity)
+	return nil
+}
+
+func (s *Store) StartTitle(title, notes string) error {
+	var created server.Activity
+	err := s.do(http.MethodPost, "/api/v1/ongoing", nil, server.StartInput{Title: title, Notes: notes}, &created)
+	if errors.Is(err, ErrUnreachable) {
+		defaults, _ := s.BillableDefaults()
+		activity := store.OpenActivity{Title: title, Start: time.Now().UTC().Truncate(time.Second), Notes: notes}
+		activity.Billable = store.BillableDefault(defaults, &activity)
+		return s.queueStart(&activity)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.setOngoing(toClosed(&created).OpenActivity)
+	return nil
+}
+
+func (s *Store) CloseActivity(notes string) (string, error) {
+	var closed server.Activity
+	err := s.do(http.MethodPost, "/api/v1/ongoing/close", nil, server.CloseInput{Notes: notes}, &closed)
+	if errors.Is(err, ErrUnreachable) {
+		return s.queueClose(notes)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		s.setOngoing(nil)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.setOngoing(nil)
+	return closed.Title, nil
+}
+
+func (s *Store) RecentTitles(limit uint8) ([]string, error) {
+	return s.Titles(store.RankRecent, "", time.Now(), int(limit))
+}
+
+// Titles returns titles ranked at the time of the server, 'at' is ignored.
+func (s *Store) Titles(rank store.TitleRank, pattern string, at time.Time, limit int) ([]string, error) {
+	query := url.Values{"rank": {string(rank)}, "limit": {strconv.Itoa(limit)}}
+	if pattern != "" {
+		query.Set("filter", pattern)
+	}
+
+	var titles []string
+	if err := s.do(http.MethodGet, "/api/v1/titles", query, nil, &titles); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// Ongoing returns the ongoing activity, or the one last known with queued changes applied if the
+// server is unreachable.
+func (s *Store) Ongoing() (*store.OpenActivity, error) {
+	var ongoing *server.Activity
+	err := s.do(http.MethodGet, "/api/v1/ongoing", nil, nil, &ongoing)
+	if errors.Is(err, ErrUnreachable) {
+		return s.state.Ongoing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ongoing == nil {
+		s.setOngoing(nil)
+		return nil, nil
+	}
+	activity := toClosed(ongoing).OpenActivity
+	s.setOngoing(activity)
+	return activity, nil
+}
+
+func (s *Store) LastClosed(title string) (*store.ClosedActivity, error) {
+	query := url.Values{"limit": {"1"}}
+	if title != "" {
+		query.Set("title", title)
+	}
+
+	var activities []server.Activity
+	if err := s.do(http.MethodGet, "/api/v1/activities", query, nil, &activities); err != nil {
+		return nil, err
+	}
+	if len(activities) == 0 {
+		return nil, nil
+	}
+	return toClosed(&activities[0]), nil
+}
+
+func (s *Store) Closed(queryStart, queryEnd time.Time, filter *store.QueryArg) ([]store.ClosedActivity, error) {
+	query := filterQuery(filter)
+	query.Set("since", formatTime(queryStart))
+	query.Set("until", formatTime(queryEnd))
+	query.Set("limit", "0")
+
+	var activities []server.Activity
+	if err := s.do(http.MethodGet, "/api/v1/activities", query, nil, &activities); err != nil {
+		return nil, err
+	}
+
+	closed := toClosedList(activities)
+	// the server returns newest first
+	sort.SliceStable(closed, func(i, j int) bool { return closed[i].Start.Before(closed[j].Start) })
+	return closed, nil
+}
+
+func (s *Store) Search(q string, queryStart, queryEnd time.Time, filter *store.QueryArg, limit int) ([]store.SearchResult, error) {
+	query := filterQuery(filter)
+	query.Set("q", q)
+	query.Set("limit", strconv.Itoa(limit))
+	if !queryStart.IsZero() {
+		query.Set("since", formatTime(queryStart))
+	}
+	if !queryEnd.IsZero() {
+		query.Set("until", formatTime(queryEnd))
+	}
+
+	var found []server.SearchActivity
+	if err := s.do(http.MethodGet, "/api/v1/search", query, nil, &found); err != nil {
+		return nil, err
+	}
+
+	results := make([]store.SearchResult, 0, len(found))
+	for i := range found {
+		results = append(results, store.SearchResult{ClosedActivity: *toClosed(found[i].Activity), Snippet: found[i].Snippet})
+	}
+	return results, nil
+}
+
+// cursorParam returns the parameter of the cursor, the id of its activity, or its time.
+func cursorParam(c store.Cursor) string {
+	if c.Id != 0 {
+		return strconv.FormatInt(c.Id, 10)
+	}
+	return formatTime(c.Start)
+}
+
+func (s *Store) History(before, after store.Cursor, filter *store.QueryArg, limit int) ([]store.ClosedActivity, error) {
+	query := filterQuery(filter)
+	query.Set("limit", strconv.Itoa(limit))
+	if !before.IsZero() {
+		query.Set("before", cursorParam(before))
+	}
+	if !after.IsZero() {
+		query.Set("after", cursorParam(after))
+	}
+
+	var activities []server.Activity
+	if err := s.do(http.MethodGet, "/api/v1/activities", query, nil, &activities); err != nil {
+		return nil, err
+	}
+	return toClosedList(activities), nil
+}
+
+func (s *Store) Add(activity *store.ClosedActivity) error {
+	var created server.Activity
+	if err := s.do(http.MethodPost, "/api/v1/activities", nil, toInput(activity.OpenActivity, activity.End), &created); err != nil {
+		return err
+	}
+
+	activity.Id = created.Id
+	return nil
+}
+
+// Get returns the activity of id, or the one closed while the server is unreachable.
+func (s *Store) Get(id int64) (*store.ClosedActivity, error) {
+	var activity server.Activity
+	err := s.do(http.MethodGet, "/api/v1/activities/"+strconv.FormatInt(id, 10), nil, nil, &activity)
+	if errors.Is(err, ErrUnreachable) && s.closed != nil && s.closed.Id == id {
+		return s.closed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toClosed(&activity), nil
+}
+
+func (s *Store) Update(activity *store.ClosedActivity) error {
+	path := "/api/v1/activities/" + strconv.FormatInt(activity.Id, 10)
+	return s.do(http.MethodPut, path, nil, toInput(activity.OpenActivity, activity.End), nil)
+}
+
+func (s *Store) Delete(id int64) error {
+	return s.do(http.MethodDelete, "/api/v1/activities/"+strconv.FormatInt(id, 10), nil, nil, nil)
+}
+
+func (s *Store) Tags() ([]store.TagUsage, error) {
+	var tags []server.TagUsage
+	if err := s.do(http.MethodGet, "/api/v1/tags", nil, nil, &tags); err != nil {
+		return nil, err
+	}
+
+	usages := make([]store.TagUsage, 0, len(tags))
+	for _, tag := range tags {
+		usages = append(usages, store.TagUsage{Name: tag.Name, Count: tag.Count, Total: time.Duration(tag.Total) * time.Second})
+	}
+	return usages, nil
+}
+
+func (s *Store) SetBillableDefault(tag string, billable bool) error {
+	return s.do(http.MethodPut, "/api/v1/billable", nil, server.BillableDefault{Tag: tag, Billable: billable}, nil)
+}
+
+// BillableDefaults returns billable defaults, or the ones last known if the server is unreachable.
+func (s *Store) BillableDefaults() (map[string]bool, error) {
+	var list []server.BillableDefault
+	err := s.do(http.MethodGet, "/api/v1/billable", nil, nil, &list)
+	if errors.Is(err, ErrUnreachable) && s.state.BillableDefaults != nil {
+		return s.state.BillableDefaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := make(map[string]bool)
+	for _, d := range list {
+		defaults[d.Tag] = d.Billable
+	}
+	s.setBillableDefaults(defaults)
+	return defaults, nil
+}
+
+func (s *Store) SetGoal(goal *store.Goal) error {
+	return s.do(http.MethodPut, "/api/v1/goals", nil, server.Goal{Tag: goal.Tag, Period: goal.Period, Target: int64(goal.Target.Seconds())}, nil)
+}
+
+func (s *Store) Goals() ([]store.Goal, error) {
+	var list []server.Goal
+	if err := s.do(http.MethodGet, "/api/v1/goals", nil, nil, &list); err != nil {
+		return nil, err
+	}
+
+	goals := make([]store.Goal, 0, len(list))
+	for _, g := range list {
+		goals = append(goals, store.Goal{Tag: g.Tag, Period: g.Period, Target: time.Duration(g.Target) * time.Second})
+	}
+	return goals, nil
+}
+
+func (s *Store) RemoveGoal(tag string, period store.GoalPeriod) error {
+	return s.do(http.MethodDelete, "/api/v1/goals", url.Values{"tag": {tag}, "period": {string(period)}}, nil, nil)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func (s *Store) SetBudget(budget *store.Budget) error {
+	return s.do(http.MethodPut, "/api/v1/budgets", nil, server.Budget{
+		Name:  budget.Name,
+		IsTag: budget.IsTag,
+		Limit: int64(budget.Limit.Seconds()),
+		From:  timeOrNil(budget.From),
+		To:    timeOrNil(budget.To),
+	}, nil)
+}
+
+func (s *Store) Budgets() ([]store.Budget, error) {
+	var list []server.Budget
+	if err := s.do(http.MethodGet, "/api/v1/budgets", nil, nil, &list); err != nil {
+		return nil, err
+	}
+
+	budgets := make([]store.Budget, 0, len(list))
+	s.usages = make(map[budgetKey]time.Duration)
+	for _, b := range list {
+		budget := store.Budget{
+			Name:  b.Name,
+			IsTag: b.IsTag,
+			Limit: time.Duration(b.Limit) * time.Second,
+			From:  timeOrZero(b.From),
+			To:    timeOrZero(b.To),
+		}
+		budgets = append(budgets, budget)
+		s.usages[keyOf(&budget)] = time.Duration(b.Used) * time.Second
+	}
+	return budgets, nil
+}
+
+func keyOf(budget *store.Budget) budgetKey {
+	return budgetKey{budget.Name, budget.IsTag, budget.From, budget.To}
+}
+
+// BudgetUsage returns time used of the budget, which must be one of the server's budgets.
+func (s *Store) BudgetUsage(budget *store.Budget) (time.Duration, error) {
+	if s.usages == nil {
+		if _, err := s.Budgets(); err != nil {
+			return 0, err
+		}
+	}
+
+	used, ok := s.usages[keyOf(budget)]
+	if !ok {
+		return 0, fmt.Errorf("budget %s: %w", budget, store.ErrNotFound)
+	}
+	return used, nil
+}
+
+func (s *Store) RemoveBudget(name string, isTag bool) error {
+	return s.do(http.MethodDelete, "/api/v1/budgets", url.Values{"name": {name}, "tag": {strconv.FormatBool(isTag)}}, nil, nil)
+}
+
+func (s *Store) SetRate(rate *store.Rate) error {
+	return s.do(http.MethodPut, "/api/v1/rates", nil, server.Rate{
+		Name:     rate.Name,
+		IsTag:    rate.IsTag,
+		Amount:   rate.Amount,
+		Currency: rate.Currency,
+		Since:    timeOrNil(rate.Since),
+	}, nil)
+}
+
+func (s *Store) Rates() ([]store.Rate, error) {
+	var list []server.Rate
+	if err := s.do(http.MethodGet, "/api/v1/rates", nil, nil, &list); err != nil {
+		return nil, err
+	}
+
+	rates := make([]store.Rate, 0, len(list))
+	for _, r := range list {
+		rates = append(rates, store.Rate{Name: r.Name, IsTag: r.IsTag, Amount: r.Amount, Currency: r.Currency, Since: timeOrZero(r.Since)})
+	}
+	return rates, nil
+}
+
+func (s *Store) RemoveRate(name string, isTag bool, since time.Time) error {
+	query := url.Values{"name": {name}, "tag": {strconv.FormatBool(isTag)}}
+	if !since.IsZero() {
+		query.Set("since", formatTime(since))
+	}
+	return s.do(http.MethodDelete, "/api/v1/rates", query, nil, nil)
+}
+
+func (s *Store) AddToken(token *store.Token, hash string) error {
+	return fmt.Errorf("tokens: %w", ErrUnsupported)
+}
+
+func (s *Store) Tokens() ([]store.Token, error) {
+	return nil, fmt.Errorf("tokens: %w", ErrUnsupported)
+}
+
+func (s *Store) FindToken(hash string) (*store.Token, error) {
+	return nil, fmt.Errorf("tokens: %w", ErrUnsupported)
+}
+
+func (s *Store) RevokeToken(name string) error {
+	return fmt.Errorf("tokens: %w", ErrUnsupported)
+}
+
+func (s *Store) AddUser(user *store.User, password string) error {
+	return fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+func (s *Store) Users() ([]store.User, error) {
+	return nil, fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+func (s *Store) GetUser(id int64) (*store.User, error) {
+	return nil, fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+func (s *Store) CheckPassword(name, password string) (*store.User, error) {
+	return nil, fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+func (s *Store) SetPassword(name, password string) error {
+	return fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+func (s *Store) RemoveUser(name string) error {
+	return fmt.Errorf("users: %w", ErrUnsupported)
+}
+
+// ForUser returns the store itself, activities are of the user of the token.
+func (s *Store) ForUser(id int64) store.Store {
+	return s
+}
+
+func (s *Store) Merge(other string) (*store.MergeReport, error) {
+	return nil, fmt.Errorf("merge: %w", ErrUnsupported)
+}
+
+func (s *Store) NewDeviceId() error {
+	return fmt.Errorf("new device id: %w", ErrUnsupported)
+}
+
+// Ping checks that the server is ready, queued changes are not replayed.
+func (s *Store) Ping() error {
+	return s.send(http.MethodGet, "/readyz", nil, nil, nil)
+}
+
+// Close saves the state file if it is changed.
+func (s *Store) Close() error {
+	return s.saveState()
+}
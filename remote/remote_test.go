@@ -0,0 +1,339 @@
+package remote
+
+import (
+	"errors"
+	"github.com/cranej/ticktock/server"
+	"github.com/cranej/ticktock/store"
+	_ "github.com/mattn/go-sqlite3"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serve serves the API of ss on addr, or a random port if addr is empty.
+func serve(t *testing.T, ss store.Store, addr string) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer((&server.Env{Store: ss}).Handler())
+	if addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Listener.Close()
+		ts.Listener = l
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestStore(t *testing.T) (store.Store, *httptest.Server, *Store) {
+	t.Helper()
+
+	dir := t.TempDir()
+	ss, err := store.NewSqliteStore(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+
+	ts := serve(t, ss, "")
+	rs, err := NewStore(ts.URL, "", filepath.Join(dir, "remote.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss, ts, rs
+}
+
+func TestStore(t *testing.T) {
+	_, _, rs := newTestStore(t)
+
+	if err := rs.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.SetBillableDefault("work", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.StartTitle("work: a", "n1"); err != nil {
+		t.Fatal(err)
+	}
+	ongoing, err := rs.Ongoing()
+	if err != nil || ongoing == nil || ongoing.Title != "work: a" || !ongoing.Billable {
+		t.Fatalf("Expects billable ongoing work: a, got: %+v, %v", ongoing, err)
+	}
+	if err := rs.StartTitle("b", ""); !errors.Is(err, store.ErrOngoingExists) {
+		t.Fatalf("Expects ErrOngoingExists, got: %v", err)
+	}
+	if title, err := rs.CloseActivity("n2"); title != "work: a" || err != nil {
+		t.Fatalf("Expects closed work: a, got: %s, %v", title, err)
+	}
+	if title, err := rs.CloseActivity(""); title != "" || err != nil {
+		t.Fatalf("Expects nothing to close, got: %s, %v", title, err)
+	}
+
+	start := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, title := range []string{"work: b", "gym"} {
+		activity := store.ClosedActivity{
+			OpenActivity: &store.OpenActivity{Title: title, Start: start.Add(time.Duration(i) * time.Hour), Notes: "notes of " + title, Meta: map[string]string{"k": "v"}},
+			End:          start.Add(time.Duration(i)*time.Hour + 30*time.Minute),
+		}
+		if err := rs.Add(&activity); err != nil || activity.Id == 0 {
+			t.Fatalf("Add: %v, id %d", err, activity.Id)
+		}
+	}
+
+	closed, err := rs.Closed(start, start.Add(24*time.Hour), store.NewTagArg([]string{"work"}).WithMeta("k", "v"))
+	if err != nil || len(closed) != 1 || closed[0].Title != "work: b" || closed[0].Notes != "notes of work: b" {
+		t.Fatalf("Expects work: b, got: %+v, %v", closed, err)
+	}
+	closed, err = rs.Closed(start, start.Add(24*time.Hour), nil)
+	if err != nil || len(closed) != 2 || closed[0].Title != "work: b" || closed[1].Title != "gym" {
+		t.Fatalf("Expects work: b and gym in order, got: %+v, %v", closed, err)
+	}
+
+	last, err := rs.LastClosed("")
+	if err != nil || last.Title != "work: a" || last.Notes != "n1n2" {
+		t.Fatalf("Expects work: a with notes, got: %+v, %v", last, err)
+	}
+	history, err := rs.History(store.CursorOf(last.OpenActivity), store.Cursor{}, nil, 1)
+	if err != nil || len(history) != 1 || history[0].Title != "gym" {
+		t.Fatalf("Expects gym before work: a, got: %+v, %v", history, err)
+	}
+	history, err = rs.History(store.Cursor{Start: start.Add(time.Hour)}, store.Cursor{}, nil, 0)
+	if err != nil || len(history) != 1 || history[0].Title != "work: b" {
+		t.Fatalf("Expects work: b before the time, got: %+v, %v", history, err)
+	}
+
+	results, err := rs.Search("notes", time.Time{}, time.Time{}, store.NewTitleArg([]string{"gym"}), 10)
+	if err != nil || len(results) != 1 || results[0].Title != "gym" || results[0].Snippet == "" {
+		t.Fatalf("Expects gym with snippet, got: %+v, %v", results, err)
+	}
+	if _, err := rs.Search(" ", time.Time{}, time.Time{}, nil, 10); !errors.Is(err, store.ErrEmptyQuery) {
+		t.Fatalf("Expects ErrEmptyQuery, got: %v", err)
+	}
+
+	gym := closed[1]
+	gym.Title = "gym: legs"
+	if err := rs.Update(&gym); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rs.Get(gym.Id); err != nil || got.Title != "gym: legs" {
+		t.Fatalf("Expects updated title, got: %+v, %v", got, err)
+	}
+	if err := rs.Delete(gym.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Get(gym.Id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	tags, err := rs.Tags()
+	if err != nil || len(tags) != 1 || tags[0].Name != "work" || tags[0].Count != 2 {
+		t.Fatalf("Expects tag work of 2 activities, got: %+v, %v", tags, err)
+	}
+	titles, err := rs.Titles(store.RankRecent, "w", time.Now(), 0)
+	if err != nil || len(titles) != 2 || titles[0] != "work: a" {
+		t.Fatalf("Expects recent titles, got: %v, %v", titles, err)
+	}
+
+	if err := rs.SetGoal(&store.Goal{Tag: "work", Period: store.PerWeek, Target: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if goals, err := rs.Goals(); err != nil || len(goals) != 1 || goals[0].Target != time.Hour {
+		t.Fatalf("Expects goal of work, got: %+v, %v", goals, err)
+	}
+	if err := rs.RemoveGoal("work", store.PerDay); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	budget := store.Budget{Name: "work", IsTag: true, Limit: 10 * time.Hour, From: start}
+	if err := rs.SetBudget(&budget); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := rs.BudgetUsage(&budget); err != nil || used != 30*time.Minute {
+		t.Fatalf("Expects 30m used of work: b, got: %v, %v", used, err)
+	}
+	if err := rs.RemoveBudget("work", true); err != nil {
+		t.Fatal(err)
+	}
+
+	rate := store.Rate{Name: "work", IsTag: true, Amount: 12050, Currency: "USD"}
+	if err := rs.SetRate(&rate); err != nil {
+		t.Fatal(err)
+	}
+	if rates, err := rs.Rates(); err != nil || len(rates) != 1 || rates[0] != rate {
+		t.Fatalf("Expects %+v, got: %+v, %v", rate, rates, err)
+	}
+	if err := rs.RemoveRate("work", true, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.Tokens(); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expects ErrUnsupported, got: %v", err)
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	ss, ts, rs := newTestStore(t)
+	ts.Close()
+	if err := rs.Ping(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expects ErrUnreachable, got: %v", err)
+	}
+
+	notified := 0
+	rs.Notify = func(string) { notified++ }
+	start := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	if err := rs.Start(&store.OpenActivity{Title: "work: a", Start: start, Notes: "n1", Billable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ongoing, err := rs.Ongoing(); err != nil || ongoing == nil || ongoing.Title != "work: a" || !ongoing.Billable {
+		t.Fatalf("Expects queued work: a, got: %+v, %v", ongoing, err)
+	}
+	if err := rs.Start(&store.OpenActivity{Title: "b", Start: time.Now()}); !errors.Is(err, store.ErrOngoingExists) {
+		t.Fatalf("Expects ErrOngoingExists, got: %v", err)
+	}
+	if title, err := rs.CloseActivity("n2"); title != "work: a" || err != nil {
+		t.Fatalf("Expects queued close of work: a, got: %s, %v", title, err)
+	}
+	if _, err := rs.CloseActivity(""); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expects ErrUnreachable without ongoing activity, got: %v", err)
+	}
+	if err := rs.Start(&store.OpenActivity{Title: "b", Start: time.Now().UTC().Truncate(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Tags(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expects ErrUnreachable, got: %v", err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if notified != 3 {
+		t.Fatalf("Expects 3 notifications, got %d", notified)
+	}
+
+	// as another process, after the server is back
+	serve(t, ss, ts.Listener.Addr().String())
+	rs, err := NewStore(ts.URL, "", rs.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ongoing, err := rs.Ongoing()
+	if err != nil || ongoing == nil || ongoing.Title != "b" {
+		t.Fatalf("Expects replayed start of b, got: %+v, %v", ongoing, err)
+	}
+	last, err := ss.LastClosed("work: a")
+	if err != nil || last == nil || last.Notes != "n1n2" || !last.Billable || !last.Start.Equal(start) {
+		t.Fatalf("Expects replayed work: a, got: %+v, %v", last, err)
+	}
+	if len(rs.state.Queue) != 0 {
+		t.Fatalf("Expects empty queue, got: %+v", rs.state.Queue)
+	}
+}
+
+func TestOfflineQueueConflict(t *testing.T) {
+	ss, ts, rs := newTestStore(t)
+	start := time.Now().UTC().Truncate(time.Second).Add(-2 * time.Hour)
+	if err := rs.Start(&store.OpenActivity{Title: "a", Start: start}); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	// a is closed and c is started on the server, while a is closed and b is started offline
+	if _, err := ss.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+	a, err := ss.LastClosed("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.End = start.Add(30 * time.Minute)
+	if err := ss.Update(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Start(&store.OpenActivity{Title: "c", Start: start.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Start(&store.OpenActivity{Title: "b", Start: time.Now().UTC().Truncate(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.CloseActivity("n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	serve(t, ss, ts.Listener.Addr().String())
+	rs, err = NewStore(ts.URL, "", rs.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dropped []string
+	rs.Notify = func(msg string) { dropped = append(dropped, msg) }
+
+	// the start of b is rejected by 409 Conflict, so neither close is replayed on c
+	ongoing, err := rs.Ongoing()
+	if err != nil || ongoing == nil || ongoing.Title != "c" || ongoing.Notes != "" {
+		t.Fatalf("Expects c kept ongoing, got: %+v, %v", ongoing, err)
+	}
+	if last, err := ss.LastClosed("b"); err != nil || last != nil {
+		t.Fatalf("Expects b not started, got: %+v, %v", last, err)
+	}
+	if len(dropped) != 3 || len(rs.state.Queue) != 0 {
+		t.Fatalf("Expects all 3 queued changes dropped, got: %v, %+v", dropped, rs.state.Queue)
+	}
+}
+
+func TestOfflineQueueLostResponse(t *testing.T) {
+	ss, ts, rs := newTestStore(t)
+	ts.Close()
+
+	start := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	if err := rs.Start(&store.OpenActivity{Title: "a", Start: start}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.CloseActivity("n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the start was replayed, but its response was lost
+	if err := ss.Start(&store.OpenActivity{Title: "a", Start: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve(t, ss, ts.Listener.Addr().String())
+	rs, err := NewStore(ts.URL, "", rs.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ongoing, err := rs.Ongoing(); err != nil || ongoing != nil {
+		t.Fatalf("Expects a closed, got: %+v, %v", ongoing, err)
+	}
+	if last, err := ss.LastClosed("a"); err != nil || last == nil || last.Notes != "n" || !last.Start.Equal(start) {
+		t.Fatalf("Expects a closed by the replay, got: %+v, %v", last, err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	for code, want := range server.ErrorCodes {
+		err := &Error{Status: 409, Message: "failed", Code: code}
+		for _, other := range server.ErrorCodes {
+			if errors.Is(err, other) != (other == want) {
+				t.Errorf("Code %s: errors.Is %v is %v", code, other, !(other == want))
+			}
+		}
+	}
+	if err := (&Error{Status: 409, Message: store.ErrDuplicateActivity.Error()}); errors.Unwrap(err) != nil {
+		t.Errorf("Expects no store error without a code, got %v", errors.Unwrap(err))
+	}
+}
@@ -0,0 +1,762 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activitiesLimit is the default number of activities returned by /api/v1/activities.
+const activitiesLimit = 50
+
+// maxCursor is after all activities, used as the 'before' bound of queries with only a 'since' bound,
+// so that they are newest first as well.
+var maxCursor = store.Cursor{Start: time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)}
+
+// Activity is the representation of activities in /api/v1. End is null if it is ongoing.
+type Activity struct {
+	Id       int64
+	Title    string
+	Start    time.Time
+	End      *time.Time
+	Notes    string
+	Billable bool
+	Meta     map[string]string
+	Tags     []string
+}
+
+func newActivity(a *store.ClosedActivity) *Activity {
+	activity := Activity{
+		Id:       a.Id,
+		Title:    a.Title,
+		Start:    a.Start,
+		Notes:    a.Notes,
+		Billable: a.Billable,
+		Meta:     a.Meta,
+		Tags:     a.AllTags(),
+	}
+	if !a.End.IsZero() {
+		activity.End = &a.End
+	}
+	return &activity
+}
+
+// ActivityWarnings is the response of requests which start or close activities, with budget warnings.
+type ActivityWarnings struct {
+	*Activity
+	Warnings []string
+}
+
+// ActivityInput is the body to create or replace an activity. Start defaults to now when creating,
+// and Billable defaults to the billable default of its tag if null.
+type ActivityInput struct {
+	Title    string
+	Start    time.Time
+	End      *time.Time
+	Notes    string
+	Billable *bool
+	Meta     map[string]string
+	Tags     []string
+}
+
+// StartInput is the body to start an activity.
+type StartInput struct {
+	Title string
+	Notes string
+}
+
+// CloseInput is the body to close the ongoing activity, Notes are appended to its notes.
+type CloseInput struct {
+	Notes string
+}
+
+// ErrorBody is the body of all error responses of /api/v1.
+type ErrorBody struct {
+	Error string
+	// Code is the key of the store error in ErrorCodes, empty if it is not one of them.
+	Code string
+}
+
+// ErrorCodes are store errors by their codes in ErrorBody, so that clients can tell them apart.
+var ErrorCodes = map[string]error{
+	"ongoing_exists":     store.ErrOngoingExists,
+	"duplicate_activity": store.ErrDuplicateActivity,
+	"not_found":          store.ErrNotFound,
+	"empty_query":        store.ErrEmptyQuery,
+	"duplicate_token":    store.ErrDuplicateToken,
+	"duplicate_user":     store.ErrDuplicateUser,
+}
+
+// errorCode returns the code of the store error err is, empty if none.
+func errorCode(err error) string {
+	for code, e := range ErrorCodes {
+		if errors.Is(err, e) {
+			return code
+		}
+	}
+	return ""
+}
+
+// ReportItem is the total time of activities with the same key.
+type ReportItem struct {
+	Key string
+	// Total and Billable are in seconds.
+	Total    int64
+	Billable int64
+}
+
+// ReportDay is the report of a day.
+type ReportDay struct {
+	Day   string
+	Total int64
+	Items []ReportItem
+}
+
+// Report is the response of /api/v1/reports, times are in seconds.
+type Report struct {
+	Since    string
+	Until    string
+	By       string
+	Total    int64
+	Billable int64
+	Items    []ReportItem
+	Days     []ReportDay
+}
+
+// errBadRequest wraps errors of invalid requests.
+var errBadRequest = errors.New("bad request")
+
+func badRequest(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", errBadRequest, fmt.Sprintf(format, a...))
+}
+
+// statusOf returns the status code of error returned by Store or handlers.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest), errors.Is(err, store.ErrEmptyQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrOngoingExists), errors.Is(err, store.ErrDuplicateActivity), errors.Is(err, store.ErrDuplicateToken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJsonStatus(w, statusOf(err), ErrorBody{err.Error(), errorCode(err)})
+}
+
+func writeJsonStatus(w http.ResponseWriter, status int, v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		status, j = http.StatusInternalServerError, []byte(`{"Error":"failed to encode response","Code":""}`)
+	}
+
+	w.Header().Set(contentTypeHeader, contentJson)
+	w.WriteHeader(status)
+	w.Write(j)
+}
+
+func readJson(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid body: %v", err)
+	}
+	return nil
+}
+
+// parseTime parses 'yyyy-MM-dd' as start of the day in local time, or end of the day if 'end',
+// or RFC3339 time. Returns UTC time.
+func parseTime(s string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if end {
+			return setTimeAndUTC(t, 23, 59, 59), nil
+		}
+		return setTimeAndUTC(t, 0, 0, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, badRequest("invalid time %s, expects yyyy-MM-dd or RFC3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// parseRange parses bounds 'since' and 'until', which are zero if not given.
+func parseRange(form url.Values) (since, until time.Time, err error) {
+	if s := form.Get("since"); s != "" {
+		if since, err = parseTime(s, false); err != nil {
+			return
+		}
+	}
+	if s := form.Get("until"); s != "" {
+		until, err = parseTime(s, true)
+	}
+	return
+}
+
+func parseInt(form url.Values, name string, defaultValue int) (int, error) {
+	s := form.Get(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, badRequest("invalid %s %s", name, s)
+	}
+	return i, nil
+}
+
+// parseFilter parses filters 'title' or 'tag' (repeatable), 'match' (any, all or none), 'billable'
+// and 'meta' (key=value, repeatable).
+func parseFilter(form url.Values) (*store.QueryArg, error) {
+	titles, tags := form["title"], form["tag"]
+	if len(titles) > 0 && len(tags) > 0 {
+		return nil, badRequest("title and tag can not be used together")
+	}
+
+	var filter *store.QueryArg
+	if len(titles) > 0 {
+		filter = store.NewTitleArg(titles)
+	}
+	if len(tags) > 0 {
+		match := store.AnyTag
+		switch form.Get("match") {
+		case "", "any":
+		case "all":
+			match = store.AllTags
+		case "none":
+			match = store.NoneTags
+		default:
+			return nil, badRequest("invalid match %s, expects any, all or none", form.Get("match"))
+		}
+		filter = store.NewTagMatchArg(tags, match)
+	}
+
+	if s := form.Get("billable"); s != "" {
+		billable, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, badRequest("invalid billable %s", s)
+		}
+		filter = filter.WithBillable(billable)
+	}
+
+	for _, meta := range form["meta"] {
+		key, value, ok := strings.Cut(meta, "=")
+		if !ok || key == "" {
+			return nil, badRequest("invalid meta %s, expects key=value", meta)
+		}
+		filter = filter.WithMeta(key, value)
+	}
+
+	return filter, nil
+}
+
+func parseId(ps httprouter.Params) (int64, error) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid id %s", ps.ByName("id"))
+	}
+	return id, nil
+}
+
+// cursorOf returns the cursor of activity of id in 'name' parameter, or of the time if it is an
+// RFC3339 time, or zero cursor if not given.
+func (env *Env) cursorOf(form url.Values, name string) (store.Cursor, error) {
+	s := form.Get(name)
+	if s == "" {
+		return store.Cursor{}, nil
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return store.Cursor{Start: t.UTC()}, nil
+		}
+		return store.Cursor{}, badRequest("invalid %s %s, expects id of an activity or RFC3339 time", name, s)
+	}
+	activity, err := env.Store.Get(id)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	return store.CursorOf(activity.OpenActivity), nil
+}
+
+// listActivities returns closed activities newest first. With 'q', they are activities whose title or
+// notes contain all words of it. Otherwise pages are turned by 'before' or 'after', ids of the last
+// or the first activity of the current page.
+func (env *Env) listActivities(form url.Values) ([]Activity, error) {
+	filter, err := parseFilter(form)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := parseInt(form, "limit", activitiesLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	since, until, err := parseRange(form)
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]Activity, 0)
+	if q := form.Get("q"); q != "" {
+		results, err := env.Store.Search(q, since, until, filter, limit)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			activities = append(activities, *newActivity(&results[i].ClosedActivity))
+		}
+		return activities, nil
+	}
+
+	before, err := env.cursorOf(form, "before")
+	if err != nil {
+		return nil, err
+	}
+	after, err := env.cursorOf(form, "after")
+	if err != nil {
+		return nil, err
+	}
+	// since and until are bounds as cursors right before or after them
+	if !until.IsZero() && (before.IsZero() || until.Before(before.Start)) {
+		before = store.Cursor{Start: until.Add(time.Second)}
+	}
+	if !since.IsZero() {
+		// newest first unless turning pages by 'after'
+		if after.IsZero() && before.IsZero() {
+			before = maxCursor
+		}
+		if after.IsZero() || since.After(after.Start) {
+			after = store.Cursor{Start: since.Add(-time.Second), Id: math.MaxInt64}
+		}
+	}
+
+	closed, err := env.Store.History(before, after, filter, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range closed {
+		activities = append(activities, *newActivity(&closed[i]))
+	}
+	return activities, nil
+}
+
+func (env *Env) apiV1Activities(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	activities, err := env.listActivities(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, activities)
+}
+
+func (env *Env) apiV1Activity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseId(ps)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	activity, err := env.Store.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, newActivity(activity))
+}
+
+// toActivity validates the input and converts it to an activity of the store.
+func (env *Env) toActivity(input *ActivityInput) (*store.ClosedActivity, error) {
+	if strings.TrimSpace(input.Title) == "" {
+		return nil, badRequest("Title is required")
+	}
+
+	activity := store.ClosedActivity{OpenActivity: &store.OpenActivity{
+		Title: input.Title,
+		Start: input.Start.UTC().Truncate(time.Second),
+		Notes: input.Notes,
+		Meta:  input.Meta,
+		Tags:  input.Tags,
+	}}
+	if input.End != nil {
+		activity.End = input.End.UTC().Truncate(time.Second)
+		if !activity.End.After(activity.Start) {
+			return nil, badRequest("End should be after Start")
+		}
+	}
+
+	if input.Billable != nil {
+		activity.Billable = *input.Billable
+	} else {
+		defaults, err := env.Store.BillableDefaults()
+		if err != nil {
+			return nil, err
+		}
+		activity.Billable = store.BillableDefault(defaults, activity.OpenActivity)
+	}
+
+	return &activity, nil
+}
+
+// warnings returns the activity with budget warnings of it.
+func (env *Env) warnings(activity *store.ClosedActivity) (*ActivityWarnings, error) {
+	warnings, err := store.BudgetWarnings(env.Store, activity, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &ActivityWarnings{newActivity(activity), warnings}, nil
+}
+
+// writeActivity writes the activity of id with budget warnings.
+func (env *Env) writeActivity(w http.ResponseWriter, status int, id int64) {
+	activity, err := env.Store.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response, err := env.warnings(activity)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJsonStatus(w, status, response)
+}
+
+func (env *Env) apiV1CreateActivity(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input ActivityInput
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if input.Start.IsZero() {
+		input.Start = time.Now()
+	}
+
+	activity, err := env.toActivity(&input)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if activity.End.IsZero() {
+		err = env.Store.Start(activity.OpenActivity)
+	} else {
+		err = env.Store.Add(activity)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/activities/%d", activity.Id))
+	env.writeActivity(w, http.StatusCreated, activity.Id)
+}
+
+func (env *Env) apiV1UpdateActivity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseId(ps)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var input ActivityInput
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if input.Start.IsZero() {
+		writeError(w, badRequest("Start is required"))
+		return
+	}
+
+	activity, err := env.toActivity(&input)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	activity.Id = id
+	if err := env.Store.Update(activity); err != nil {
+		writeError(w, err)
+		return
+	}
+	env.writeActivity(w, http.StatusOK, id)
+}
+
+func (env *Env) apiV1DeleteActivity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := parseId(ps)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := env.Store.Delete(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1Ongoing returns the ongoing activity with budget warnings, or null if there is none.
+func (env *Env) apiV1Ongoing(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ongoing, err := env.Store.Ongoing()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if ongoing == nil {
+		writeJsonStatus(w, http.StatusOK, nil)
+		return
+	}
+
+	response, err := env.warnings(&store.ClosedActivity{OpenActivity: ongoing})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, response)
+}
+
+func (env *Env) apiV1Start(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input StartInput
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(input.Title) == "" {
+		writeError(w, badRequest("Title is required"))
+		return
+	}
+
+	if err := env.Store.StartTitle(input.Title, input.Notes); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ongoing, err := env.Store.Ongoing()
+	if err == nil && ongoing == nil {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	env.writeActivity(w, http.StatusCreated, ongoing.Id)
+}
+
+func (env *Env) apiV1Close(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input CloseInput
+	if r.ContentLength != 0 {
+		if err := readJson(r, &input); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	ongoing, err := env.Store.Ongoing()
+	if err == nil && ongoing == nil {
+		err = fmt.Errorf("no ongoing activity: %w", store.ErrNotFound)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if _, err := env.Store.CloseActivity(input.Notes); err != nil {
+		writeError(w, err)
+		return
+	}
+	env.writeActivity(w, http.StatusOK, ongoing.Id)
+}
+
+func (env *Env) apiV1Titles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	rank := store.RankRecent
+	if r.Form.Get("rank") != "" {
+		rank = store.TitleRank(r.Form.Get("rank"))
+	}
+	if rank != store.RankRecent && rank != store.RankFrecency {
+		writeError(w, badRequest("invalid rank %s, expects recent or frecency", rank))
+		return
+	}
+
+	limit, err := parseInt(r.Form, "limit", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	titles, err := env.Store.Titles(rank, r.Form.Get("filter"), time.Now(), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, titles)
+}
+
+// TagUsage is the usage of a tag, Total is in seconds.
+type TagUsage struct {
+	Name  string
+	Count int
+	Total int64
+}
+
+func (env *Env) apiV1Tags(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	usages, err := env.Store.Tags()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tags := make([]TagUsage, 0, len(usages))
+	for _, usage := range usages {
+		tags = append(tags, TagUsage{usage.Name, usage.Count, int64(usage.Total.Seconds())})
+	}
+	writeJsonStatus(w, http.StatusOK, tags)
+}
+
+// reportTotals sums durations of activities by key.
+type reportTotals struct {
+	totals, billables map[string]time.Duration
+	sum, billableSum  time.Duration
+}
+
+func newReportTotals() *reportTotals {
+	return &reportTotals{totals: make(map[string]time.Duration), billables: make(map[string]time.Duration)}
+}
+
+// add counts the activity in each of keys, and once in the sum.
+func (r *reportTotals) add(a *store.ClosedActivity, keys []string) {
+	d := a.End.Sub(a.Start)
+	r.sum += d
+	if a.Billable {
+		r.billableSum += d
+	}
+
+	for _, key := range keys {
+		r.totals[key] += d
+		if a.Billable {
+			r.billables[key] += d
+		}
+	}
+}
+
+// items returns items ordered by total descending, then key.
+func (r *reportTotals) items() []ReportItem {
+	items := make([]ReportItem, 0, len(r.totals))
+	for key, total := range r.totals {
+		items = append(items, ReportItem{key, int64(total.Seconds()), int64(r.billables[key].Seconds())})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Total != items[j].Total {
+			return items[i].Total > items[j].Total
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+// apiV1Report reports total time of closed activities between 'since' and 'until' (both required), in
+// total and by day, grouped by 'by': title (default), tag, or meta:<key>. Filters are the same as
+// /api/v1/activities.
+func (env *Env) apiV1Report(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	if r.Form.Get("since") == "" || r.Form.Get("until") == "" {
+		writeError(w, badRequest("since and until are required"))
+		return
+	}
+	since, err := parseTime(r.Form.Get("since"), false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	until, err := parseTime(r.Form.Get("until"), true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filter, err := parseFilter(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	by := r.Form.Get("by")
+	var keys func(*store.ClosedActivity) []string
+	switch {
+	case by == "" || by == "title":
+		by = "title"
+		keys = func(a *store.ClosedActivity) []string { return []string{a.Title} }
+	case by == "tag":
+		keys = func(a *store.ClosedActivity) []string { return a.AllTags() }
+	case strings.HasPrefix(by, "meta:") && len(by) > len("meta:"):
+		key := strings.TrimPrefix(by, "meta:")
+		keys = func(a *store.ClosedActivity) []string {
+			if value, ok := a.Meta[key]; ok {
+				return []string{value}
+			}
+			return []string{"(no " + key + ")"}
+		}
+	default:
+		writeError(w, badRequest("invalid by %s, expects title, tag or meta:<key>", by))
+		return
+	}
+
+	activities, err := env.Store.Closed(since, until, filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	report := Report{Since: since.Format(time.RFC3339), Until: until.Format(time.RFC3339), By: by, Days: []ReportDay{}}
+	all := newReportTotals()
+	days := make(map[string]*reportTotals)
+	for i := range activities {
+		a := &activities[i]
+		day := a.Start.Local().Format(time.DateOnly)
+		if _, ok := days[day]; !ok {
+			days[day] = newReportTotals()
+		}
+		all.add(a, keys(a))
+		days[day].add(a, keys(a))
+	}
+
+	report.Total, report.Billable = int64(all.sum.Seconds()), int64(all.billableSum.Seconds())
+	report.Items = all.items()
+	for day, totals := range days {
+		report.Days = append(report.Days, ReportDay{day, int64(totals.sum.Seconds()), totals.items()})
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day < report.Days[j].Day })
+
+	writeJsonStatus(w, http.StatusOK, report)
+}
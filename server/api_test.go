@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func request(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// assertJson asserts status of the response, and decodes its body into v if v is not nil.
+func assertJson(t *testing.T, w *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("Expects status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if w.Code == http.StatusNoContent {
+		return
+	}
+	if got := w.Header().Get(contentTypeHeader); got != contentJson {
+		t.Fatalf("Expects JSON, got %s", got)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Invalid JSON %s: %v", w.Body.String(), err)
+		}
+	}
+}
+
+func TestApiV1Activities(t *testing.T) {
+	_, handler := newTestEnv(t)
+
+	for i, title := range []string{"work: a", "work: b", "gym"} {
+		body := fmt.Sprintf(`{"Title": %q, "Start": "2023-03-0%dT09:00:00Z", "End": "2023-03-0%dT10:00:00Z", "Tags": ["x"]}`, title, i+1, i+1)
+		var created ActivityWarnings
+		assertJson(t, request(handler, "POST", "/api/v1/activities", body), http.StatusCreated, &created)
+		if created.Title != title || created.End == nil || created.Id == 0 {
+			t.Fatalf("Got created activity %+v", created.Activity)
+		}
+	}
+
+	var e ErrorBody
+	assertJson(t, request(handler, "POST", "/api/v1/activities",
+		`{"Title": "gym", "Start": "2023-03-03T09:00:00Z", "End": "2023-03-03T09:30:00Z"}`), http.StatusConflict, &e)
+	if e.Error == "" {
+		t.Fatal("Expects error message")
+	}
+	assertJson(t, request(handler, "POST", "/api/v1/activities", `{"Title": ""}`), http.StatusBadRequest, &e)
+	assertJson(t, request(handler, "POST", "/api/v1/activities", `{"Titel": "a"}`), http.StatusBadRequest, &e)
+
+	titles := func(activities []Activity) string {
+		s := make([]string, 0, len(activities))
+		for _, a := range activities {
+			s = append(s, a.Title)
+		}
+		return strings.Join(s, ",")
+	}
+	for query, want := range map[string]string{
+		"":                                   "gym,work: b,work: a",
+		"?limit=1":                           "gym",
+		"?tag=work":                          "work: b,work: a",
+		"?tag=work&match=none":               "gym",
+		"?title=gym&title=work:%20a":         "gym,work: a",
+		"?since=2023-03-02":                  "gym,work: b",
+		"?until=2023-03-02":                  "work: b,work: a",
+		"?since=2023-03-02&until=2023-03-02": "work: b",
+		"?q=gym":                             "gym",
+	} {
+		var activities []Activity
+		assertJson(t, request(handler, "GET", "/api/v1/activities"+query, ""), http.StatusOK, &activities)
+		if got := titles(activities); got != want {
+			t.Fatalf("%s: got %s, want %s", query, got, want)
+		}
+	}
+	assertJson(t, request(handler, "GET", "/api/v1/activities?tag=a&title=b", ""), http.StatusBadRequest, &e)
+	assertJson(t, request(handler, "GET", "/api/v1/activities?before=100", ""), http.StatusNotFound, &e)
+
+	var activities []Activity
+	assertJson(t, request(handler, "GET", "/api/v1/activities?limit=1", ""), http.StatusOK, &activities)
+	path := fmt.Sprintf("/api/v1/activities?before=%d", activities[0].Id)
+	assertJson(t, request(handler, "GET", path, ""), http.StatusOK, &activities)
+	if got := titles(activities); got != "work: b,work: a" {
+		t.Fatalf("%s: got %s", path, got)
+	}
+
+	id := activities[0].Id
+	path = fmt.Sprintf("/api/v1/activities/%d", id)
+	var activity Activity
+	assertJson(t, request(handler, "PUT", path,
+		`{"Title": "work: c", "Start": "2023-03-02T09:00:00Z", "End": "2023-03-02T11:00:00Z", "Billable": true}`), http.StatusOK, &activity)
+	assertJson(t, request(handler, "GET", path, ""), http.StatusOK, &activity)
+	if activity.Title != "work: c" || !activity.Billable || activity.End.Sub(activity.Start).Hours() != 2 {
+		t.Fatalf("Got updated activity %+v", activity)
+	}
+	assertJson(t, request(handler, "PUT", path,
+		`{"Title": "gym", "Start": "2023-03-03T09:00:00Z", "End": "2023-03-03T11:00:00Z"}`), http.StatusConflict, &e)
+
+	assertJson(t, request(handler, "DELETE", path, ""), http.StatusNoContent, nil)
+	assertJson(t, request(handler, "GET", path, ""), http.StatusNotFound, &e)
+	assertJson(t, request(handler, "DELETE", path, ""), http.StatusNotFound, &e)
+	assertJson(t, request(handler, "GET", "/api/v1/activities/x", ""), http.StatusBadRequest, &e)
+}
+
+func TestApiV1Ongoing(t *testing.T) {
+	_, handler := newTestEnv(t)
+
+	var ongoing *ActivityWarnings
+	assertJson(t, request(handler, "GET", "/api/v1/ongoing", ""), http.StatusOK, &ongoing)
+	if ongoing != nil {
+		t.Fatalf("Expects no ongoing activity, got %+v", ongoing)
+	}
+
+	var e ErrorBody
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing/close", ""), http.StatusNotFound, &e)
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing", `{"Title": " "}`), http.StatusBadRequest, &e)
+
+	var started ActivityWarnings
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing", `{"Title": "work: a", "Notes": "n1"}`), http.StatusCreated, &started)
+	if started.Title != "work: a" || started.End != nil || started.Warnings == nil {
+		t.Fatalf("Got started activity %+v", started)
+	}
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing", `{"Title": "b"}`), http.StatusConflict, &e)
+	assertJson(t, request(handler, "POST", "/api/v1/activities", `{"Title": "b"}`), http.StatusConflict, &e)
+	if w := request(handler, "POST", "/api/start/b", ""); w.Code != http.StatusConflict {
+		t.Fatalf("Expects conflict, got %d", w.Code)
+	}
+
+	assertJson(t, request(handler, "GET", "/api/v1/ongoing", ""), http.StatusOK, &ongoing)
+	if ongoing == nil || ongoing.Id != started.Id {
+		t.Fatalf("Expects ongoing activity %d, got %+v", started.Id, ongoing)
+	}
+
+	var closed ActivityWarnings
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing/close", `{"Notes": "n2"}`), http.StatusOK, &closed)
+	if closed.Id != started.Id || closed.End == nil || closed.Notes != "n1n2" {
+		t.Fatalf("Got closed activity %+v", closed.Activity)
+	}
+
+	var titles []string
+	assertJson(t, request(handler, "GET", "/api/v1/titles?rank=frecency", ""), http.StatusOK, &titles)
+	if len(titles) != 1 || titles[0] != "work: a" {
+		t.Fatalf("Got titles %v", titles)
+	}
+	assertJson(t, request(handler, "GET", "/api/v1/titles?rank=x", ""), http.StatusBadRequest, &e)
+
+	var tags []TagUsage
+	assertJson(t, request(handler, "GET", "/api/v1/tags", ""), http.StatusOK, &tags)
+	if len(tags) != 1 || tags[0].Name != "work" || tags[0].Count != 1 {
+		t.Fatalf("Got tags %v", tags)
+	}
+}
+
+func TestApiV1Report(t *testing.T) {
+	_, handler := newTestEnv(t)
+
+	for _, body := range []string{
+		`{"Title": "work: a", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T10:00:00Z", "Billable": true, "Tags": ["x"]}`,
+		`{"Title": "work: b", "Start": "2023-03-01T11:00:00Z", "End": "2023-03-01T11:30:00Z"}`,
+		`{"Title": "work: a", "Start": "2023-03-02T09:00:00Z", "End": "2023-03-02T09:15:00Z"}`,
+	} {
+		assertJson(t, request(handler, "POST", "/api/v1/activities", body), http.StatusCreated, nil)
+	}
+
+	var report Report
+	assertJson(t, request(handler, "GET", "/api/v1/reports?since=2023-02-28T00:00:00Z&until=2023-03-03T00:00:00Z&by=tag", ""), http.StatusOK, &report)
+	if report.Total != 6300 || report.Billable != 3600 || len(report.Days) != 2 {
+		t.Fatalf("Got report %+v", report)
+	}
+	if got := fmt.Sprint(report.Items); got != "[{work 6300 3600} {x 3600 3600}]" {
+		t.Fatalf("Got items %s", got)
+	}
+
+	assertJson(t, request(handler, "GET", "/api/v1/reports?since=2023-02-28T00:00:00Z&until=2023-03-03T00:00:00Z&title=work:%20a", ""), http.StatusOK, &report)
+	if got := fmt.Sprint(report.Items); got != "[{work: a 4500 3600}]" {
+		t.Fatalf("Got items %s", got)
+	}
+
+	var e ErrorBody
+	assertJson(t, request(handler, "GET", "/api/v1/reports?since=2023-02-28", ""), http.StatusBadRequest, &e)
+	assertJson(t, request(handler, "GET", "/api/v1/reports?since=2023-02-28&until=2023-03-01&by=x", ""), http.StatusBadRequest, &e)
+}
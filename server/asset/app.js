@@ -5,15 +5,26 @@ createApp({
             recentTitles: [],
             detailObject: null ,
             ongoing: null,
+            warnings: [],
             error: null,
             newStart: '',
             report: null,
+            searchQuery: '',
+            searchResults: null,
             queryParam: {'dayStart': "", "dayEnd": "", 'viewType': "summary"},
+            // me is the signed in user in multi-user mode, null otherwise
+            me: null,
+            tokens: [],
+            newToken: {'Name': '', 'Scope': 'read'},
+            createdToken: null,
+            teamReport: null,
         }
     },
 
     created() {
+        this.getMe();
         this.getData();
+        this.subscribe();
         // default value for queryParam.dayStart/dayEnd
         let today = this.getDateString(new Date());
         this.queryParam.dayStart = today;
@@ -28,13 +39,72 @@ createApp({
         async getOngoing() {
             const url = '/api/ongoing/';
             this.ongoing = await (await fetch(url)).json();
+            if (this.ongoing != null) {
+                this.warnings = this.ongoing.Warnings;
+            }
         },
 
+        async getMe() {
+            const rep = await fetch('/api/v1/me');
+            if (rep.status == 401) {
+                location.assign('/login');
+            } else if (rep.ok) {
+                this.me = await rep.json();
+                this.getTokens();
+            }
+        },
+        async getTokens() {
+            this.tokens = await (await fetch('/api/v1/tokens')).json();
+        },
+        async createToken() {
+            const rep = await fetch('/api/v1/tokens', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify(this.newToken),
+            });
+            if (rep.ok) {
+                this.createdToken = await rep.json();
+                this.newToken.Name = '';
+                this.getTokens();
+            } else {
+                this.error = (await rep.json()).Error;
+            }
+        },
+        async revokeToken(name) {
+            const rep = await fetch(`/api/v1/tokens/${encodeURIComponent(name)}`, {method: 'DELETE'});
+            if (!rep.ok) {
+                this.error = (await rep.json()).Error;
+            }
+            this.getTokens();
+        },
+        async getTeamReport(dayStart, dayEnd) {
+            const rep = await fetch(`/api/v1/team/report?since=${dayStart}&until=${dayEnd}`);
+            if (rep.ok) {
+                this.teamReport = await rep.json();
+            } else {
+                this.error = (await rep.json()).Error;
+            }
+        },
+        durationString(seconds) {
+            return `${Math.floor(seconds / 3600)}h${Math.floor(seconds % 3600 / 60).toString().padStart(2, '0')}m`;
+        },
         getData() {
             this.getRecent();
             this.getOngoing();
             this.error = null;
         },
+        // subscribe refreshes ongoing and recent titles on changes made elsewhere, such as the CLI or other tabs.
+        // EventSource reconnects by itself if the connection is lost.
+        subscribe() {
+            const source = new EventSource('/api/events');
+            for (const name of ['start', 'close']) {
+                source.addEventListener(name, () => {
+                    this.getRecent();
+                    this.getOngoing();
+                });
+            }
+            source.addEventListener('add', () => this.getRecent());
+        },
         async start(title) {
             if (title == null || title.length == 0) {
                 this.error = "Empty title";
@@ -43,9 +113,10 @@ createApp({
 
             let url = `/api/start/${encodeURI(title)}`;
             await (fetch(url, {method: 'POST'})
-                   .then((rep) => {
+                   .then(async (rep) => {
                        if (rep.ok) {
                            this.getData();
+                           this.warnings = (await rep.json()).Warnings;
                        } else {
                            this.error = `${rep.status}`;
                        }
@@ -54,9 +125,10 @@ createApp({
         async finish() {
             let url = `/api/finish`;
             await (fetch(url, {method: 'POST', body: this.ongoing.Notes})
-                   .then((rep) => {
+                   .then(async (rep) => {
                        if (rep.ok) {
                            this.getData();
+                           this.warnings = (await rep.json()).Warnings;
                        } else {
                            this.error = `${rep.status}`;
                        }
@@ -78,6 +150,31 @@ createApp({
             this.detailObject = null;
           }
         },
+        async search(query) {
+            if (query.trim().length == 0) {
+                this.error = "Empty search query";
+                return;
+            }
+
+            let url = `/api/search?q=${encodeURIComponent(query)}`;
+            await (fetch(url)
+                   .then(async (rep) => {
+                       if (rep.ok) {
+                           this.searchResults = await rep.json();
+                       } else {
+                           this.error = `${rep.status}`;
+                       }
+                   }).catch((err) => this.error = err))
+        },
+        // snippetParts splits snippet into parts, matched words are enclosed by \u0002 and \u0003
+        snippetParts(snippet) {
+            return snippet.split(/(\u0002[^\u0003]*\u0003)/).filter((part) => part.length > 0).map((part) => {
+                if (part.startsWith('\u0002')) {
+                    return {text: part.slice(1, -1), matched: true};
+                }
+                return {text: part, matched: false};
+            });
+        },
         onQuickReport(offset, days) {
             let one_day = 86400000;
             let now_t = new Date().getTime();
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// Scopes of routes in addition to the scopes of tokens.
+const (
+	// scopePublic routes need no authentication.
+	scopePublic store.TokenScope = ""
+	// scopeWeb routes of the web interface need basic auth if it is configured, or signing in in
+	// multi-user mode. With tokens only, they need a token as the API does, since pages can't send
+	// tokens in their API requests.
+	scopeWeb store.TokenScope = "web"
+	// scopeAdmin routes need ScopeRead, of admins in multi-user mode.
+	scopeAdmin store.TokenScope = "admin"
+	// scopeShared routes change settings shared by all users, they need ScopeWrite, of admins in
+	// multi-user mode.
+	scopeShared store.TokenScope = "shared"
+)
+
+const authRealm = "ticktock"
+
+func (env *Env) authEnabled() bool {
+	return env.Auth || env.BasicAuth != "" || env.MultiUser
+}
+
+// authenticate returns the scope granted to the request, empty if it is not authenticated, and its
+// user in multi-user mode. Basic auth and sessions grant read-write access, and tokens grant their
+// scopes. In multi-user mode, tokens of the owner of the db are not accepted.
+func (env *Env) authenticate(r *http.Request) (store.TokenScope, *store.User, error) {
+	if env.MultiUser {
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if id, ok := env.sessions.find(cookie.Value); ok {
+				return env.userScope(id, store.ScopeWrite)
+			}
+		}
+	} else if user, password, ok := r.BasicAuth(); ok {
+		if env.BasicAuth != "" && subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(env.BasicAuth)) == 1 {
+			return store.ScopeWrite, nil, nil
+		}
+		return "", nil, nil
+	}
+
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", nil, nil
+	}
+
+	token, err := env.Store.FindToken(store.HashToken(strings.TrimSpace(secret)))
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !env.MultiUser {
+		return token.Scope, nil, nil
+	}
+	if token.User == 0 {
+		return "", nil, nil
+	}
+	return env.userScope(token.User, token.Scope)
+}
+
+// userScope returns the scope granted to the user of id, empty if the user is removed.
+func (env *Env) userScope(id int64, scope store.TokenScope) (store.TokenScope, *store.User, error) {
+	user, err := env.Store.GetUser(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return scope, user, nil
+}
+
+// authorize wraps handle of the route requiring the scope.
+func (env *Env) authorize(scope store.TokenScope, handle httprouter.Handle) httprouter.Handle {
+	if scope == scopePublic || !env.authEnabled() {
+		return handle
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		granted, user, err := env.authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if granted == "" && scope == scopeWeb && env.MultiUser {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if granted == "" {
+			challenge := "Bearer realm=\"" + authRealm + "\""
+			if env.BasicAuth != "" {
+				challenge = "Basic realm=\"" + authRealm + "\", " + challenge
+			}
+			msg := "authentication required"
+			if scope == scopeWeb && env.BasicAuth == "" {
+				msg += ", the web interface is only available with basic auth or in multi-user mode"
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeJsonStatus(w, http.StatusUnauthorized, ErrorBody{Error: msg})
+			return
+		}
+
+		required := scope
+		if scope == scopeAdmin || scope == scopeShared {
+			if user != nil && !user.Admin {
+				writeJsonStatus(w, http.StatusForbidden, ErrorBody{Error: "only admins can " + r.Method + " " + r.URL.Path})
+				return
+			}
+			required = store.ScopeRead
+			if scope == scopeShared {
+				required = store.ScopeWrite
+			}
+		}
+		if scope != scopeWeb && !granted.Allows(required) {
+			writeJsonStatus(w, http.StatusForbidden, ErrorBody{Error: "token of scope " + string(granted) + " can not " + r.Method + " " + r.URL.Path})
+			return
+		}
+
+		if user != nil {
+			r = withUser(r, user)
+		}
+		handle(w, r, ps)
+	}
+}
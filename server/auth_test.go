@@ -0,0 +1,246 @@
+package server
+
+import (
+	"github.com/cranej/ticktock/store"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func addToken(t *testing.T, env *Env, name string, scope store.TokenScope) string {
+	t.Helper()
+
+	secret, err := store.NewTokenSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Store.AddToken(&store.Token{Name: name, Scope: scope, Created: time.Now()}, store.HashToken(secret)); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestAuth(t *testing.T) {
+	env, _ := newTestEnv(t)
+	env.Auth = true
+	handler := env.Handler()
+
+	read, write := addToken(t, env, "read", store.ScopeRead), addToken(t, env, "write", store.ScopeWrite)
+	s := loadSpec(t, handler)
+
+	for _, c := range []struct {
+		method, path, auth string
+		status             int
+	}{
+		{"GET", "/version", "", 200},
+		{"GET", "/api/openapi.json", "", 200},
+		{"GET", "/", "", 401},
+		{"GET", "/static/app.js", "", 401},
+		{"GET", "/", "Bearer " + read, 200},
+		{"GET", "/api/v1/ongoing", "", 401},
+		{"GET", "/api/v1/ongoing", "Bearer wrong", 401},
+		{"GET", "/api/v1/ongoing", "Basic " + "dTpw", 401},
+		{"GET", "/api/v1/ongoing", "Bearer " + read, 200},
+		{"GET", "/api/ongoing", "Bearer " + write, 200},
+		{"POST", "/api/v1/ongoing/close", "Bearer " + read, 403},
+		{"POST", "/api/finish", "Bearer " + read, 403},
+		{"POST", "/api/v1/ongoing/close", "Bearer " + write, 404},
+	} {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(""))
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("%s %s with %q: expects %d, got %d: %s", c.method, c.path, c.auth, c.status, w.Code, w.Body.String())
+		}
+		s.checkResponse(t, c.method, c.path, "", w)
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Fatalf("Expects Bearer challenge, got %s", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	if err := env.Store.RevokeToken("write"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/v1/ongoing", nil)
+	req.Header.Set("Authorization", "Bearer "+write)
+	w := httptest.NewRecorder()
+	if handler.ServeHTTP(w, req); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expects revoked token unauthorized, got %d", w.Code)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	env, _ := newTestEnv(t)
+	env.BasicAuth = "me:secret"
+	handler := env.Handler()
+	read := addToken(t, env, "read", store.ScopeRead)
+	s := loadSpec(t, handler)
+
+	for _, c := range []struct {
+		method, path, user, password, token string
+		status                              int
+	}{
+		{"GET", "/version", "", "", "", 200},
+		{"GET", "/", "", "", "", 401},
+		{"GET", "/static/app.js", "me", "wrong", "", 401},
+		{"GET", "/", "me", "secret", "", 200},
+		{"POST", "/api/v1/ongoing", "me", "secret", "", 400},
+		{"GET", "/api/v1/ongoing", "", "", read, 200},
+		{"GET", "/api/v1/ongoing", "", "", "", 401},
+	} {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(""))
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("%s %s: expects %d, got %d: %s", c.method, c.path, c.status, w.Code, w.Body.String())
+		}
+		s.checkResponse(t, c.method, c.path, "", w)
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+			t.Fatalf("Expects Basic challenge, got %s", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func newMultiUserEnv(t *testing.T, hooks ...Webhook) (*Env, http.Handler) {
+	t.Helper()
+
+	ss, err := store.NewSqliteStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+
+	env := &Env{Store: ss, MultiUser: true, Webhooks: hooks, LogOutput: io.Discard}
+	return env, env.Handler()
+}
+
+// addUser adds the user whose password is the name.
+func addUser(t *testing.T, env *Env, name string, admin bool) *store.User {
+	t.Helper()
+
+	user := store.User{Name: name, Admin: admin}
+	if err := env.Store.AddUser(&user, name); err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func TestMultiUser(t *testing.T) {
+	ts, requests := receiver(t, 0)
+	env, handler := newMultiUserEnv(t, Webhook{URL: ts.URL})
+	alice, bob := addUser(t, env, "alice", true), addUser(t, env, "bob", false)
+	aliceToken := addToken(t, env.forUser(alice.Id).env, "phone", store.ScopeWrite)
+	ownerToken := addToken(t, env, "owner", store.ScopeWrite)
+
+	do := func(method, path, body, auth string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == http.MethodPost && strings.HasPrefix(path, "/login") {
+			req.Header.Set(contentTypeHeader, "application/x-www-form-urlencoded")
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/", "", "", nil); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Fatalf("Expects redirect to /login, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	for _, auth := range []string{"", ownerToken} {
+		if w := do("GET", "/api/v1/ongoing", "", auth, nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expects 401 with token %q, got %d", auth, w.Code)
+		}
+	}
+	if w := do("POST", "/login", "name=bob&password=alice", "", nil); w.Header().Get("Location") != "/login?failed=1" || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("Expects failed sign in, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	w := do("POST", "/login", "name=bob&password=bob", "", nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("Expects signed in, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	session := w.Result().Cookies()[0]
+	if w := do("GET", "/", "", "", session); w.Code != http.StatusOK {
+		t.Fatalf("Expects web interface, got %d", w.Code)
+	}
+
+	// each user has an ongoing activity, and events of their own
+	events := env.forUser(bob.Id).env.events.subscribe()
+	assertJson(t, do("POST", "/api/v1/ongoing", `{"Title": "work: a"}`, aliceToken, nil), http.StatusCreated, nil)
+	assertJson(t, do("POST", "/api/v1/ongoing", `{"Title": "work: b"}`, "", session), http.StatusCreated, nil)
+	if event := <-events; event.Activity.Title != "work: b" || len(events) != 0 {
+		t.Fatalf("Expects start event of bob only, got %+v", event)
+	}
+	var ongoing Activity
+	assertJson(t, do("GET", "/api/v1/ongoing", "", "", session), http.StatusOK, &ongoing)
+	if ongoing.Title != "work: b" {
+		t.Fatalf("Expects ongoing activity of bob, got %+v", ongoing)
+	}
+	assertJson(t, do("POST", "/api/v1/ongoing/close", "", aliceToken, nil), http.StatusOK, nil)
+	assertJson(t, do("POST", "/api/v1/ongoing/close", "", "", session), http.StatusOK, nil)
+	env.webhooks.wait()
+	if got := requests(); len(got) != 4 || got[0].body.User != "alice" || got[1].body.User != "bob" {
+		t.Fatalf("Expects events of alice and bob, got %+v", got)
+	}
+
+	var me User
+	assertJson(t, do("GET", "/api/v1/me", "", "", session), http.StatusOK, &me)
+	if me != (User{"bob", false}) {
+		t.Fatalf("Got %+v", me)
+	}
+	today := time.Now().Format(time.DateOnly)
+	assertJson(t, do("GET", "/api/v1/team/report?since="+today+"&until="+today, "", "", session), http.StatusForbidden, nil)
+	var report TeamReport
+	assertJson(t, do("GET", "/api/v1/team/report?since="+today+"&until="+today, "", aliceToken, nil), http.StatusOK, &report)
+	if len(report.Users) != 2 || len(report.Tags) != 1 || report.Tags[0].Tag != "work" || len(report.Tags[0].Users) != 2 {
+		t.Fatalf("Expects work of alice and bob, got %+v", report)
+	}
+
+	// shared settings are changed by admins only
+	aliceRead := addToken(t, env.forUser(alice.Id).env, "watch", store.ScopeRead)
+	goal := `{"Tag": "work", "Period": "week", "Target": 36000}`
+	assertJson(t, do("PUT", "/api/v1/goals", goal, "", session), http.StatusForbidden, nil)
+	assertJson(t, do("DELETE", "/api/v1/rates?Name=work&IsTag=true", "", "", session), http.StatusForbidden, nil)
+	assertJson(t, do("PUT", "/api/v1/goals", goal, aliceRead, nil), http.StatusForbidden, nil)
+	if w := do("PUT", "/api/v1/goals", goal, aliceToken, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expects goal set by alice, got %d %s", w.Code, w.Body.String())
+	}
+	var goals []Goal
+	assertJson(t, do("GET", "/api/v1/goals", "", "", session), http.StatusOK, &goals)
+	if len(goals) != 1 {
+		t.Fatalf("Expects the goal shared with bob, got %+v", goals)
+	}
+
+	if w := do("POST", "/logout", "", "", session); w.Code != http.StatusSeeOther {
+		t.Fatalf("Expects signed out, got %d", w.Code)
+	}
+	if w := do("GET", "/", "", "", session); w.Code != http.StatusSeeOther {
+		t.Fatalf("Expects ended session, got %d", w.Code)
+	}
+	if err := env.Store.RemoveUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if w := do("GET", "/api/v1/ongoing", "", aliceToken, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expects token of removed user unauthorized, got %d", w.Code)
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	contentEventStream = "text/event-stream"
+
+	// streamBuffer is the number of events buffered for each client, events are dropped for clients
+	// which do not keep up.
+	streamBuffer = 16
+	// watchInterval is how often the Store is checked for changes made by others, such as the CLI.
+	watchInterval = 2 * time.Second
+	// keepaliveInterval is how often a comment is sent to idle clients, so that proxies keep the
+	// connection open.
+	keepaliveInterval = 30 * time.Second
+)
+
+// StreamEvent is the data of events sent by /api/events.
+type StreamEvent struct {
+	Event    store.EventType
+	Time     time.Time
+	Activity *Activity
+}
+
+// snapshot is the state of the Store which changes are detected from.
+type snapshot struct {
+	ongoing *store.OpenActivity
+	last    *store.ClosedActivity
+}
+
+// events broadcasts events of activities started, closed or added to clients of /api/events. Events
+// come from changes made through the server, and from changes made by others, which are detected by
+// checking the Store every 'interval' while there are clients.
+type events struct {
+	ss       store.Store
+	interval time.Duration
+	log      *logger
+
+	mu      sync.Mutex
+	clients map[chan *StreamEvent]bool
+	// last is the snapshot to detect changes from, stop stops watching, nil if not watching.
+	last   *snapshot
+	stop   chan struct{}
+	closed bool
+}
+
+func newEvents(ss store.Store, log *logger) *events {
+	return &events{ss: ss, interval: watchInterval, log: log, clients: make(map[chan *StreamEvent]bool)}
+}
+
+// subscribe returns a channel of events, which is closed when the server shuts down. Returns nil if
+// it is shut down already.
+func (e *events) subscribe() chan *StreamEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil
+	}
+
+	if len(e.clients) == 0 {
+		e.last = e.snapshot()
+		e.stop = make(chan struct{})
+		go e.watch(e.stop)
+	}
+	ch := make(chan *StreamEvent, streamBuffer)
+	e.clients[ch] = true
+	return ch
+}
+
+func (e *events) unsubscribe(ch chan *StreamEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.clients[ch] {
+		return
+	}
+	delete(e.clients, ch)
+	if len(e.clients) == 0 {
+		e.unwatch()
+	}
+}
+
+// unwatch stops watching, e.mu must be held.
+func (e *events) unwatch() {
+	if e.stop != nil {
+		close(e.stop)
+	}
+	e.stop, e.last = nil, nil
+}
+
+// close disconnects all clients, and refuses new ones.
+func (e *events) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for ch := range e.clients {
+		close(ch)
+		delete(e.clients, ch)
+	}
+	e.unwatch()
+}
+
+// handle broadcasts changes made through the server.
+func (e *events) handle(event *store.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.broadcast(event.Type, event.Time, event.Activity)
+	if e.stop != nil {
+		e.last = e.snapshot()
+	}
+}
+
+// broadcast sends the event to all clients without blocking, e.mu must be held.
+func (e *events) broadcast(t store.EventType, at time.Time, activity *store.ClosedActivity) {
+	if t != store.EventStart && t != store.EventClose && t != store.EventAdd {
+		return
+	}
+
+	event := &StreamEvent{t, at, newActivity(activity)}
+	for ch := range e.clients {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// snapshot returns the current snapshot, or nil if the Store fails.
+func (e *events) snapshot() *snapshot {
+	ongoing, err := e.ss.Ongoing()
+	if err != nil {
+		e.log.error("failed to watch activities", "error", err)
+		return nil
+	}
+	last, err := e.ss.LastClosed("")
+	if err != nil {
+		e.log.error("failed to watch activities", "error", err)
+		return nil
+	}
+	return &snapshot{ongoing, last}
+}
+
+func (e *events) watch(stop chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		e.mu.Lock()
+		select {
+		case <-stop:
+			e.mu.Unlock()
+			return
+		default:
+		}
+		if current := e.snapshot(); current != nil {
+			if e.last != nil {
+				e.detect(e.last, current)
+			}
+			e.last = current
+		}
+		e.mu.Unlock()
+	}
+}
+
+// detect broadcasts changes between snapshots: the ongoing activity closed, a new one started, and
+// a closed activity added after the last one. e.mu must be held.
+func (e *events) detect(old, current *snapshot) {
+	now := time.Now()
+	closedId := int64(0)
+	if old.ongoing != nil && (current.ongoing == nil || current.ongoing.Id != old.ongoing.Id) {
+		activity, err := e.ss.Get(old.ongoing.Id)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			e.log.error("failed to watch activities", "error", err)
+		}
+		if err == nil && !activity.End.IsZero() {
+			closedId = activity.Id
+			e.broadcast(store.EventClose, now, activity)
+		}
+	}
+
+	if current.last != nil && (old.last == nil || current.last.Id != old.last.Id) && current.last.Id != closedId &&
+		(old.last == nil || current.last.Start.After(old.last.Start)) {
+		e.broadcast(store.EventAdd, now, current.last)
+	}
+
+	if current.ongoing != nil && (old.ongoing == nil || current.ongoing.Id != old.ongoing.Id) {
+		e.broadcast(store.EventStart, now, &store.ClosedActivity{OpenActivity: current.ongoing})
+	}
+}
+
+// apiEvents streams events as Server-Sent Events, the event name is the type of event, and the data
+// is StreamEvent.
+func (env *Env) apiEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := env.events.subscribe()
+	if ch == nil {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer env.events.unsubscribe(ch)
+
+	w.Header().Set(contentTypeHeader, contentEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				env.log.error("failed to encode event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cranej/ticktock/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stream returns events read from /api/events of the server, the channel is closed at the end of the stream.
+func stream(t *testing.T, url string) <-chan StreamEvent {
+	t.Helper()
+
+	resp, err := http.Get(url + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || mediaType(resp.Header.Get(contentTypeHeader)) != contentEventStream {
+		t.Fatalf("Expects event stream, got %d %s", resp.StatusCode, resp.Header.Get(contentTypeHeader))
+	}
+
+	events := make(chan StreamEvent, 10)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		name := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			if value, ok := strings.CutPrefix(line, "event: "); ok {
+				name = value
+			} else if value, ok := strings.CutPrefix(line, "data: "); ok {
+				var event StreamEvent
+				if err := json.Unmarshal([]byte(value), &event); err != nil || string(event.Event) != name {
+					t.Errorf("Invalid event %s: %s", name, value)
+				}
+				events <- event
+			}
+		}
+	}()
+	return events
+}
+
+func expectEvent(t *testing.T, events <-chan StreamEvent, want store.EventType, title string) *Activity {
+	t.Helper()
+
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatalf("Expects %s event of %s, the stream ended", want, title)
+		}
+		if e.Event != want || e.Activity.Title != title {
+			t.Fatalf("Expects %s event of %s, got: %s %+v", want, title, e.Event, e.Activity)
+		}
+		return e.Activity
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expects %s event of %s, got nothing", want, title)
+	}
+	return nil
+}
+
+func TestEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	ss, err := store.NewSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the other one, as the CLI
+	other, err := store.NewSqliteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &Env{Store: ss}
+	ts := httptest.NewServer(env.Handler())
+	defer ts.Close()
+	env.events.interval = 10 * time.Millisecond
+	events := stream(t, ts.URL)
+
+	resp, err := http.Post(ts.URL+"/api/v1/ongoing", contentJson, strings.NewReader(`{"Title": "work: a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if a := expectEvent(t, events, store.EventStart, "work: a"); a.End != nil {
+		t.Fatalf("Expects open activity, got: %+v", a)
+	}
+
+	if _, err := other.CloseActivity("done"); err != nil {
+		t.Fatal(err)
+	}
+	if a := expectEvent(t, events, store.EventClose, "work: a"); a.End == nil || a.Notes != "done" {
+		t.Fatalf("Expects closed activity with notes, got: %+v", a)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	added := store.ClosedActivity{OpenActivity: &store.OpenActivity{Title: "work: b", Start: now.Add(time.Minute)}, End: now.Add(2 * time.Minute)}
+	if err := other.Add(&added); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, store.EventAdd, "work: b")
+
+	if err := other.StartTitle("work: c", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, store.EventStart, "work: c")
+
+	env.events.close()
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Fatalf("Expects the stream ended, got: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expects the stream ended")
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// Health is the response of /healthz and /readyz.
+type Health struct {
+	Status string
+}
+
+// healthz reports that the server is up.
+func healthz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJsonStatus(w, http.StatusOK, Health{"ok"})
+}
+
+// readyz reports whether the server can serve requests, which it can't if the database can't be
+// read, or it is shutting down.
+func (env *Env) readyz(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if env.shuttingDown.Load() {
+		writeJsonStatus(w, http.StatusServiceUnavailable, ErrorBody{Error: "server is shutting down"})
+		return
+	}
+	if err := env.Store.Ping(); err != nil {
+		env.log.warn("database is not ready", "error", err)
+		writeJsonStatus(w, http.StatusServiceUnavailable, ErrorBody{Error: "database is not ready"})
+		return
+	}
+	writeJsonStatus(w, http.StatusOK, Health{"ok"})
+}
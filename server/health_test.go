@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	env, handler := newTestEnv(t)
+
+	var health Health
+	assertJson(t, request(handler, "GET", "/healthz", ""), http.StatusOK, &health)
+	if health.Status != "ok" {
+		t.Fatalf("Expects ok, got %s", health.Status)
+	}
+	assertJson(t, request(handler, "GET", "/readyz", ""), http.StatusOK, nil)
+
+	env.shuttingDown.Store(true)
+	assertJson(t, request(handler, "GET", "/readyz", ""), http.StatusServiceUnavailable, nil)
+	env.shuttingDown.Store(false)
+
+	if err := env.Store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var e ErrorBody
+	assertJson(t, request(handler, "GET", "/readyz", ""), http.StatusServiceUnavailable, &e)
+	if e.Error != "database is not ready" {
+		t.Fatalf("Got %s", e.Error)
+	}
+	assertJson(t, request(handler, "GET", "/healthz", ""), http.StatusOK, nil)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix is the prefix of addresses of unix domain sockets, as 'unix:/run/ticktock.sock'.
+const unixPrefix = "unix:"
+
+// DefaultSocketMode is the permissions of unix sockets, which allows the owner only.
+const DefaultSocketMode fs.FileMode = 0600
+
+// shutdownTimeout is how long in-flight requests are waited for when shutting down.
+const shutdownTimeout = 10 * time.Second
+
+// certValidity is the validity of self-signed certificates.
+const certValidity = 5 * 365 * 24 * time.Hour
+
+// listen listens on addr, which is a TCP address, or a unix socket path prefixed by 'unix:'. Stale
+// socket files are removed.
+func (env *Env) listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("another server is listening on " + path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// the socket is created with permissions of the owner only, not those of the umask until it is
+	// chmod-ed
+	var l net.Listener
+	err := withUmask(0177, func() (err error) {
+		l, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mode := env.SocketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Run serves on addr until ctx is done, then shuts down gracefully: stops listening, disconnects
+// event streams, and waits for in-flight requests and webhook deliveries for at most 10 seconds. addr is a TCP address, or a unix
+// socket path prefixed by 'unix:'. HTTPS is served if TLSCert and TLSKey are set.
+func (env *Env) Run(ctx context.Context, addr string) error {
+	l, err := env.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: env.Handler()}
+	srv.ErrorLog = log.New(env.log.writer(LogError), "", 0)
+	// event streams never end by themselves
+	srv.RegisterOnShutdown(env.closeEvents)
+	errs := make(chan error, 1)
+	go func() {
+		if env.TLSCert != "" || env.TLSKey != "" {
+			env.log.info("serving HTTPS", "addr", addr)
+			errs <- srv.ServeTLS(l, env.TLSCert, env.TLSKey)
+		} else {
+			env.log.info("serving", "addr", addr)
+			errs <- srv.Serve(l)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	env.log.info("shutting down")
+	env.shuttingDown.Store(true)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if env.webhooks != nil {
+		done := make(chan struct{})
+		go func() {
+			env.webhooks.wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+			env.log.warn("webhook deliveries not done are dropped")
+		}
+	}
+	return nil
+}
+
+// GenerateCert writes a self-signed certificate for hosts (names or IPs) and its private key to
+// certFile and keyFile, which must not exist.
+func GenerateCert(certFile, keyFile string, hosts []string) error {
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Lstat(file); err == nil {
+			return fmt.Errorf("%s already exists", file)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ticktock"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return writeNewFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// writeNewFile writes data to name, failing if it exists.
+func writeNewFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CertHosts returns hosts of the self-signed certificate for serving on addr: the host of addr, or
+// the host name if addr listens on all interfaces, and localhost.
+func CertHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if strings.HasPrefix(addr, unixPrefix) {
+		return hosts
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return hosts
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil || host == "" {
+			return hosts
+		}
+	}
+	for _, h := range hosts {
+		if h == host {
+			return hosts
+		}
+	}
+	return append([]string{host}, hosts...)
+}
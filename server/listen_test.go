@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunUnixTLS(t *testing.T) {
+	dir := t.TempDir()
+	env, _ := newTestEnv(t)
+	env.TLSCert, env.TLSKey = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	env.SocketMode = 0660
+	if err := GenerateCert(env.TLSCert, env.TLSKey, CertHosts("unix:x")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(env.TLSKey); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expects key of mode 0600, got (%v, %v)", info, err)
+	}
+	if err := GenerateCert(env.TLSCert, filepath.Join(dir, "other.key"), CertHosts("unix:x")); err == nil {
+		t.Fatal("Expects error of an existing certificate")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.key")); !os.IsNotExist(err) {
+		t.Fatalf("Expects no key written, got %v", err)
+	}
+
+	// a stale socket file is removed
+	socket := filepath.Join(dir, "ticktock.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- env.Run(ctx, unixPrefix+socket) }()
+
+	pool := x509.NewCertPool()
+	pem, err := os.ReadFile(env.TLSCert)
+	if err != nil || !pool.AppendCertsFromPEM(pem) {
+		t.Fatalf("Invalid certificate: %v", err)
+	}
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://localhost/api/v1/tags"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "[]" {
+		t.Fatalf("Got %d: %s", resp.StatusCode, body)
+	}
+
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0660 {
+		t.Fatalf("Expects socket of mode 0660, got (%v, %v)", info, err)
+	}
+	if err := env.Run(context.Background(), unixPrefix+socket); err == nil {
+		t.Fatal("Expects error of listening on a socket in use")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expects Run returns after cancelled")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("Expects socket removed, got %v", err)
+	}
+}
+
+func TestCertHosts(t *testing.T) {
+	hostname, _ := os.Hostname()
+	for addr, want := range map[string]string{
+		"unix:/tmp/s":      "localhost,127.0.0.1,::1",
+		"127.0.0.1:8080":   "localhost,127.0.0.1,::1",
+		"example.com:8443": "example.com,localhost,127.0.0.1,::1",
+		"192.168.1.2:8443": "192.168.1.2,localhost,127.0.0.1,::1",
+		":8080":            hostname + ",localhost,127.0.0.1,::1",
+	} {
+		if got := strings.Join(CertHosts(addr), ","); got != want {
+			t.Errorf("%s: got %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestWithUmask(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "f")
+	if err := withUmask(0177, func() error { return os.WriteFile(name, nil, 0666) }); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expects file of mode 0600, got (%v, %v)", info, err)
+	}
+}
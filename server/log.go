@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIdHeader is the header of ids of requests, see logRequests.
+const requestIdHeader = "X-Request-Id"
+
+// LogLevel is the severity of messages logged by the server.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota - 1
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l-LogDebug]
+}
+
+// ParseLogLevel returns the LogLevel of the name, which is one of debug, info, warn and error.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if n == strings.ToLower(name) {
+			return LogDebug + LogLevel(i), nil
+		}
+	}
+	return LogInfo, fmt.Errorf("invalid log level %s, expects one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// logger writes messages of at least its level as JSON lines, with 'time', 'level' and 'msg'
+// followed by fields of the message.
+type logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level LogLevel
+}
+
+func newLogger(out io.Writer, level LogLevel) *logger {
+	return &logger{out: out, level: level}
+}
+
+// log writes the message if its level is enabled, fields are pairs of names and values. Errors are
+// written as their messages, and durations in seconds.
+func (l *logger) log(level LogLevel, msg string, fields ...any) {
+	if level < l.level {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeLogValue(&b, time.Now().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeLogValue(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeLogValue(&b, msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		b.WriteByte(',')
+		writeLogValue(&b, fmt.Sprint(fields[i]))
+		b.WriteByte(':')
+		writeLogValue(&b, fields[i+1])
+	}
+	b.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+func writeLogValue(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case error:
+		writeLogValue(b, v.Error())
+		return
+	case time.Duration:
+		writeLogValue(b, v.Seconds())
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+func (l *logger) debug(msg string, fields ...any) { l.log(LogDebug, msg, fields...) }
+func (l *logger) info(msg string, fields ...any)  { l.log(LogInfo, msg, fields...) }
+func (l *logger) warn(msg string, fields ...any)  { l.log(LogWarn, msg, fields...) }
+func (l *logger) error(msg string, fields ...any) { l.log(LogError, msg, fields...) }
+
+// writer returns a writer which logs each write as a message of the level, for http.Server.ErrorLog.
+func (l *logger) writer(level LogLevel) io.Writer {
+	return logWriter{l, level}
+}
+
+type logWriter struct {
+	l     *logger
+	level LogLevel
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.log(w.level, strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// quietPaths are paths of probes and scrapes, which are requested so often that they are logged at
+// LogDebug.
+var quietPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// logRequests wraps the handler to log requests when they are done, at LogError if the status is
+// 5xx. Each request has an id, which is its X-Request-Id header if valid, or a random one, and is
+// sent back in the same header.
+func (env *Env) logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		level := LogInfo
+		if sw.code() >= http.StatusInternalServerError {
+			level = LogError
+		} else if quietPaths[r.URL.Path] {
+			level = LogDebug
+		}
+		env.log.log(level, "request", "id", id, "method", r.Method, "path", r.URL.Path, "status", sw.code(),
+			"duration", time.Since(start), "bytes", sw.bytes, "remote", r.RemoteAddr)
+	})
+}
+
+// validRequestId reports whether the id from a client is not empty, at most 64 characters of
+// letters, digits, '-', '_' and '.', so that it is safe to log and send back.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestId returns 16 random hex digits.
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
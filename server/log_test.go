@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/cranej/ticktock/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logLines decodes lines of the log.
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	out.Reset()
+	return lines
+}
+
+func TestLogRequests(t *testing.T) {
+	ss, err := store.NewSqliteStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+
+	var out bytes.Buffer
+	env := &Env{Store: ss, LogOutput: &out}
+	handler := env.Handler()
+
+	req := httptest.NewRequest("GET", "/api/v1/ongoing", nil)
+	req.Header.Set(requestIdHeader, "abc-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIdHeader); got != "abc-1" {
+		t.Fatalf("Expects the request id abc-1, got %s", got)
+	}
+	lines := logLines(t, &out)
+	if len(lines) != 1 {
+		t.Fatalf("Expects a line, got %v", lines)
+	}
+	line := lines[0]
+	if line["level"] != "info" || line["msg"] != "request" || line["id"] != "abc-1" || line["method"] != "GET" ||
+		line["path"] != "/api/v1/ongoing" || line["status"] != float64(http.StatusOK) {
+		t.Fatalf("Got %v", line)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, line["time"].(string)); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := line["duration"].(float64); !ok || d < 0 {
+		t.Fatalf("Expects duration in seconds, got %v", line["duration"])
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/ongoing", strings.NewReader(`{"Title": ""}`))
+	req.Header.Set(requestIdHeader, "not valid")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	id := w.Header().Get(requestIdHeader)
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+		t.Fatalf("Expects a random request id, got %q", id)
+	}
+	lines = logLines(t, &out)
+	if len(lines) != 1 || lines[0]["id"] != id || lines[0]["status"] != float64(http.StatusBadRequest) {
+		t.Fatalf("Got %v", lines)
+	}
+
+	request(handler, "GET", "/healthz", "")
+	request(handler, "GET", "/readyz", "")
+	if lines := logLines(t, &out); len(lines) != 0 {
+		t.Fatalf("Expects probes not logged at info, got %v", lines)
+	}
+
+	env.log.level = LogDebug
+	request(handler, "GET", "/healthz", "")
+	if lines := logLines(t, &out); len(lines) != 1 || lines[0]["level"] != "debug" || lines[0]["path"] != "/healthz" {
+		t.Fatalf("Got %v", lines)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	l := newLogger(&out, LogWarn)
+	l.info("hidden")
+	l.warn("shown", "error", errors.New("failed"), "wait", 1500*time.Millisecond, "n", 2, "s", `"quoted"`)
+	l.error("odd", "dangling")
+
+	lines := logLines(t, &out)
+	if len(lines) != 2 {
+		t.Fatalf("Expects 2 lines, got %v", lines)
+	}
+	if m := lines[0]; m["level"] != "warn" || m["msg"] != "shown" || m["error"] != "failed" || m["wait"] != 1.5 ||
+		m["n"] != float64(2) || m["s"] != `"quoted"` {
+		t.Fatalf("Got %v", m)
+	}
+	if m := lines[1]; m["level"] != "error" || len(m) != 3 {
+		t.Fatalf("Got %v", m)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		got, err := ParseLogLevel(strings.ToUpper(level.String()))
+		if err != nil || got != level {
+			t.Fatalf("%s: got %s, %v", level, got, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatal("Expects an error of invalid level")
+	}
+}
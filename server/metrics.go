@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// requestBuckets are upper bounds in seconds of buckets of request durations.
+	requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// storeBuckets are upper bounds in seconds of buckets of store operation durations.
+	storeBuckets = []float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.5, 1}
+)
+
+// streamingRoutes are routes of event streams, which last as long as clients are connected, so
+// their durations are not observed.
+var streamingRoutes = map[string]bool{"/api/events": true}
+
+// histogram counts observations in buckets, the counts are not cumulative.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+// requestKey is the labels of requests.
+type requestKey struct {
+	method, route string
+	status        int
+}
+
+// routeKey is the labels of durations of requests.
+type routeKey struct {
+	method, route string
+}
+
+// metrics are metrics of the server exposed by /metrics in Prometheus text format.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[routeKey]*histogram
+	store     map[string]*histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[routeKey]*histogram),
+		store:     make(map[string]*histogram),
+	}
+}
+
+// observeStore records the duration of the store operation, see store.WithTiming.
+func (m *metrics) observeStore(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.store[op]
+	if !ok {
+		h = newHistogram(storeBuckets)
+		m.store[op] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// instrument wraps handle of the route to count requests and their durations, except durations of
+// streamingRoutes.
+func (m *metrics) instrument(method, route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		handle(sw, r, ps)
+		d := time.Since(start)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.requests[requestKey{method, route, sw.code()}]++
+		if streamingRoutes[route] {
+			return
+		}
+		key := routeKey{method, route}
+		h, ok := m.durations[key]
+		if !ok {
+			h = newHistogram(requestBuckets)
+			m.durations[key] = h
+		}
+		h.observe(d.Seconds())
+	}
+}
+
+// statusWriter records the status and the size of the response. It is an http.Flusher for event
+// streams.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// code returns the status written, 200 if nothing is written.
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// metricsWriter writes metrics in Prometheus text format.
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+// header writes HELP and TYPE of the metric.
+func (w metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample of the metric, labels are pairs of names and values.
+func (w metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	fmt.Fprintf(w, " %s\n", formatValue(value))
+}
+
+// histogram writes buckets, sum and count of the histogram.
+func (w metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		w.sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatValue(bound))...)
+	}
+	w.sample(name+"_bucket", float64(h.count), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	w.sample(name+"_sum", h.sum, labels...)
+	w.sample(name+"_count", float64(h.count), labels...)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeServer writes metrics of requests and store operations.
+func (m *metrics) writeServer(w metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	w.header("ticktock_http_requests_total", "counter", "Number of HTTP requests by route and status.")
+	for _, key := range requests {
+		w.sample("ticktock_http_requests_total", float64(m.requests[key]), "method", key.method, "route", key.route, "status", strconv.Itoa(key.status))
+	}
+
+	routes := make([]routeKey, 0, len(m.durations))
+	for key := range m.durations {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].route != routes[j].route {
+			return routes[i].route < routes[j].route
+		}
+		return routes[i].method < routes[j].method
+	})
+	w.header("ticktock_http_request_duration_seconds", "histogram", "Duration of HTTP requests by route.")
+	for _, key := range routes {
+		w.histogram("ticktock_http_request_duration_seconds", m.durations[key], "method", key.method, "route", key.route)
+	}
+
+	ops := make([]string, 0, len(m.store))
+	for op := range m.store {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	w.header("ticktock_store_operation_duration_seconds", "histogram", "Duration of operations of the store.")
+	for _, op := range ops {
+		w.histogram("ticktock_store_operation_duration_seconds", m.store[op], "operation", op)
+	}
+}
+
+// activityMetrics are metrics of activities of a Store, labels are of the user in multi-user mode.
+type activityMetrics struct {
+	labels           []string
+	ongoing, elapsed float64
+	// today is the time tracked today by tag
+	today map[string]time.Duration
+}
+
+// collectActivities returns metrics of the ongoing activity and time tracked today of the Store,
+// labeled by the user if it is not empty.
+func collectActivities(ss store.Store, user string, now time.Time) (*activityMetrics, error) {
+	m := activityMetrics{today: make(map[string]time.Duration)}
+	if user != "" {
+		m.labels = []string{"user", user}
+	}
+
+	ongoing, err := ss.Ongoing()
+	if err != nil {
+		return nil, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	activities, err := ss.Closed(today.UTC(), now.UTC(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range activities {
+		for _, tag := range activities[i].AllTags() {
+			m.today[tag] += activities[i].End.Sub(activities[i].Start)
+		}
+	}
+	if ongoing != nil {
+		m.ongoing, m.elapsed = 1, now.Sub(ongoing.Start).Seconds()
+		start := ongoing.Start
+		if start.Before(today) {
+			start = today
+		}
+		for _, tag := range ongoing.AllTags() {
+			m.today[tag] += now.Sub(start)
+		}
+	}
+	return &m, nil
+}
+
+// activities writes metrics of activities, samples of each metric are written together.
+func (w metricsWriter) activities(all []*activityMetrics) {
+	w.header("ticktock_activity_ongoing", "gauge", "Whether an activity is ongoing.")
+	for _, m := range all {
+		w.sample("ticktock_activity_ongoing", m.ongoing, m.labels...)
+	}
+	w.header("ticktock_activity_ongoing_seconds", "gauge", "Elapsed seconds of the ongoing activity, 0 if there is none.")
+	for _, m := range all {
+		w.sample("ticktock_activity_ongoing_seconds", m.elapsed, m.labels...)
+	}
+	w.header("ticktock_tracked_today_seconds", "gauge", "Seconds of activities started today by tag, including the ongoing one.")
+	for _, m := range all {
+		tags := make([]string, 0, len(m.today))
+		for tag := range m.today {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			w.sample("ticktock_tracked_today_seconds", m.today[tag].Seconds(), append(m.labels[:len(m.labels):len(m.labels)], "tag", tag)...)
+		}
+	}
+}
+
+// apiMetrics exposes metrics in Prometheus text format. In multi-user mode, metrics of activities
+// are of all users, labeled by their names.
+func (env *Env) apiMetrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	now := time.Now()
+	var all []*activityMetrics
+	if env.MultiUser {
+		users, err := env.Store.Users()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, user := range users {
+			m, err := collectActivities(env.Store.ForUser(user.Id), user.Name, now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			all = append(all, m)
+		}
+	} else {
+		m, err := collectActivities(env.Store, "", now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		all = append(all, m)
+	}
+
+	var b strings.Builder
+	out := metricsWriter{bufio.NewWriter(&b)}
+	env.metrics.writeServer(out)
+	out.activities(all)
+	out.Flush()
+
+	w.Header().Set(contentTypeHeader, contentMetrics)
+	io.WriteString(w, b.String())
+}
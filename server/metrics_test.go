@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"github.com/cranej/ticktock/store"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleLine matches lines of samples in Prometheus text format.
+var sampleLine = regexp.MustCompile(`^[a-z_]+(\{([a-z_]+="([^"\\]|\\.)*",?)+\})? ([-+0-9.e]+|\+Inf)$`)
+
+// assertMetrics asserts that the body is in Prometheus text format, and has all lines of want.
+func assertMetrics(t *testing.T, body string, want ...string) {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") && !sampleLine.MatchString(line) {
+			t.Fatalf("Invalid line %q", line)
+		}
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w+"\n") {
+			t.Fatalf("Expects %q in:\n%s", w, body)
+		}
+	}
+}
+
+// metricValue returns the value of the sample of name with labels, as 'a{b="c"}'.
+func metricValue(t *testing.T, body, sample string) float64 {
+	t.Helper()
+
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	t.Fatalf("No %s in:\n%s", sample, body)
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	env, handler := newTestEnv(t)
+
+	if err := env.Store.Start(&store.OpenActivity{Title: "work: a", Start: time.Now().Add(-time.Minute).Truncate(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing", `{"Title": "work: b"}`), http.StatusConflict, nil)
+	assertJson(t, request(handler, "POST", "/api/v1/ongoing", `{"Title": ""}`), http.StatusBadRequest, nil)
+	// the stream ends when the client is gone
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/events", nil).WithContext(ctx))
+
+	w := request(handler, "GET", "/metrics", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get(contentTypeHeader), "text/plain; version=0.0.4") {
+		t.Fatalf("Got %d %s", w.Code, w.Header().Get(contentTypeHeader))
+	}
+	body := w.Body.String()
+	assertMetrics(t, body,
+		`# TYPE ticktock_http_requests_total counter`,
+		`ticktock_http_requests_total{method="POST",route="/api/v1/ongoing",status="400"} 1`,
+		`ticktock_http_requests_total{method="POST",route="/api/v1/ongoing",status="409"} 1`,
+		`ticktock_http_request_duration_seconds_bucket{method="POST",route="/api/v1/ongoing",le="+Inf"} 2`,
+		`ticktock_http_request_duration_seconds_count{method="POST",route="/api/v1/ongoing"} 2`,
+		`# TYPE ticktock_store_operation_duration_seconds histogram`,
+		`ticktock_store_operation_duration_seconds_count{operation="Start"} 1`,
+		`ticktock_store_operation_duration_seconds_count{operation="StartTitle"} 1`,
+		`ticktock_http_requests_total{method="GET",route="/api/events",status="200"} 1`,
+		`ticktock_activity_ongoing 1`,
+	)
+	if strings.Contains(body, `ticktock_http_request_duration_seconds_count{method="GET",route="/api/events"}`) {
+		t.Fatalf("Expects no durations of event streams in:\n%s", body)
+	}
+	elapsed := metricValue(t, body, "ticktock_activity_ongoing_seconds")
+	if elapsed < 60 || elapsed > 120 {
+		t.Fatalf("Expects about a minute elapsed, got %v", elapsed)
+	}
+	if today := metricValue(t, body, `ticktock_tracked_today_seconds{tag="work"}`); today <= 0 || today > elapsed {
+		t.Fatalf("Expects time of work today, got %v", today)
+	}
+}
+
+func TestMetricsMultiUser(t *testing.T) {
+	env, handler := newMultiUserEnv(t)
+	alice, bob := addUser(t, env, "alice", true), addUser(t, env, "bob", false)
+	tokens := map[string]string{
+		"alice": addToken(t, env.forUser(alice.Id).env, "phone", "read"),
+		"bob":   addToken(t, env.forUser(bob.Id).env, "phone", "write"),
+	}
+	do := func(method, path, body, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tokens[user])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assertJson(t, do("POST", "/api/v1/ongoing", `{"Title": "gym"}`, "bob"), http.StatusCreated, nil)
+	assertJson(t, do("GET", "/metrics", "", "bob"), http.StatusForbidden, nil)
+	w := do("GET", "/metrics", "", "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got %d: %s", w.Code, w.Body.String())
+	}
+	assertMetrics(t, w.Body.String(),
+		`ticktock_http_requests_total{method="GET",route="/metrics",status="403"} 1`,
+		`ticktock_activity_ongoing{user="alice"} 0`,
+		`ticktock_activity_ongoing{user="bob"} 1`,
+	)
+	metricValue(t, w.Body.String(), `ticktock_tracked_today_seconds{user="bob",tag="gym"}`)
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type spec struct {
+	Paths      map[string]map[string]*operation
+	Components struct {
+		Schemas map[string]*schema
+	}
+}
+
+type operation struct {
+	// Security is nil if not specified, empty if no authentication is needed
+	Security    *[]map[string][]string
+	Parameters  []parameter
+	RequestBody *struct {
+		Required bool
+		Content  map[string]struct{ Schema *schema }
+	}
+	Responses map[string]struct {
+		Content map[string]struct{ Schema *schema }
+	}
+}
+
+type parameter struct {
+	Name     string
+	In       string
+	Required bool
+	Schema   *schema
+}
+
+// schema is the subset of OpenAPI 3.0 schema used by asset/openapi.json.
+type schema struct {
+	Ref                  string `json:"$ref"`
+	Type                 string
+	Format               string
+	Nullable             bool
+	Enum                 []string
+	Required             []string
+	Properties           map[string]*schema
+	AdditionalProperties *schema
+	Items                *schema
+	AllOf                []*schema
+}
+
+// validate validates the decoded JSON value against the schema. Objects with properties may not
+// have properties not in the schema, so that responses can't drift from the spec.
+func (s *spec) validate(sc *schema, value any, path string) error {
+	if sc.Ref != "" {
+		name := strings.TrimPrefix(sc.Ref, "#/components/schemas/")
+		ref, ok := s.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, sc.Ref)
+		}
+		return s.validate(ref, value, path)
+	}
+
+	if value == nil {
+		if sc.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: unexpected null", path)
+	}
+
+	for _, sub := range sc.AllOf {
+		if err := s.validate(sub, value, path); err != nil {
+			return err
+		}
+	}
+
+	switch sc.Type {
+	case "":
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expects object, got %v", path, value)
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required %s", path, name)
+			}
+		}
+		for name, v := range obj {
+			prop, ok := sc.Properties[name]
+			if !ok {
+				prop = sc.AdditionalProperties
+			}
+			if prop == nil {
+				if len(sc.Properties) > 0 {
+					return fmt.Errorf("%s: unexpected property %s", path, name)
+				}
+				continue
+			}
+			if err := s.validate(prop, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expects array, got %v", path, value)
+		}
+		for i, item := range items {
+			if err := s.validate(sc.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expects string, got %v", path, value)
+		}
+		if sc.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: invalid date-time %s", path, str)
+			}
+		}
+		if len(sc.Enum) > 0 && !contains(sc.Enum, str) {
+			return fmt.Errorf("%s: %s is not one of %v", path, str, sc.Enum)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: expects number, got %v", path, value)
+		}
+		if sc.Type == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s: expects integer, got %v", path, n)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expects boolean, got %v", path, value)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported type %s", path, sc.Type)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// specPath converts path of routes as '/a/:id' and '/a/*path' into '/a/{id}' and '/a/{path}'.
+func specPath(path string) string {
+	return regexp.MustCompile(`[:*]([a-z_]+)`).ReplaceAllString(path, "{$1}")
+}
+
+// find returns the spec path of the operation serving the request path.
+func (s *spec) find(method, path string) (string, *operation) {
+	for p, ops := range s.Paths {
+		segments := strings.Split(p, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, "{") {
+				segments[i] = "[^/]+"
+			} else {
+				segments[i] = regexp.QuoteMeta(segment)
+			}
+		}
+		pattern := "^" + strings.Join(segments, "/") + "$"
+		if op, ok := ops[strings.ToLower(method)]; ok && regexp.MustCompile(pattern).MatchString(path) {
+			return p, op
+		}
+	}
+	return "", nil
+}
+
+func mediaType(contentType string) string {
+	t, _, _ := mime.ParseMediaType(contentType)
+	return t
+}
+
+// check validates the request and response of the operation against the spec. Body of requests
+// expected to be bad requests is not validated.
+func (s *spec) check(op *operation, target, body string, w *httptest.ResponseRecorder) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	for name := range u.Query() {
+		declared := false
+		for _, p := range op.Parameters {
+			declared = declared || (p.In == "query" && p.Name == name)
+		}
+		if !declared {
+			return fmt.Errorf("query parameter %s is not in the spec", name)
+		}
+	}
+
+	if body != "" && op.RequestBody != nil {
+		if content, ok := op.RequestBody.Content[contentJson]; ok && w.Code != http.StatusBadRequest {
+			var v any
+			if err := json.Unmarshal([]byte(body), &v); err != nil {
+				return err
+			}
+			if err := s.validate(content.Schema, v, "request"); err != nil {
+				return err
+			}
+		}
+	} else if body != "" {
+		return fmt.Errorf("request body is not in the spec")
+	}
+
+	response, ok := op.Responses[fmt.Sprint(w.Code)]
+	if !ok {
+		return fmt.Errorf("status %d is not in the spec: %s", w.Code, w.Body.String())
+	}
+	if len(response.Content) == 0 {
+		if w.Body.Len() > 0 {
+			return fmt.Errorf("expects no content, got %s", w.Body.String())
+		}
+		return nil
+	}
+
+	ct := mediaType(w.Header().Get(contentTypeHeader))
+	content, ok := response.Content[ct]
+	if !ok {
+		content, ok = response.Content["*/*"]
+	}
+	if !ok {
+		return fmt.Errorf("content type %s of status %d is not in the spec", ct, w.Code)
+	}
+	if ct == contentJson {
+		var v any
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			return fmt.Errorf("invalid JSON %s: %v", w.Body.String(), err)
+		}
+		if err := s.validate(content.Schema, v, "response"); err != nil {
+			return fmt.Errorf("%v: %s", err, w.Body.String())
+		}
+	}
+	return nil
+}
+
+func loadSpec(t *testing.T, handler http.Handler) *spec {
+	t.Helper()
+
+	w := request(handler, "GET", "/api/openapi.json", "")
+	var s spec
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Invalid spec: %v", err)
+	}
+	return &s
+}
+
+// checkResponse validates the response of the request against the spec.
+func (s *spec) checkResponse(t *testing.T, method, target, body string, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	_, op := s.find(method, strings.SplitN(target, "?", 2)[0])
+	if op == nil {
+		t.Fatalf("%s %s is not in the spec", method, target)
+	}
+	if err := s.check(op, target, body, w); err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	env, handler := newTestEnv(t)
+	s := loadSpec(t, handler)
+
+	for _, r := range env.routes() {
+		op, ok := s.Paths[specPath(r.path)][strings.ToLower(r.method)]
+		if !ok {
+			t.Errorf("Route %s %s is not in the spec", r.method, r.path)
+			continue
+		}
+		if public := op.Security != nil && len(*op.Security) == 0; public != (r.scope == scopePublic) {
+			t.Errorf("Route %s %s: security of the spec does not match scope %q", r.method, r.path, r.scope)
+		}
+	}
+
+	covered := make(map[string]bool)
+	s.checkRequests(t, handler, "", covered, []specRequest{
+		{"GET", "/", "", 200},
+		{"GET", "/static/app.js", "", 200},
+		{"GET", "/static/no-such-file", "", 404},
+		{"GET", "/version", "", 200},
+		{"GET", "/api/openapi.json", "", 200},
+
+		{"GET", "/api/v1/ongoing", "", 200},
+		{"GET", "/api/ongoing", "", 200},
+		{"POST", "/api/v1/ongoing/close", "", 404},
+		{"POST", "/api/v1/ongoing", `{"Title": ""}`, 400},
+		{"POST", "/api/v1/ongoing", `{"Title": "work: a", "Notes": "n"}`, 201},
+		{"POST", "/api/v1/ongoing", `{"Title": "work: b"}`, 409},
+		{"GET", "/api/v1/ongoing", "", 200},
+		{"GET", "/api/ongoing", "", 200},
+		{"POST", "/api/start/b", "", 409},
+		{"POST", "/api/v1/ongoing/close", `{"Notes": "closed"}`, 200},
+		{"POST", "/api/v1/ongoing/close", `{"Note": "x"}`, 400},
+		{"POST", "/api/start/gym", "", 200},
+		{"POST", "/api/finish", "notes", 200},
+
+		{"POST", "/api/v1/activities", `{"Title": "work: c", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T10:00:00Z", "Billable": true, "Meta": {"k": "v"}, "Tags": ["x"]}`, 201},
+		{"POST", "/api/v1/activities", `{"Title": "work: c", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T10:00:00Z"}`, 409},
+		{"POST", "/api/v1/activities", `{"Title": "work: d", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T08:00:00Z"}`, 400},
+		{"POST", "/api/v1/activities", `{"Title": "work: d", "Start": "2023-03-02T09:00:00Z", "End": "2023-03-02T10:00:00Z"}`, 201},
+		{"GET", "/api/v1/activities", "", 200},
+		{"GET", "/api/v1/activities?tag=work&match=all&billable=true&meta=k=v&since=2023-03-01&until=2023-03-02T00:00:00Z&limit=10", "", 200},
+		{"GET", "/api/v1/activities?title=work:%20c&before=4&after=3", "", 200},
+		{"GET", "/api/v1/activities?q=work", "", 200},
+		{"GET", "/api/v1/activities?match=x&tag=a", "", 400},
+		{"GET", "/api/v1/activities?before=100", "", 404},
+		{"GET", "/api/v1/activities/3", "", 200},
+		{"GET", "/api/v1/activities/x", "", 400},
+		{"GET", "/api/v1/activities/100", "", 404},
+		{"PUT", "/api/v1/activities/4", `{"Title": "work: e", "Start": "2023-03-02T09:00:00Z", "End": "2023-03-02T11:00:00Z", "Billable": false}`, 200},
+		{"PUT", "/api/v1/activities/4", `{"Title": "work: c", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T10:00:00Z"}`, 409},
+		{"PUT", "/api/v1/activities/4", `{"Title": "work: e"}`, 400},
+		{"PUT", "/api/v1/activities/100", `{"Title": "work: f", "Start": "2023-03-02T09:00:00Z"}`, 404},
+
+		{"GET", "/api/recent?rank=frecency&filter=w&limit=3", "", 200},
+		{"GET", "/api/recent?rank=x", "", 400},
+		{"GET", "/api/latest/work:%20c", "", 200},
+		{"GET", "/api/latest/none", "", 404},
+		{"GET", "/api/report/2023-03-01/2023-03-02?view_type=detail", "", 200},
+		{"GET", "/api/report/2023-03-01/x", "", 400},
+		{"GET", "/api/search?q=work&since=2023-03-01&until=2023-03-02&tag=work", "", 200},
+		{"GET", "/api/search?q=", "", 400},
+		{"GET", "/api/events", "", 200},
+		{"GET", "/api/v1/titles?rank=recent&filter=w&limit=2", "", 200},
+		{"GET", "/api/v1/titles?limit=-1", "", 400},
+		{"GET", "/api/v1/tags", "", 200},
+		{"GET", "/api/v1/reports?since=2023-03-01&until=2023-03-02&by=meta:k&tag=work", "", 200},
+		{"GET", "/api/v1/reports?since=2023-03-01", "", 400},
+		{"GET", "/api/v1/activities?before=2023-03-02T00:00:00Z&limit=1", "", 200},
+		{"GET", "/api/v1/search?q=work&since=2023-03-01&until=2023-03-02&tag=work&limit=5", "", 200},
+		{"GET", "/api/v1/search?q=", "", 400},
+
+		{"PUT", "/api/v1/billable", `{"Tag": "work", "Billable": true}`, 204},
+		{"PUT", "/api/v1/billable", `{"Tag": ""}`, 400},
+		{"GET", "/api/v1/billable", "", 200},
+		{"PUT", "/api/v1/goals", `{"Tag": "work", "Period": "week", "Target": 36000}`, 204},
+		{"PUT", "/api/v1/goals", `{"Tag": "work", "Period": "month", "Target": 36000}`, 400},
+		{"GET", "/api/v1/goals", "", 200},
+		{"DELETE", "/api/v1/goals?tag=work&period=week", "", 204},
+		{"DELETE", "/api/v1/goals?tag=work&period=week", "", 404},
+		{"DELETE", "/api/v1/goals?tag=work", "", 400},
+		{"PUT", "/api/v1/budgets", `{"Name": "work", "IsTag": true, "Limit": 36000, "From": "2023-03-01T00:00:00Z", "To": null}`, 204},
+		{"PUT", "/api/v1/budgets", `{"Name": "work", "Limit": 0}`, 400},
+		{"GET", "/api/v1/budgets", "", 200},
+		{"DELETE", "/api/v1/budgets?name=work&tag=true", "", 204},
+		{"DELETE", "/api/v1/budgets?name=work", "", 404},
+		{"DELETE", "/api/v1/budgets?name=work&tag=x", "", 400},
+		{"PUT", "/api/v1/rates", `{"Name": "work", "IsTag": true, "Amount": 12050, "Currency": "USD", "Since": "2023-03-01T00:00:00Z"}`, 204},
+		{"PUT", "/api/v1/rates", `{"Name": "work", "Amount": 100}`, 400},
+		{"GET", "/api/v1/rates", "", 200},
+		{"DELETE", "/api/v1/rates?name=work&tag=true&since=2023-03-01T00:00:00Z", "", 204},
+		{"DELETE", "/api/v1/rates?name=work&tag=true", "", 404},
+		{"DELETE", "/api/v1/rates?name=work&since=x", "", 400},
+
+		{"DELETE", "/api/v1/activities/4", "", 204},
+		{"DELETE", "/api/v1/activities/4", "", 404},
+		{"DELETE", "/api/v1/activities/x", "", 400},
+
+		{"POST", "/api/v1/tokens", `{"Name": "ci", "Scope": "read"}`, 201},
+		{"GET", "/api/v1/tokens", "", 200},
+		{"DELETE", "/api/v1/tokens/ci", "", 204},
+		{"GET", "/api/v1/me", "", 404},
+		{"GET", "/api/v1/team/report?since=2023-03-01&until=2023-03-02", "", 404},
+		{"GET", "/login", "", 404},
+		{"GET", "/metrics", "", 200},
+		{"GET", "/healthz", "", 200},
+		{"GET", "/readyz", "", 200},
+	})
+
+	env, handler = newMultiUserEnv(t)
+	admin := addUser(t, env, "alice", true)
+	s.checkRequests(t, handler, "Bearer "+addToken(t, env.forUser(admin.Id).env, "admin", store.ScopeWrite), covered, []specRequest{
+		{"GET", "/login", "", 200},
+		{"POST", "/login", "name=alice&password=wrong", 303},
+		{"POST", "/login", "name=alice&password=alice", 303},
+		{"POST", "/logout", "", 303},
+		{"GET", "/api/v1/me", "", 200},
+		{"POST", "/api/v1/activities", `{"Title": "work: a", "Start": "2023-03-01T09:00:00Z", "End": "2023-03-01T10:00:00Z", "Billable": true}`, 201},
+		{"GET", "/api/v1/team/report?since=2023-03-01&until=2023-03-02", "", 200},
+		{"GET", "/api/v1/team/report?since=2023-03-01", "", 400},
+		{"POST", "/api/v1/tokens", `{"Name": "ci", "Scope": "read"}`, 201},
+		{"POST", "/api/v1/tokens", `{"Name": "ci", "Scope": "read"}`, 409},
+		{"POST", "/api/v1/tokens", `{"Name": "ci", "Scope": "all"}`, 400},
+		{"GET", "/api/v1/tokens", "", 200},
+		{"DELETE", "/api/v1/tokens/ci", "", 204},
+		{"DELETE", "/api/v1/tokens/ci", "", 404},
+		{"GET", "/metrics", "", 200},
+		{"GET", "/healthz", "", 200},
+		{"GET", "/readyz", "", 200},
+	})
+
+	missing := make([]string, 0)
+	for path, ops := range s.Paths {
+		for method := range ops {
+			if key := strings.ToUpper(method) + " " + path; !covered[key] {
+				missing = append(missing, key)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Fatalf("Operations not tested: %v", missing)
+	}
+}
+
+// specRequest is a request expecting a status.
+type specRequest struct {
+	method, target, body string
+	status               int
+}
+
+// checkRequests makes requests in order with the Authorization header auth if not empty, checks
+// their status and validates them against the spec, then marks their operations covered.
+func (s *spec) checkRequests(t *testing.T, handler http.Handler, auth string, covered map[string]bool, requests []specRequest) {
+	t.Helper()
+
+	for _, c := range requests {
+		path, op := s.find(c.method, strings.SplitN(c.target, "?", 2)[0])
+		if op == nil {
+			t.Fatalf("%s %s is not in the spec", c.method, c.target)
+		}
+
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.body != "" && op.RequestBody != nil {
+			for ct := range op.RequestBody.Content {
+				req.Header.Set(contentTypeHeader, ct)
+			}
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		if c.target == "/api/events" {
+			// the stream ends when the client is gone
+			ctx, cancel := context.WithCancel(req.Context())
+			cancel()
+			req = req.WithContext(ctx)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Fatalf("%s %s: expects status %d, got %d: %s", c.method, c.target, c.status, w.Code, w.Body.String())
+		}
+		if err := s.check(op, c.target, c.body, w); err != nil {
+			t.Fatalf("%s %s: %v", c.method, c.target, err)
+		}
+		covered[c.method+" "+path] = true
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	s := spec{}
+	s.Components.Schemas = map[string]*schema{
+		"A": {Type: "object", Required: []string{"Id"}, Properties: map[string]*schema{
+			"Id":   {Type: "integer"},
+			"Tags": {Type: "array", Items: &schema{Type: "string"}},
+			"End":  {Type: "string", Format: "date-time", Nullable: true},
+		}},
+	}
+	ref := &schema{Ref: "#/components/schemas/A"}
+
+	for body, valid := range map[string]bool{
+		`{"Id": 1, "Tags": ["a"], "End": null}`:    true,
+		`{"Id": 1, "End": "2023-03-01T09:00:00Z"}`: true,
+		`{"Tags": []}`:                  false,
+		`{"Id": 1.5}`:                   false,
+		`{"Id": 1, "Tags": [1]}`:        false,
+		`{"Id": 1, "End": "yesterday"}`: false,
+		`{"Id": 1, "Other": true}`:      false,
+		`null`:                          false,
+	} {
+		var v any
+		if err := json.Unmarshal([]byte(body), &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.validate(ref, v, "body"); (err == nil) != valid {
+			t.Errorf("%s: expects valid %v, got %v", body, valid, err)
+		}
+	}
+}
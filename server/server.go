@@ -3,16 +3,21 @@ package server
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"github.com/cranej/ticktock/store"
 	"github.com/cranej/ticktock/version"
 	"github.com/cranej/ticktock/view"
 	"github.com/julienschmidt/httprouter"
 	"html/template"
 	"io"
-	"log"
+	"io/fs"
 	"net/http"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +40,37 @@ const (
 
 type Env struct {
 	Store store.Store
+	// Webhooks are notified of activities started, closed or added through the server.
+	Webhooks []Webhook
+	// Auth requires requests to the API to be authenticated by tokens of the Store, see TokenScope.
+	Auth bool
+	// BasicAuth is 'user:password' required by the web interface if not empty, which also grants
+	// read-write access to the API, and enables authentication of the API as Auth.
+	BasicAuth string
+	// MultiUser serves users of the Store, each with their own activities, see Store.ForUser. Users
+	// sign in to the web interface with passwords, and the API is authenticated by their tokens as
+	// Auth. It can't be used with BasicAuth.
+	MultiUser bool
+	// TLSCert and TLSKey are files of the certificate and its key to serve HTTPS, if set.
+	TLSCert, TLSKey string
+	// SocketMode is the permissions of the unix socket if listening on one, DefaultSocketMode if zero.
+	SocketMode fs.FileMode
+	// LogLevel is the least level of messages logged, requests are logged at LogInfo.
+	LogLevel LogLevel
+	// LogOutput is where messages are logged as JSON lines, os.Stderr if nil.
+	LogOutput io.Writer
+
+	log      *logger
+	webhooks *webhooks
+	events   *events
+	sessions *sessions
+	metrics  *metrics
+	// shuttingDown makes /readyz fail while the server shuts down.
+	shuttingDown atomic.Bool
+
+	mu           sync.Mutex
+	users        map[int64]*userEnv
+	eventsClosed bool
 }
 
 func index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -48,6 +84,17 @@ func index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Write(data)
 }
 
+func openapi(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := asset.ReadFile(assetPath("openapi.json"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentJson)
+	w.Write(data)
+}
+
 func anyFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	file := p.ByName("filepath")
 	data, err := asset.ReadFile(assetPath(file))
@@ -72,8 +119,30 @@ func anyFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 }
 
 func (env *Env) apiRecent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	titles, err := env.Store.RecentTitles(5)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rank := store.RankRecent
+	if r.Form.Get("rank") != "" {
+		rank = store.TitleRank(r.Form.Get("rank"))
+	}
+	if rank != store.RankRecent && rank != store.RankFrecency {
+		http.Error(w, "Invalid rank", http.StatusBadRequest)
+		return
+	}
+
+	limit := 5
+	if r.Form.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(r.Form.Get("limit")); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
 
+	titles, err := env.Store.Titles(rank, r.Form.Get("filter"), time.Now(), limit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -97,6 +166,7 @@ func (env *Env) apiLastActivity(w http.ResponseWriter, r *http.Request, ps httpr
 
 	t, err := template.New("activity").Parse(`<h2>{{.Title}}</h2>
 	<h3>{{.Start.Local.Format "2006-01-02 15:04:05"}} ~ {{.End.Local.Format "2006-01-02 15:04:05"}}</h3>
+	{{with .MetaString}}<p>{{.}}</p>{{end}}
 	<pre>{{.Notes}}</pre>`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -108,9 +178,15 @@ func (env *Env) apiLastActivity(w http.ResponseWriter, r *http.Request, ps httpr
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(contentTypeHeader, contentHtml)
 	io.WriteString(w, out.String())
 }
 
+// budgetWarnings is the response of api which changes activities.
+type budgetWarnings struct {
+	Warnings []string
+}
+
 func (env *Env) apiOngoing(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	activity, err := env.Store.Ongoing()
 	if err != nil {
@@ -118,18 +194,41 @@ func (env *Env) apiOngoing(w http.ResponseWriter, r *http.Request, _ httprouter.
 		return
 	}
 
-	writeJson(w, activity)
+	if activity == nil {
+		writeJson(w, activity)
+		return
+	}
+
+	warnings, err := store.BudgetWarnings(env.Store, &store.ClosedActivity{OpenActivity: activity}, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Tags in responses include the one derived from Title, as of Activity
+	activity.Tags = activity.AllTags()
+	writeJson(w, struct {
+		*store.OpenActivity
+		budgetWarnings
+	}{activity, budgetWarnings{warnings}})
 }
 
 func (env *Env) apiStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	title := ps.ByName("title")
 
 	if err := env.Store.StartTitle(title, ""); err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+
+	activity := store.OpenActivity{Title: title, Start: time.Now()}
+	warnings, err := store.BudgetWarnings(env.Store, &store.ClosedActivity{OpenActivity: &activity}, time.Now())
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJson(w, budgetWarnings{warnings})
 }
 
 func (env *Env) apiCloseActivity(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -139,14 +238,31 @@ func (env *Env) apiCloseActivity(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
-	_, err = env.Store.CloseActivity(string(notes))
+	title, err := env.Store.CloseActivity(string(notes))
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	warnings := []string{}
+	if title != "" {
+		activity, err := env.Store.LastClosed(title)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if activity != nil {
+			warnings, err = store.BudgetWarnings(env.Store, activity, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	writeJson(w, budgetWarnings{warnings})
 }
 
 func (env *Env) apiReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -187,22 +303,164 @@ func (env *Env) apiReport(w http.ResponseWriter, r *http.Request, ps httprouter.
 	io.WriteString(w, view)
 }
 
-func (env *Env) Run(addr string) error {
+// searchLimit is the max number of activities returned by /api/search.
+const searchLimit = 50
+
+func (env *Env) apiSearch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var since, until time.Time
+	if s := r.Form.Get("since"); s != "" {
+		t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid format of since", http.StatusBadRequest)
+			return
+		}
+		since = setTimeAndUTC(t, 0, 0, 0)
+	}
+	if s := r.Form.Get("until"); s != "" {
+		t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid format of until", http.StatusBadRequest)
+			return
+		}
+		until = setTimeAndUTC(t, 23, 59, 59)
+	}
+
+	var filter *store.QueryArg
+	if tags := r.Form["tag"]; len(tags) > 0 {
+		filter = store.NewTagArg(tags)
+	}
+
+	results, err := env.Store.Search(r.Form.Get("q"), since, until, filter, searchLimit)
+	if errors.Is(err, store.ErrEmptyQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range results {
+		results[i].Tags = results[i].AllTags()
+	}
+	writeJson(w, results)
+}
+
+// route is a route of the server, all routes are described by asset/openapi.json.
+type route struct {
+	method string
+	path   string
+	// scope required to access the route if authentication is enabled
+	scope  store.TokenScope
+	handle httprouter.Handle
+}
+
+func (env *Env) routes() []route {
+	return []route{
+		{http.MethodGet, "/", scopeWeb, index},
+		{http.MethodGet, "/static/*filepath", scopeWeb, anyFile},
+		{http.MethodGet, "/version", scopePublic, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			io.WriteString(w, version.Version)
+		}},
+		{http.MethodGet, "/api/openapi.json", scopePublic, openapi},
+		{http.MethodGet, "/healthz", scopePublic, healthz},
+		{http.MethodGet, "/readyz", scopePublic, env.readyz},
+		{http.MethodGet, "/metrics", scopeAdmin, env.apiMetrics},
+		{http.MethodGet, "/login", scopePublic, env.multiUserOnly(login)},
+		{http.MethodPost, "/login", scopePublic, env.multiUserOnly(env.apiLogin)},
+		{http.MethodPost, "/logout", scopePublic, env.multiUserOnly(env.apiLogout)},
+		{http.MethodGet, "/api/recent", store.ScopeRead, env.apiRecent},
+		{http.MethodGet, "/api/latest/:title", store.ScopeRead, env.apiLastActivity},
+		{http.MethodGet, "/api/ongoing", store.ScopeRead, env.apiOngoing},
+		{http.MethodPost, "/api/start/:title", store.ScopeWrite, env.apiStart},
+		{http.MethodPost, "/api/finish", store.ScopeWrite, env.apiCloseActivity},
+		{http.MethodGet, "/api/report/:start/:end", store.ScopeRead, env.apiReport},
+		{http.MethodGet, "/api/search", store.ScopeRead, env.apiSearch},
+		{http.MethodGet, "/api/events", store.ScopeRead, env.apiEvents},
+
+		{http.MethodGet, "/api/v1/activities", store.ScopeRead, env.apiV1Activities},
+		{http.MethodPost, "/api/v1/activities", store.ScopeWrite, env.apiV1CreateActivity},
+		{http.MethodGet, "/api/v1/activities/:id", store.ScopeRead, env.apiV1Activity},
+		{http.MethodPut, "/api/v1/activities/:id", store.ScopeWrite, env.apiV1UpdateActivity},
+		{http.MethodDelete, "/api/v1/activities/:id", store.ScopeWrite, env.apiV1DeleteActivity},
+		{http.MethodGet, "/api/v1/ongoing", store.ScopeRead, env.apiV1Ongoing},
+		{http.MethodPost, "/api/v1/ongoing", store.ScopeWrite, env.apiV1Start},
+		{http.MethodPost, "/api/v1/ongoing/close", store.ScopeWrite, env.apiV1Close},
+		{http.MethodGet, "/api/v1/titles", store.ScopeRead, env.apiV1Titles},
+		{http.MethodGet, "/api/v1/tags", store.ScopeRead, env.apiV1Tags},
+		{http.MethodGet, "/api/v1/reports", store.ScopeRead, env.apiV1Report},
+		{http.MethodGet, "/api/v1/search", store.ScopeRead, env.apiV1Search},
+		{http.MethodGet, "/api/v1/billable", store.ScopeRead, env.apiV1BillableDefaults},
+		{http.MethodPut, "/api/v1/billable", scopeShared, env.apiV1SetBillableDefault},
+		{http.MethodGet, "/api/v1/goals", store.ScopeRead, env.apiV1Goals},
+		{http.MethodPut, "/api/v1/goals", scopeShared, env.apiV1SetGoal},
+		{http.MethodDelete, "/api/v1/goals", scopeShared, env.apiV1RemoveGoal},
+		{http.MethodGet, "/api/v1/budgets", store.ScopeRead, env.apiV1Budgets},
+		{http.MethodPut, "/api/v1/budgets", scopeShared, env.apiV1SetBudget},
+		{http.MethodDelete, "/api/v1/budgets", scopeShared, env.apiV1RemoveBudget},
+		{http.MethodGet, "/api/v1/rates", store.ScopeRead, env.apiV1Rates},
+		{http.MethodPut, "/api/v1/rates", scopeShared, env.apiV1SetRate},
+		{http.MethodDelete, "/api/v1/rates", scopeShared, env.apiV1RemoveRate},
+		{http.MethodGet, "/api/v1/me", store.ScopeRead, env.multiUserOnly(env.apiV1Me)},
+		{http.MethodGet, "/api/v1/tokens", store.ScopeWrite, env.apiV1Tokens},
+		{http.MethodPost, "/api/v1/tokens", store.ScopeWrite, env.apiV1CreateToken},
+		{http.MethodDelete, "/api/v1/tokens/:name", store.ScopeWrite, env.apiV1RevokeToken},
+		{http.MethodGet, "/api/v1/team/report", scopeAdmin, env.multiUserOnly(env.apiV1TeamReport)},
+	}
+}
+
+// eventError logs errors of reading the activity of an event after a change, which is made anyway.
+func (env *Env) eventError(err error) {
+	env.log.error("failed to read the changed activity", "error", err)
+}
+
+// Handler returns the handler of all routes, which logs requests, it starts delivering webhooks in
+// background if any. In multi-user mode, requests of users are served by their own Env, see forUser.
+func (env *Env) Handler() http.Handler {
+	if env.log == nil {
+		out := env.LogOutput
+		if out == nil {
+			out = os.Stderr
+		}
+		env.log = newLogger(out, env.LogLevel)
+	}
+	if env.metrics == nil {
+		env.metrics = newMetrics()
+		env.Store = store.WithTiming(env.Store, env.metrics.observeStore)
+	}
+	if len(env.Webhooks) > 0 && env.webhooks == nil {
+		env.webhooks = newWebhooks(env.Webhooks, env.log)
+		env.Store = store.WithEvents(env.Store, env.eventError, env.webhooks.handle)
+		if env.MultiUser {
+			env.webhooks.users = env.Store
+		}
+	}
+	if env.MultiUser && env.users == nil {
+		env.users = make(map[int64]*userEnv)
+		env.sessions = newSessions()
+	} else if !env.MultiUser && env.events == nil {
+		env.events = newEvents(env.Store, env.log)
+		env.Store = store.WithEvents(env.Store, env.eventError, env.events.handle)
+	}
+
 	router := httprouter.New()
-	router.GET("/", index)
-	router.GET("/static/*filepath", anyFile)
-	router.GET("/api/recent", env.apiRecent)
-	router.GET("/api/latest/:title", env.apiLastActivity)
-	router.GET("/api/ongoing", env.apiOngoing)
-	router.POST("/api/start/:title", env.apiStart)
-	router.POST("/api/finish", env.apiCloseActivity)
-	router.GET("/api/report/:start/:end", env.apiReport)
-	router.GET("/version", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		io.WriteString(w, version.Version)
-	})
-
-	log.Println("Serve at:", addr)
-	return http.ListenAndServe(addr, router)
+	for i, r := range env.routes() {
+		handle := r.handle
+		if env.MultiUser && r.scope != scopePublic {
+			i := i
+			handle = func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				env.forUser(userOf(r).Id).handles[i](w, r, ps)
+			}
+		}
+		router.Handle(r.method, r.path, env.metrics.instrument(r.method, r.path, env.authorize(r.scope, handle)))
+	}
+
+	return env.logRequests(router)
 }
 
 func writeJson(w http.ResponseWriter, v any) {
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BillableDefault is whether activities of Tag are billable by default.
+type BillableDefault struct {
+	Tag      string
+	Billable bool
+}
+
+// Goal is a goal of a tag, Target is in seconds.
+type Goal struct {
+	Tag    string
+	Period store.GoalPeriod
+	Target int64
+}
+
+// Budget is a budget of a title, or of a tag if IsTag. From and To are null if unbounded. Limit and
+// Used are in seconds, Used is ignored when setting budgets.
+type Budget struct {
+	Name  string
+	IsTag bool
+	Limit int64
+	From  *time.Time
+	To    *time.Time
+	Used  int64
+}
+
+// Rate is an hourly rate of a title, or of a tag if IsTag. Amount is in cents, and Since is null if
+// the rate is effective since ever.
+type Rate struct {
+	Name     string
+	IsTag    bool
+	Amount   int64
+	Currency string
+	Since    *time.Time
+}
+
+// SearchActivity is an activity matched by /api/v1/search, Snippet is a fragment of its notes (or
+// title) around matched words, which are enclosed by \u0002 and \u0003.
+type SearchActivity struct {
+	*Activity
+	Snippet string
+}
+
+// timeOrNil returns nil if t is zero.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// timeOrZero returns zero time if t is nil.
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// parseBool parses the boolean parameter 'name', false if not given.
+func parseBool(form url.Values, name string) (bool, error) {
+	s := form.Get(name)
+	if s == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, badRequest("invalid %s %s", name, s)
+	}
+	return b, nil
+}
+
+func (env *Env) apiV1BillableDefaults(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defaults, err := env.Store.BillableDefaults()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]BillableDefault, 0, len(defaults))
+	for tag, billable := range defaults {
+		response = append(response, BillableDefault{tag, billable})
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Tag < response[j].Tag })
+	writeJsonStatus(w, http.StatusOK, response)
+}
+
+func (env *Env) apiV1SetBillableDefault(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input BillableDefault
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(input.Tag) == "" {
+		writeError(w, badRequest("Tag is required"))
+		return
+	}
+
+	if err := env.Store.SetBillableDefault(input.Tag, input.Billable); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (env *Env) apiV1Goals(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	goals, err := env.Store.Goals()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]Goal, 0, len(goals))
+	for _, goal := range goals {
+		response = append(response, Goal{goal.Tag, goal.Period, int64(goal.Target.Seconds())})
+	}
+	writeJsonStatus(w, http.StatusOK, response)
+}
+
+func parsePeriod(s string) (store.GoalPeriod, error) {
+	period := store.GoalPeriod(s)
+	if period != store.PerDay && period != store.PerWeek {
+		return "", badRequest("invalid period %s, expects day or week", s)
+	}
+	return period, nil
+}
+
+func (env *Env) apiV1SetGoal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input Goal
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	period, err := parsePeriod(string(input.Period))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(input.Tag) == "" || input.Target <= 0 {
+		writeError(w, badRequest("Tag and positive Target are required"))
+		return
+	}
+
+	goal := store.Goal{Tag: input.Tag, Period: period, Target: time.Duration(input.Target) * time.Second}
+	if err := env.Store.SetGoal(&goal); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1RemoveGoal removes the goal of 'tag' and 'period'.
+func (env *Env) apiV1RemoveGoal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+	period, err := parsePeriod(r.Form.Get("period"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := env.Store.RemoveGoal(r.Form.Get("tag"), period); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (env *Env) apiV1Budgets(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	budgets, err := env.Store.Budgets()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]Budget, 0, len(budgets))
+	for i := range budgets {
+		b := &budgets[i]
+		used, err := env.Store.BudgetUsage(b)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		response = append(response, Budget{
+			Name:  b.Name,
+			IsTag: b.IsTag,
+			Limit: int64(b.Limit.Seconds()),
+			From:  timeOrNil(b.From),
+			To:    timeOrNil(b.To),
+			Used:  int64(used.Seconds()),
+		})
+	}
+	writeJsonStatus(w, http.StatusOK, response)
+}
+
+func (env *Env) apiV1SetBudget(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input Budget
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(input.Name) == "" || input.Limit <= 0 {
+		writeError(w, badRequest("Name and positive Limit are required"))
+		return
+	}
+
+	budget := store.Budget{
+		Name:  input.Name,
+		IsTag: input.IsTag,
+		Limit: time.Duration(input.Limit) * time.Second,
+		From:  timeOrZero(input.From),
+		To:    timeOrZero(input.To),
+	}
+	if err := env.Store.SetBudget(&budget); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1RemoveBudget removes the budget of 'name', which is a tag if 'tag' is true.
+func (env *Env) apiV1RemoveBudget(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+	isTag, err := parseBool(r.Form, "tag")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := env.Store.RemoveBudget(r.Form.Get("name"), isTag); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (env *Env) apiV1Rates(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rates, err := env.Store.Rates()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]Rate, 0, len(rates))
+	for _, rate := range rates {
+		response = append(response, Rate{rate.Name, rate.IsTag, rate.Amount, rate.Currency, timeOrNil(rate.Since)})
+	}
+	writeJsonStatus(w, http.StatusOK, response)
+}
+
+func (env *Env) apiV1SetRate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input Rate
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if strings.TrimSpace(input.Name) == "" || input.Currency == "" || input.Amount < 0 {
+		writeError(w, badRequest("Name, Currency and non-negative Amount are required"))
+		return
+	}
+
+	rate := store.Rate{
+		Name:     input.Name,
+		IsTag:    input.IsTag,
+		Amount:   input.Amount,
+		Currency: input.Currency,
+		Since:    timeOrZero(input.Since),
+	}
+	if err := env.Store.SetRate(&rate); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1RemoveRate removes the rate of 'name', which is a tag if 'tag' is true, effective since 'since'
+// (ever if not given).
+func (env *Env) apiV1RemoveRate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+	isTag, err := parseBool(r.Form, "tag")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var since time.Time
+	if s := r.Form.Get("since"); s != "" {
+		if since, err = parseTime(s, false); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if err := env.Store.RemoveRate(r.Form.Get("name"), isTag, since); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1Search returns closed activities, newest first, whose title or notes contain all words of 'q',
+// with snippets. Other parameters are the same as /api/v1/activities.
+func (env *Env) apiV1Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+	filter, err := parseFilter(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit, err := parseInt(r.Form, "limit", activitiesLimit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	since, until, err := parseRange(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	results, err := env.Store.Search(r.Form.Get("q"), since, until, filter, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := make([]SearchActivity, 0, len(results))
+	for i := range results {
+		response = append(response, SearchActivity{newActivity(&results[i].ClosedActivity), results[i].Snippet})
+	}
+	writeJsonStatus(w, http.StatusOK, response)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package server
+
+// withUmask calls f, as there is no umask on this platform.
+func withUmask(mask int, f func() error) error {
+	return f()
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package server
+
+import "syscall"
+
+// withUmask calls f with the umask of the process set to mask, so that files f creates are never
+// more permissive than it allows.
+func withUmask(mask int, f func() error) error {
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+	return f()
+}
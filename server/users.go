@@ -0,0 +1,363 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/cranej/ticktock/store"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookie is the cookie of sessions of the web interface in multi-user mode.
+	sessionCookie = "ticktock_session"
+	// sessionTTL is how long users stay signed in.
+	sessionTTL = 7 * 24 * time.Hour
+)
+
+// User is the signed in user, the response of /api/v1/me.
+type User struct {
+	Name  string
+	Admin bool
+}
+
+// Token is an API token of the user.
+type Token struct {
+	Name    string
+	Scope   store.TokenScope
+	Created time.Time
+}
+
+// NewToken is a created token, with the secret which is not shown again.
+type NewToken struct {
+	Token
+	Secret string
+}
+
+// TokenInput is the body to create a token.
+type TokenInput struct {
+	Name  string
+	Scope store.TokenScope
+}
+
+// TeamTag is the total time of activities of a tag, and of each user of them keyed by name.
+type TeamTag struct {
+	Tag      string
+	Total    int64
+	Billable int64
+	Users    []ReportItem
+}
+
+// TeamReport is the response of /api/v1/team/report, the total time of activities of each user,
+// and of each tag. Times are in seconds.
+type TeamReport struct {
+	Since    string
+	Until    string
+	Total    int64
+	Billable int64
+	Users    []ReportItem
+	Tags     []TeamTag
+}
+
+type session struct {
+	user    int64
+	expires time.Time
+}
+
+// sessions are sessions of the web interface by hashes of their secrets. They are kept in memory,
+// users sign in again after the server restarts.
+type sessions struct {
+	mu     sync.Mutex
+	byHash map[string]session
+}
+
+func newSessions() *sessions {
+	return &sessions{byHash: make(map[string]session)}
+}
+
+// create returns the secret of a new session of the user, expired sessions are removed.
+func (s *sessions) create(user int64) (string, time.Time, error) {
+	secret, err := store.NewTokenSecret()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, session := range s.byHash {
+		if now.After(session.expires) {
+			delete(s.byHash, hash)
+		}
+	}
+	expires := now.Add(sessionTTL)
+	s.byHash[store.HashToken(secret)] = session{user, expires}
+	return secret, expires, nil
+}
+
+// find returns the user of the session of the secret, false if there is no such session or it expired.
+func (s *sessions) find(secret string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.byHash[store.HashToken(secret)]
+	if !ok || time.Now().After(session.expires) {
+		return 0, false
+	}
+	return session.user, true
+}
+
+func (s *sessions) remove(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.byHash, store.HashToken(secret))
+}
+
+type userKey struct{}
+
+// userOf returns the user of the request in multi-user mode, nil otherwise.
+func userOf(r *http.Request) *store.User {
+	user, _ := r.Context().Value(userKey{}).(*store.User)
+	return user
+}
+
+// withUser returns the request with the user in its context.
+func withUser(r *http.Request, user *store.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+}
+
+// userEnv is the Env of a user in multi-user mode, handles are of its routes, in the order of routes.
+type userEnv struct {
+	env     *Env
+	handles []httprouter.Handle
+}
+
+// forUser returns the Env of the user, which is created on the first request of the user. Its Store
+// has activities and tokens of the user, and its events are of the user only.
+func (env *Env) forUser(id int64) *userEnv {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if ue, ok := env.users[id]; ok {
+		return ue
+	}
+
+	ss := env.Store.ForUser(id)
+	ue := &userEnv{env: &Env{MultiUser: true, log: env.log, events: newEvents(ss, env.log), metrics: env.metrics}}
+	ue.env.Store = store.WithEvents(ss, env.eventError, ue.env.events.handle)
+	if env.eventsClosed {
+		ue.env.events.close()
+	}
+	for _, r := range ue.env.routes() {
+		ue.handles = append(ue.handles, r.handle)
+	}
+	env.users[id] = ue
+	return ue
+}
+
+// closeEvents disconnects clients of events, of all users in multi-user mode.
+func (env *Env) closeEvents() {
+	if env.events != nil {
+		env.events.close()
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	env.eventsClosed = true
+	for _, ue := range env.users {
+		ue.env.events.close()
+	}
+}
+
+func login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	data, err := asset.ReadFile(assetPath("login.html"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentHtml)
+	w.Write(data)
+}
+
+// apiLogin signs in the user of form fields 'name' and 'password', then redirects to the web
+// interface. It redirects back to /login?failed=1 if they are wrong.
+func (env *Env) apiLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := env.Store.CheckPassword(r.PostForm.Get("name"), r.PostForm.Get("password"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Redirect(w, r, "/login?failed=1", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	secret, expires, err := env.sessions.create(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// apiLogout ends the session of the request if any, then redirects to /login.
+func (env *Env) apiLogout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		env.sessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// multiUserOnly wraps handle of a route which is not found if not in multi-user mode.
+func (env *Env) multiUserOnly(handle httprouter.Handle) httprouter.Handle {
+	if env.MultiUser {
+		return handle
+	}
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		writeJsonStatus(w, http.StatusNotFound, ErrorBody{Error: "multi-user mode is not enabled"})
+	}
+}
+
+func (env *Env) apiV1Me(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := userOf(r)
+	writeJsonStatus(w, http.StatusOK, User{user.Name, user.Admin})
+}
+
+func (env *Env) apiV1Tokens(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	tokens, err := env.Store.Tokens()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, Token{token.Name, token.Scope, token.Created})
+	}
+	writeJsonStatus(w, http.StatusOK, result)
+}
+
+func (env *Env) apiV1CreateToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var input TokenInput
+	if err := readJson(r, &input); err != nil {
+		writeError(w, err)
+		return
+	}
+	if input.Name == "" {
+		writeError(w, badRequest("Name is required"))
+		return
+	}
+	if input.Scope != store.ScopeRead && input.Scope != store.ScopeWrite {
+		writeError(w, badRequest("invalid Scope %s, expects read or write", input.Scope))
+		return
+	}
+
+	secret, err := store.NewTokenSecret()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	token := store.Token{Name: input.Name, Scope: input.Scope, Created: time.Now().UTC().Truncate(time.Second)}
+	if err := env.Store.AddToken(&token, store.HashToken(secret)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJsonStatus(w, http.StatusCreated, NewToken{Token{token.Name, token.Scope, token.Created}, secret})
+}
+
+func (env *Env) apiV1RevokeToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := env.Store.RevokeToken(ps.ByName("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiV1TeamReport reports total time of closed activities of all users between 'since' and 'until'
+// (both required), by user and by tag.
+func (env *Env) apiV1TeamReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, badRequest("%v", err))
+		return
+	}
+
+	since, until, err := parseRange(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if since.IsZero() || until.IsZero() {
+		writeError(w, badRequest("since and until are required"))
+		return
+	}
+
+	users, err := env.Store.Users()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	all := newReportTotals()
+	tags := make(map[string]*reportTotals)
+	for _, user := range users {
+		activities, err := env.Store.ForUser(user.Id).Closed(since, until, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		for i := range activities {
+			a := &activities[i]
+			all.add(a, []string{user.Name})
+			for _, tag := range a.AllTags() {
+				if _, ok := tags[tag]; !ok {
+					tags[tag] = newReportTotals()
+				}
+				tags[tag].add(a, []string{user.Name})
+			}
+		}
+	}
+
+	report := TeamReport{
+		Since:    since.Format(time.RFC3339),
+		Until:    until.Format(time.RFC3339),
+		Total:    int64(all.sum.Seconds()),
+		Billable: int64(all.billableSum.Seconds()),
+		Users:    all.items(),
+		Tags:     []TeamTag{},
+	}
+	for tag, totals := range tags {
+		report.Tags = append(report.Tags, TeamTag{tag, int64(totals.sum.Seconds()), int64(totals.billableSum.Seconds()), totals.items()})
+	}
+	sort.Slice(report.Tags, func(i, j int) bool {
+		if report.Tags[i].Total != report.Tags[j].Total {
+			return report.Tags[i].Total > report.Tags[j].Total
+		}
+		return report.Tags[i].Tag < report.Tags[j].Tag
+	})
+
+	writeJsonStatus(w, http.StatusOK, report)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader is the header of the HMAC-SHA256 signature of the body, as 'sha256=<hex>'.
+	SignatureHeader = "X-Ticktock-Signature"
+	// EventHeader is the header of the event type, as 'start'.
+	EventHeader = "X-Ticktock-Event"
+)
+
+const (
+	webhookQueueSize = 100
+	webhookAttempts  = 5
+	webhookBackoff   = time.Second
+	webhookTimeout   = 10 * time.Second
+)
+
+// Webhook is a URL to which events of activities started, closed or added are POSTed as JSON.
+type Webhook struct {
+	URL string
+	// Secret signs the body in header X-Ticktock-Signature if not empty.
+	Secret string
+}
+
+// WebhookEvent is the body POSTed to webhooks. User is the name of the user of the activity in
+// multi-user mode, empty otherwise.
+type WebhookEvent struct {
+	Event    store.EventType
+	Time     time.Time
+	Activity *store.ClosedActivity
+	User     string
+}
+
+// Sign returns the value of SignatureHeader of body signed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type delivery struct {
+	hook    Webhook
+	event   store.EventType
+	body    []byte
+	attempt int
+}
+
+// webhooks delivers events in background, failed deliveries are retried with exponential
+// backoff, without blocking other deliveries.
+type webhooks struct {
+	hooks  []Webhook
+	client *http.Client
+	// queues are deliveries of each of hooks, each delivered by its own goroutine, so that a slow
+	// or down webhook does not delay events of others.
+	queues []chan *delivery
+	// attempts is the max number of attempts of each delivery, backoff is the wait before
+	// the first retry, doubled for each next retry.
+	attempts int
+	backoff  time.Duration
+	// pending counts deliveries not done yet, including ones waiting for retry
+	pending sync.WaitGroup
+	// users looks up names of users of events in multi-user mode, nil otherwise.
+	users store.Store
+	log   *logger
+}
+
+func newWebhooks(hooks []Webhook, log *logger) *webhooks {
+	w := &webhooks{
+		hooks:    hooks,
+		log:      log,
+		client:   &http.Client{Timeout: webhookTimeout},
+		attempts: webhookAttempts,
+		backoff:  webhookBackoff,
+	}
+	for range hooks {
+		queue := make(chan *delivery, webhookQueueSize)
+		w.queues = append(w.queues, queue)
+		go w.run(queue)
+	}
+	return w
+}
+
+// handle queues deliveries of the event to all webhooks, it never blocks.
+func (w *webhooks) handle(e *store.Event) {
+	if e.Type != store.EventStart && e.Type != store.EventClose && e.Type != store.EventAdd {
+		return
+	}
+
+	event := WebhookEvent{Event: e.Type, Time: e.Time, Activity: e.Activity}
+	if w.users != nil && e.User != 0 {
+		user, err := w.users.GetUser(e.User)
+		if err != nil {
+			w.log.error("failed to find user of webhook event", "event", e.Type, "user", e.User, "error", err)
+			return
+		}
+		event.User = user.Name
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.log.error("failed to encode webhook event", "event", e.Type, "error", err)
+		return
+	}
+
+	for i, hook := range w.hooks {
+		w.pending.Add(1)
+		select {
+		case w.queues[i] <- &delivery{hook: hook, event: e.Type, body: body}:
+		default:
+			w.pending.Done()
+			w.log.warn("webhook queue is full, event dropped", "url", hook.URL, "event", e.Type)
+		}
+	}
+}
+
+// run delivers deliveries of the queue of a webhook one at a time.
+func (w *webhooks) run(queue chan *delivery) {
+	for d := range queue {
+		d.attempt++
+		err := w.deliver(d)
+		if err == nil {
+			w.log.debug("webhook delivered", "url", d.hook.URL, "event", d.event, "attempt", d.attempt)
+			w.pending.Done()
+			continue
+		}
+
+		if d.attempt >= w.attempts {
+			w.log.warn("webhook delivery given up", "url", d.hook.URL, "event", d.event, "attempts", d.attempt, "error", err)
+			w.pending.Done()
+			continue
+		}
+		w.log.debug("webhook delivery failed, retrying", "url", d.hook.URL, "event", d.event, "attempt", d.attempt, "error", err)
+
+		d := d
+		time.AfterFunc(w.backoff<<(d.attempt-1), func() { queue <- d })
+	}
+}
+
+func (w *webhooks) deliver(d *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.hook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(contentTypeHeader, contentJson)
+	req.Header.Set(EventHeader, string(d.event))
+	if d.hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.hook.Secret, d.body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// wait waits until all queued deliveries are done or given up.
+func (w *webhooks) wait() {
+	w.pending.Wait()
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/cranej/ticktock/store"
+	_ "github.com/mattn/go-sqlite3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type received struct {
+	event     string
+	signature string
+	body      WebhookEvent
+	raw       []byte
+}
+
+// receiver returns a server which records requests, and fails the first 'failures' of them.
+func receiver(t *testing.T, failures int) (*httptest.Server, func() []received) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requests := make([]received, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		req := received{event: r.Header.Get(EventHeader), signature: r.Header.Get(SignatureHeader), raw: raw}
+		if err := json.Unmarshal(raw, &req.body); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, req)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), requests...)
+	}
+}
+
+func newTestEnv(t *testing.T, hooks ...Webhook) (*Env, http.Handler) {
+	t.Helper()
+
+	ss, err := store.NewSqliteStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &Env{Store: ss, Webhooks: hooks, LogOutput: io.Discard}
+	handler := env.Handler()
+	if env.webhooks != nil {
+		env.webhooks.backoff = 10 * time.Millisecond
+	}
+	return env, handler
+}
+
+func post(t *testing.T, handler http.Handler, path, body string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST %s: %d %s", path, w.Code, w.Body.String())
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ts, requests := receiver(t, 0)
+	env, handler := newTestEnv(t, Webhook{URL: ts.URL, Secret: "s3cret"})
+
+	post(t, handler, "/api/start/work:%20a", "")
+	post(t, handler, "/api/finish", "done")
+	env.webhooks.wait()
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("Expects 2 requests, got: %v", got)
+	}
+	for i, want := range []store.EventType{store.EventStart, store.EventClose} {
+		r := got[i]
+		if r.event != string(want) || r.body.Event != want || r.body.Activity.Title != "work: a" {
+			t.Fatalf("Got %s event %+v, want %s of 'work: a'", r.event, r.body, want)
+		}
+		if r.signature != Sign("s3cret", r.raw) {
+			t.Fatalf("Got signature %s, want %s", r.signature, Sign("s3cret", r.raw))
+		}
+	}
+	if a := got[1].body.Activity; a.End.IsZero() || a.Notes != "done" {
+		t.Fatalf("Expects closed activity with notes, got: %+v", a)
+	}
+}
+
+func TestWebhooksSlow(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	ts, requests := receiver(t, 0)
+	env, handler := newTestEnv(t, Webhook{URL: slow.URL}, Webhook{URL: ts.URL})
+
+	post(t, handler, "/api/start/a", "")
+	post(t, handler, "/api/finish", "")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(requests()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	env.webhooks.wait()
+
+	if got := requests(); len(got) != 2 {
+		t.Fatalf("Expects events delivered while the other webhook is slow, got: %v", got)
+	}
+}
+
+func TestWebhooksRetry(t *testing.T) {
+	ts, requests := receiver(t, 2)
+	broken, _ := receiver(t, 100)
+	env, handler := newTestEnv(t, Webhook{URL: ts.URL}, Webhook{URL: broken.URL})
+
+	post(t, handler, "/api/start/a", "")
+	env.webhooks.wait()
+
+	got := requests()
+	if len(got) != 1 || got[0].event != string(store.EventStart) || got[0].signature != "" {
+		t.Fatalf("Expects one unsigned start event after retries, got: %v", got)
+	}
+}
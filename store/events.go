@@ -0,0 +1,171 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStart  EventType = "start"
+	EventClose  EventType = "close"
+	EventAdd    EventType = "add"
+	EventEdit   EventType = "edit"
+	EventDelete EventType = "delete"
+)
+
+// Event is a change of activities.
+type Event struct {
+	Type EventType
+	// Activity is the activity after the change, or before the change for EventDelete.
+	// End is zero if it is open.
+	Activity *ClosedActivity
+	Time     time.Time
+	// User is the id of the user of the Store the change is made through, see Store.ForUser.
+	User int64
+}
+
+// eventStore calls handlers with events of changes made through it.
+type eventStore struct {
+	Store
+	handlers []func(*Event)
+	onError  func(error)
+	user     int64
+}
+
+// WithEvents returns a Store which calls handlers, in order, after each change made through it.
+// The change is made even if the activity changed can't be read for its event, then the event is
+// skipped, and onError is called with the error if not nil.
+func WithEvents(ss Store, onError func(error), handlers ...func(*Event)) Store {
+	return &eventStore{Store: ss, handlers: handlers, onError: onError}
+}
+
+func (s *eventStore) emit(t EventType, activity *ClosedActivity) {
+	event := Event{Type: t, Activity: activity, Time: time.Now(), User: s.user}
+	for _, handle := range s.handlers {
+		handle(&event)
+	}
+}
+
+// failed reports the error of reading the activity of the event, which is skipped.
+func (s *eventStore) failed(t EventType, err error) {
+	if s.onError != nil {
+		s.onError(fmt.Errorf("skipped %s event: %w", t, err))
+	}
+}
+
+// emitOngoing emits the event of the ongoing activity.
+func (s *eventStore) emitOngoing(t EventType) {
+	activity, err := s.Store.Ongoing()
+	if err != nil {
+		s.failed(t, err)
+		return
+	}
+	if activity != nil {
+		s.emit(t, &ClosedActivity{OpenActivity: activity})
+	}
+}
+
+// emitGet emits the event of the activity of id.
+func (s *eventStore) emitGet(t EventType, id int64) {
+	activity, err := s.Store.Get(id)
+	if err != nil {
+		s.failed(t, err)
+		return
+	}
+	s.emit(t, activity)
+}
+
+func (s *eventStore) Start(activity *OpenActivity) error {
+	if err := s.Store.Start(activity); err != nil {
+		return err
+	}
+
+	s.emitOngoing(EventStart)
+	return nil
+}
+
+func (s *eventStore) StartTitle(title, notes string) error {
+	if err := s.Store.StartTitle(title, notes); err != nil {
+		return err
+	}
+
+	s.emitOngoing(EventStart)
+	return nil
+}
+
+func (s *eventStore) CloseActivity(notes string) (string, error) {
+	activity, err := s.Store.Ongoing()
+	if err != nil {
+		return "", err
+	}
+
+	title, err := s.Store.CloseActivity(notes)
+	if err != nil || activity == nil {
+		return title, err
+	}
+
+	s.emitGet(EventClose, activity.Id)
+	return title, nil
+}
+
+func (s *eventStore) Add(activity *ClosedActivity) error {
+	if err := s.Store.Add(activity); err != nil {
+		return err
+	}
+
+	s.emitGet(EventAdd, activity.Id)
+	return nil
+}
+
+// Update emits EventClose if the activity is closed by the update, e.g. a close replayed by a
+// remote store, otherwise EventEdit.
+func (s *eventStore) Update(activity *ClosedActivity) error {
+	// The activity before the update is only needed for the event, and the update is made anyway.
+	before, _ := s.Store.Get(activity.Id)
+	if err := s.Store.Update(activity); err != nil {
+		return err
+	}
+
+	t := EventEdit
+	if before != nil && before.End.IsZero() && !activity.End.IsZero() {
+		t = EventClose
+	}
+	s.emitGet(t, activity.Id)
+	return nil
+}
+
+func (s *eventStore) Delete(id int64) error {
+	activity, err := s.Store.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Store.Delete(id); err != nil {
+		return err
+	}
+
+	s.emit(EventDelete, activity)
+	return nil
+}
+
+func (s *eventStore) Merge(other string) (*MergeReport, error) {
+	report, err := s.Store.Merge(other)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range report.Added {
+		s.emit(EventAdd, &report.Added[i])
+	}
+	for i := range report.Updated {
+		s.emit(EventEdit, &report.Updated[i])
+	}
+	return report, nil
+}
+
+// ForUser returns the Store of the user which calls the same handlers.
+func (s *eventStore) ForUser(id int64) Store {
+	return &eventStore{Store: s.Store.ForUser(id), handlers: s.handlers, onError: s.onError, user: id}
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// MergeReport is the result of Store.Merge.
+type MergeReport struct {
+	// Device is the id of the device of the other database.
+	Device string
+	// LastSynced is when activities were last merged from the device, zero if never.
+	LastSynced time.Time
+	// Added and Updated are the activities changed here.
+	Added   []ClosedActivity
+	Updated []ClosedActivity
+	// Unchanged is the number of merged activities which are the same here already.
+	Unchanged int
+	// Conflicts are activities edited differently in both databases, which are kept as here.
+	Conflicts []MergeConflict
+	// Overlaps are added activities, or updated ones with time changed, overlapping in time with
+	// other activities here.
+	Overlaps []Overlap
+}
+
+// MergeConflict is an activity edited in both databases. Here is the one kept, Other is the one
+// in the other database, with Id there.
+type MergeConflict struct {
+	Here  ClosedActivity
+	Other ClosedActivity
+}
+
+// Overlap is a merged activity overlapping in time with an existing one.
+type Overlap struct {
+	Activity ClosedActivity
+	Existing ClosedActivity
+}
+
+// origin is the device where an activity was created, and its id there.
+type origin struct {
+	device string
+	id     int64
+}
+
+func (o origin) less(other origin) bool {
+	return o.device < other.device || o.device == other.device && o.id < other.id
+}
+
+// syncActivity is an activity with its origin, and when it was last changed in unix nanoseconds.
+type syncActivity struct {
+	ClosedActivity
+	origin  origin
+	updated int64
+}
+
+// syncColumns are columns scanned by querySyncActivities, '?' is the device id of the database.
+const syncColumns = activityColumns + `, IFNULL(origin, ?), IFNULL(origin_id, id), updated`
+
+// querySyncActivities returns activities of the query which selects syncColumns, with details loaded.
+func (s *sqlite) querySyncActivities(query string, params []any) ([]syncActivity, error) {
+	activities := make([]syncActivity, 0)
+	err := s.queryEach(query, params, func(rows *sql.Rows) error {
+		var a syncActivity
+		activity, err := scanActivity(scannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &a.origin.device, &a.origin.id, &a.updated)...)
+		}))
+		if err != nil {
+			return err
+		}
+
+		a.ClosedActivity = *activity
+		activities = append(activities, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]*OpenActivity, 0, len(activities))
+	for i := range activities {
+		details = append(details, activities[i].OpenActivity)
+	}
+	return activities, s.loadDetails(details)
+}
+
+// deviceId returns the id of the device of the database, which is random and assigned on creation.
+func (s *sqlite) deviceId() (string, error) {
+	var id string
+	err := s.conn().QueryRow(`SELECT id FROM device`).Scan(&id)
+	return id, err
+}
+
+func (s *sqlite) NewDeviceId() error {
+	return s.inTx(func(s *sqlite) error {
+		device, err := s.deviceId()
+		if err != nil {
+			return err
+		}
+		// activities created here are still of the old id, as known by databases merged with this one
+		if _, err := s.conn().Exec(`UPDATE clocking SET origin = ?, origin_id = id WHERE origin IS NULL`, device); err != nil {
+			return err
+		}
+		_, err = s.conn().Exec(`UPDATE device SET id = lower(hex(randomblob(16)))`)
+		return err
+	})
+}
+
+// openChecked opens the existing ticktock database file at path, read-only if readOnly. It is not
+// migrated, so it must be of the same version of the schema.
+func openChecked(path string, readOnly bool) (sqlite, error) {
+	mode := "rw"
+	if readOnly {
+		mode = "ro"
+	}
+	pool, err := sql.Open("sqlite3", (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=" + mode}).String())
+	if err != nil {
+		return sqlite{}, err
+	}
+
+	var version int
+	if err := pool.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		pool.Close()
+		return sqlite{}, err
+	}
+	if version != len(migrations) {
+		pool.Close()
+		return sqlite{}, fmt.Errorf("it is of schema version %d instead of %d, open it with this version of ticktock first", version, len(migrations))
+	}
+	return sqlite{db: pool}, nil
+}
+
+// changedSince returns closed activities changed after 'updated', in the order of changes.
+func (s *sqlite) changedSince(device string, updated int64) ([]syncActivity, error) {
+	return s.querySyncActivities(`SELECT `+syncColumns+`
+		FROM clocking
+		WHERE end IS NOT NULL AND updated > ? AND user_id = ?
+		ORDER BY updated`, []any{device, updated, s.user})
+}
+
+// findMerged returns the activity here which the one from the other database is, matched by its
+// origin, then by Title and Start. Returns nil if there is none.
+func (s *sqlite) findMerged(device string, other *syncActivity) (*syncActivity, error) {
+	var found []syncActivity
+	var err error
+	if other.origin.device == device {
+		found, err = s.querySyncActivities(`SELECT `+syncColumns+` FROM clocking WHERE id = ? AND user_id = ?`,
+			[]any{device, other.origin.id, s.user})
+	} else {
+		found, err = s.querySyncActivities(`SELECT `+syncColumns+` FROM clocking WHERE origin = ? AND origin_id = ? AND user_id = ?`,
+			[]any{device, other.origin.device, other.origin.id, s.user})
+		if err == nil && len(found) == 0 {
+			found, err = s.querySyncActivities(`SELECT `+syncColumns+` FROM clocking WHERE title = ? AND start = ? AND user_id = ?`,
+				[]any{device, other.Title, other.Start.UTC().Format(time.RFC3339), s.user})
+		}
+	}
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+
+	return &found[0], nil
+}
+
+// adoptOrigin sets the origin of the activity here to the one of the same activity from the other
+// database if it is less, so that both databases converge on the same origin of activities created
+// on both, which are matched by Title and Start.
+func (s *sqlite) adoptOrigin(device string, here, other *syncActivity) error {
+	if here.origin == other.origin || !other.origin.less(here.origin) {
+		return nil
+	}
+
+	var from, id any
+	if other.origin.device != device {
+		from, id = other.origin.device, other.origin.id
+	}
+	_, err := s.conn().Exec(`UPDATE clocking SET origin = ?, origin_id = ? WHERE id = ?`, from, id, here.Id)
+	return err
+}
+
+// overlapping returns closed activities other than the given one overlapping with it in time.
+func (s *sqlite) overlapping(activity *ClosedActivity) ([]ClosedActivity, error) {
+	return s.queryActivities(`SELECT `+activityColumns+`
+		FROM clocking
+		WHERE id != ? AND end IS NOT NULL AND start < ? AND end > ? AND user_id = ?
+		ORDER BY start`,
+		[]any{activity.Id, activity.End.UTC().Format(time.RFC3339), activity.Start.UTC().Format(time.RFC3339), s.user})
+}
+
+// sameActivity reports whether a and b have the same Title, Start, End, Notes, Billable, Meta and Tags.
+func sameActivity(a, b *ClosedActivity) bool {
+	return a.Title == b.Title &&
+		a.Start.Equal(b.Start) &&
+		a.End.Equal(b.End) &&
+		a.Notes == b.Notes &&
+		a.Billable == b.Billable &&
+		a.MetaString() == b.MetaString() &&
+		strings.Join(a.AllTags(), ",") == strings.Join(b.AllTags(), ",")
+}
+
+func (s *sqlite) Merge(other string) (*MergeReport, error) {
+	// opening a path which does not exist would create an empty database
+	if _, err := os.Stat(other); err != nil {
+		return nil, err
+	}
+	o, err := openChecked(other, true)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", other, err)
+	}
+	defer o.Close()
+
+	var report *MergeReport
+	err = s.inTx(func(s *sqlite) error {
+		report, err = s.merge(other, &o)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// merge merges activities of the other database o at path other.
+func (s *sqlite) merge(other string, o *sqlite) (*MergeReport, error) {
+	device, err := s.deviceId()
+	if err != nil {
+		return nil, err
+	}
+	otherDevice, err := o.deviceId()
+	if err != nil {
+		return nil, err
+	}
+	if device == otherDevice {
+		return nil, fmt.Errorf("%s is a copy of this database, or this database itself, give one of them a new device id to merge a copy", other)
+	}
+
+	var pulled, synced int64 = -1, 0
+	err = s.conn().QueryRow(`SELECT pulled, synced FROM sync_peers WHERE device = ?`, otherDevice).Scan(&pulled, &synced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	report := MergeReport{Device: otherDevice}
+	if synced > 0 {
+		report.LastSynced = time.Unix(0, synced)
+	}
+
+	changed, err := o.changedSince(otherDevice, pulled)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapped := make(map[[2]int64]bool)
+	for i := range changed {
+		incoming := &changed[i]
+		pulled = incoming.updated
+
+		here, err := s.findMerged(device, incoming)
+		if err != nil {
+			return nil, err
+		}
+		if here != nil {
+			if err := s.adoptOrigin(device, here, incoming); err != nil {
+				return nil, err
+			}
+		}
+
+		activity := ClosedActivity{OpenActivity: &OpenActivity{}, End: incoming.End}
+		*activity.OpenActivity = *incoming.OpenActivity
+		switch {
+		case here == nil && incoming.origin.device == device:
+			// deleted here
+			continue
+		case here == nil:
+			activity.Id = 0
+			if err := s.insert(activity.OpenActivity, activity.End, &incoming.origin); err != nil {
+				return nil, err
+			}
+			report.Added = append(report.Added, activity)
+		case sameActivity(&here.ClosedActivity, &incoming.ClosedActivity):
+			report.Unchanged++
+			continue
+		case here.updated > synced:
+			report.Conflicts = append(report.Conflicts, MergeConflict{here.ClosedActivity, incoming.ClosedActivity})
+			continue
+		default:
+			activity.Id = here.Id
+			err := s.Update(&activity)
+			if errors.Is(err, ErrDuplicateActivity) {
+				report.Conflicts = append(report.Conflicts, MergeConflict{here.ClosedActivity, incoming.ClosedActivity})
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			report.Updated = append(report.Updated, activity)
+			if activity.Start.Equal(here.Start) && activity.End.Equal(here.End) {
+				continue
+			}
+		}
+
+		existing, err := s.overlapping(&activity)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range existing {
+			pair := [2]int64{activity.Id, e.Id}
+			if pair[0] > pair[1] {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+			if !overlapped[pair] {
+				overlapped[pair] = true
+				report.Overlaps = append(report.Overlaps, Overlap{activity, e})
+			}
+		}
+	}
+
+	if _, err := s.conn().Exec(`INSERT INTO sync_peers (device, pulled, synced) VALUES (?, ?, ?)
+		ON CONFLICT (device) DO UPDATE SET pulled = excluded.pulled, synced = excluded.synced`,
+		otherDevice, pulled, time.Now().UnixNano()); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
@@ -4,33 +4,129 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 type sqlite struct {
 	db *sql.DB
+	// tx is the transaction which all queries of the store are in, see inTx.
+	tx *sql.Tx
+	// fts is whether the full-text index is available
+	fts bool
+	// user owns the activities and tokens of the store, see Store.ForUser.
+	user int64
 }
 
-func (s *sqlite) Start(activity *OpenActivity) error {
-	start := activity.Start.Format(time.RFC3339)
+// queryer is a *sql.DB or a *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
 
-	var count uint
-	row := s.db.QueryRow(`select count(1) from clocking
-		where end is null`,
-		activity.Title)
-	if err := row.Scan(&count); err != nil {
+// conn returns the transaction of the store if any, or its db.
+func (s *sqlite) conn() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// txn is a transaction begun by sqlite.begin. Committing or rolling back the transaction of the
+// store is left to inTx.
+type txn struct {
+	*sql.Tx
+	nested bool
+}
+
+func (t txn) Commit() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t txn) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// begin begins a transaction, which is the one of the store if any.
+func (s *sqlite) begin() (txn, error) {
+	if s.tx != nil {
+		return txn{s.tx, true}, nil
+	}
+	tx, err := s.db.Begin()
+	return txn{Tx: tx}, err
+}
+
+// inTx calls f with a copy of the store of which all queries are in a transaction, which is
+// committed if f succeeds, or rolled back.
+func (s *sqlite) inTx(f func(*sqlite) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
 		return err
 	}
-	if count > 0 {
-		return ErrOngoingExists
+	defer tx.Rollback()
+
+	if err := f(&sqlite{db: s.db, tx: tx, fts: s.fts, user: s.user}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// activityColumns are columns scanned by scanActivity
+const activityColumns = `id, title, start, end, notes, billable`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scannerFunc adapts a function to scanner.
+type scannerFunc func(dest ...any) error
+
+func (f scannerFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// scanActivity scans a row of activityColumns, End is zero if the activity is open.
+func scanActivity(row scanner) (*ClosedActivity, error) {
+	var activity = ClosedActivity{OpenActivity: &OpenActivity{}}
+	var start string
+	var end, notes sql.NullString
+	if err := row.Scan(&activity.Id, &activity.Title, &start, &end, &notes, &activity.Billable); err != nil {
+		return nil, err
+	}
+
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, err
+	}
+
+	endTime, err := parseNullTime(end)
+	if err != nil {
+		return nil, err
 	}
 
+	activity.Start, activity.End, activity.Notes = startTime, endTime, notes.String
+	return &activity, nil
+}
+
+// checkDuplicate returns ErrDuplicateActivity if there is an activity other than 'id'
+// with the same title and start.
+func (s *sqlite) checkDuplicate(id int64, title string, start time.Time) error {
 	var exists uint
-	row = s.db.QueryRow(`select count(1) from clocking
-		where title = ? and start = ?`,
-		activity.Title,
-		start)
+	row := s.conn().QueryRow(`select count(1) from clocking
+		where title = ? and start = ? and id != ? and user_id = ?`,
+		title,
+		start.Format(time.RFC3339),
+		id,
+		s.user)
 	if err := row.Scan(&exists); err != nil {
 		return err
 	}
@@ -38,32 +134,238 @@ func (s *sqlite) Start(activity *OpenActivity) error {
 		return ErrDuplicateActivity
 	}
 
-	_, err := s.db.Exec(`INSERT INTO clocking (title, start, notes)
-	VALUES(?,?,?)`,
+	return nil
+}
+
+// checkOngoing returns ErrOngoingExists if there is an open activity other than 'id'.
+func (s *sqlite) checkOngoing(id int64) error {
+	var count uint
+	row := s.conn().QueryRow(`select count(1) from clocking
+		where end is null and id != ? and user_id = ?`,
+		id,
+		s.user)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrOngoingExists
+	}
+
+	return nil
+}
+
+func (s *sqlite) Start(activity *OpenActivity) error {
+	if err := s.checkOngoing(0); err != nil {
+		return err
+	}
+
+	if err := s.checkDuplicate(0, activity.Title, activity.Start); err != nil {
+		return err
+	}
+
+	return s.insert(activity, time.Time{}, nil)
+}
+
+// insert inserts the activity and its metadata, sets Id of the activity. Zero end inserts an open activity.
+// from is where the activity was created, nil if on this device.
+func (s *sqlite) insert(activity *OpenActivity, end time.Time, from *origin) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var device sql.NullString
+	var originId sql.NullInt64
+	if from != nil {
+		device = sql.NullString{String: from.device, Valid: true}
+		originId = sql.NullInt64{Int64: from.id, Valid: true}
+	}
+	r, err := tx.Exec(`INSERT INTO clocking (title, start, end, notes, billable, origin, origin_id, updated, user_id)
+	VALUES(?,?,?,?,?,?,?,?,?)`,
 		activity.Title,
-		start,
-		activity.Notes)
+		activity.Start.Format(time.RFC3339),
+		nullTime(end),
+		activity.Notes,
+		activity.Billable,
+		device,
+		originId,
+		time.Now().UnixNano(),
+		s.user)
+	if err != nil {
+		return err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := insertMeta(tx, id, activity.Meta); err != nil {
+		return err
+	}
+	if err := insertTags(tx, id, activity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	activity.Id = id
+	return nil
+}
+
+func insertMeta(tx queryer, id int64, meta map[string]string) error {
+	for key, value := range meta {
+		if _, err := tx.Exec(`INSERT INTO activity_meta (activity_id, key, value)
+			VALUES(?,?,?)`,
+			id, key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertTags inserts Tags of the activity as explicit ones, and the tag derived from its title
+// unless it is one of them. Only explicit tags are loaded into Tags, so that the derived one is
+// replaced when the title changes.
+func insertTags(tx queryer, id int64, activity *OpenActivity) error {
+	for _, tag := range activity.Tags {
+		if tag == "" {
+			continue
+		}
+		if err := insertTag(tx, id, tag, true); err != nil {
+			return err
+		}
+	}
+
+	return insertTag(tx, id, activity.Tag(), false)
+}
+
+func insertTag(tx queryer, id int64, tag string, explicit bool) error {
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO tags (name) VALUES(?)`, tag); err != nil {
+		return err
+	}
 
+	_, err := tx.Exec(`INSERT OR IGNORE INTO activity_tags (activity_id, tag_id, explicit)
+		SELECT ?, id, ? FROM tags WHERE name = ?`,
+		id, explicit, tag)
 	return err
 }
 
+// maxParams is the max number of parameters in a query, lower than SQLITE_MAX_VARIABLE_NUMBER of old versions.
+const maxParams = 500
+
+// loadDetails queries and sets Meta and Tags of the activities.
+func (s *sqlite) loadDetails(activities []*OpenActivity) error {
+	byId := make(map[int64]*OpenActivity, len(activities))
+	for _, activity := range activities {
+		byId[activity.Id] = activity
+	}
+
+	for i := 0; i < len(activities); i += maxParams {
+		chunk := activities[i:]
+		if len(chunk) > maxParams {
+			chunk = chunk[:maxParams]
+		}
+
+		params := make([]any, 0, len(chunk))
+		for _, activity := range chunk {
+			params = append(params, activity.Id)
+		}
+		in := `(?` + strings.Repeat(",?", len(chunk)-1) + `)`
+
+		err := s.queryEach(`SELECT activity_id, key, value
+			FROM activity_meta
+			WHERE activity_id in `+in,
+			params,
+			func(rows *sql.Rows) error {
+				var id int64
+				var key, value string
+				if err := rows.Scan(&id, &key, &value); err != nil {
+					return err
+				}
+
+				activity := byId[id]
+				if activity.Meta == nil {
+					activity.Meta = make(map[string]string)
+				}
+				activity.Meta[key] = value
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		err = s.queryEach(`SELECT at.activity_id, t.name
+			FROM activity_tags at JOIN tags t ON t.id = at.tag_id
+			WHERE at.explicit = 1 AND at.activity_id in `+in+`
+			ORDER BY t.name`,
+			params,
+			func(rows *sql.Rows) error {
+				var id int64
+				var tag string
+				if err := rows.Scan(&id, &tag); err != nil {
+					return err
+				}
+
+				byId[id].Tags = append(byId[id].Tags, tag)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryEach calls f on each row of the query result.
+func (s *sqlite) queryEach(query string, params []any, f func(*sql.Rows) error) error {
+	rows, err := s.conn().Query(query, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := f(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func (s *sqlite) StartTitle(title, notes string) error {
-	return s.Start(&OpenActivity{
+	activity := OpenActivity{
 		Title: title,
 		Start: time.Now().UTC(),
 		Notes: notes,
-	})
+	}
+
+	billable, err := s.BillableDefaults()
+	if err != nil {
+		return err
+	}
+	activity.Billable = BillableDefault(billable, &activity)
+
+	return s.Start(&activity)
 }
 
 func (s *sqlite) CloseActivity(notes string) (string, error) {
-	row := s.db.QueryRow(`update clocking
-		set end = ?, notes = IFNULL(notes, '')||?
+	row := s.conn().QueryRow(`update clocking
+		set end = ?, notes = IFNULL(notes, '')||?, updated = ?
 		where id in (
 			select max(id) from clocking
-			where end is null
+			where end is null and user_id = ?
 		) returning title`,
 		time.Now().UTC().Format(time.RFC3339),
-		notes)
+		notes,
+		time.Now().UnixNano(),
+		s.user)
 
 	var title string
 	err := row.Scan(&title)
@@ -75,15 +377,17 @@ func (s *sqlite) CloseActivity(notes string) (string, error) {
 }
 
 func (s *sqlite) RecentTitles(limit uint8) ([]string, error) {
-	rows, err := s.db.Query(`SELECT title, max(start)
+	rows, err := s.conn().Query(`SELECT title, max(start)
 		FROM clocking
-		where end is not null
+		where end is not null and user_id = ?
 		group by title
 		order by max(start) desc limit ?`,
+		s.user,
 		limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	titles := make([]string, 0)
 	for rows.Next() {
@@ -95,16 +399,66 @@ func (s *sqlite) RecentTitles(limit uint8) ([]string, error) {
 		titles = append(titles, title)
 	}
 
-	return titles, nil
+	return titles, rows.Err()
+}
+
+func (s *sqlite) Titles(rank TitleRank, pattern string, at time.Time, limit int) ([]string, error) {
+	var query string
+	switch rank {
+	case RankRecent:
+		query = `select title, max(start) from clocking
+			where end is not null and user_id = ?
+			group by title
+			order by max(start) desc`
+	case RankFrecency:
+		// newest first, so that titles with the same score are ordered by recency
+		query = `select title, start from clocking
+			where end is not null and user_id = ?
+			order by start desc`
+	default:
+		return nil, fmt.Errorf("unknown rank of titles: %s", rank)
+	}
+
+	titles := make([]string, 0)
+	scores := make(map[string]float64)
+	err := s.queryEach(query, []any{s.user}, func(rows *sql.Rows) error {
+		var title, start string
+		if err := rows.Scan(&title, &start); err != nil {
+			return err
+		}
+
+		if rank == RankRecent {
+			titles = append(titles, title)
+			return nil
+		}
+
+		startTime, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return err
+		}
+		if _, ok := scores[title]; !ok {
+			titles = append(titles, title)
+		}
+		scores[title] += FrecencyScore(startTime, at)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rank == RankFrecency {
+		sort.SliceStable(titles, func(i, j int) bool { return scores[titles[i]] > scores[titles[j]] })
+	}
+	return FilterTitles(titles, pattern, limit), nil
 }
 
 func (s *sqlite) Ongoing() (*OpenActivity, error) {
-	row := s.db.QueryRow(`SELECT title, start, notes
+	row := s.conn().QueryRow(`SELECT `+activityColumns+`
 		from clocking
-		where end is null`)
+		where end is null and user_id = ?`, s.user)
 
-	var title, start, notes string
-	if err := row.Scan(&title, &start, &notes); err != nil {
+	activity, err := scanActivity(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		} else {
@@ -112,20 +466,19 @@ func (s *sqlite) Ongoing() (*OpenActivity, error) {
 		}
 	}
 
-	startTime, err := time.Parse(time.RFC3339, start)
-	if err != nil {
+	if err := s.loadDetails([]*OpenActivity{activity.OpenActivity}); err != nil {
 		return nil, err
 	}
 
-	return &OpenActivity{title, startTime, notes}, nil
+	return activity.OpenActivity, nil
 }
 
 func (s *sqlite) LastClosed(title string) (*ClosedActivity, error) {
-	query := `SELECT title, start, end, notes
+	query := `SELECT ` + activityColumns + `
 		FROM clocking
 		WHERE id in (
 			SELECT max(id) FROM clocking
-			WHERE %s end IS NOT NULL)`
+			WHERE %s end IS NOT NULL AND user_id = ?)`
 	params := []any{}
 	if title == "" {
 		query = fmt.Sprintf(query, "")
@@ -133,11 +486,10 @@ func (s *sqlite) LastClosed(title string) (*ClosedActivity, error) {
 		query = fmt.Sprintf(query, "title = ? and ")
 		params = append(params, title)
 	}
+	params = append(params, s.user)
 
-	row := s.db.QueryRow(query, params...)
-
-	var title_, start, end, notes string
-	if err := row.Scan(&title_, &start, &end, &notes); err != nil {
+	activity, err := scanActivity(s.conn().QueryRow(query, params...))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		} else {
@@ -145,17 +497,64 @@ func (s *sqlite) LastClosed(title string) (*ClosedActivity, error) {
 		}
 	}
 
-	startTime, err := time.Parse(time.RFC3339, start)
-	if err != nil {
+	if err := s.loadDetails([]*OpenActivity{activity.OpenActivity}); err != nil {
 		return nil, err
 	}
-	endTime, err := time.Parse(time.RFC3339, end)
-	if err != nil {
-		return nil, err
+
+	return activity, nil
+}
+
+// tagsCond returns condition matches activities by tags.
+func tagsCond(tags []string, match TagMatch) (string, []any) {
+	params := make([]any, 0, len(tags))
+	for _, tag := range tags {
+		params = append(params, tag)
+	}
+
+	sub := `(select count(distinct t.name)
+		from activity_tags at join tags t on t.id = at.tag_id
+		where at.activity_id = clocking.id and t.name in (?` + strings.Repeat(",?", len(tags)-1) + `))`
+	switch match {
+	case AllTags:
+		params = append(params, len(tags))
+		return sub + " = ?", params
+	case NoneTags:
+		return sub + " = 0", params
+	default:
+		return sub + " > 0", params
+	}
+}
+
+// filterCond returns conditions and parameters of the filter, in form of "and ...".
+func filterCond(filter *QueryArg) (string, []any) {
+	var cond strings.Builder
+	params := []any{}
+
+	if !filter.Empty() {
+		if filter.IsTag() {
+			tagCond, tagParams := tagsCond(filter.Values(), filter.Match())
+			cond.WriteString(" and " + tagCond)
+			params = append(params, tagParams...)
+		} else {
+			cond.WriteString(" and title in (?" + strings.Repeat(",?", len(filter.Values())-1) + ")")
+			for _, t := range filter.Values() {
+				params = append(params, t)
+			}
+		}
+	}
+
+	if billable, ok := filter.Billable(); ok {
+		cond.WriteString(" and billable = ?")
+		params = append(params, billable)
+	}
+
+	for _, kv := range filter.Meta() {
+		cond.WriteString(` and exists (select 1 from activity_meta
+			where activity_id = clocking.id and key = ? and value = ?)`)
+		params = append(params, kv[0], kv[1])
 	}
 
-	result := ClosedActivity{&OpenActivity{title_, startTime, notes}, endTime}
-	return &result, nil
+	return cond.String(), params
 }
 
 var errTimeShouldBeUTC = errors.New("parameters should be in UTC")
@@ -167,107 +566,941 @@ func (s *sqlite) Closed(start, end time.Time, filter *QueryArg) ([]ClosedActivit
 		return nil, errTimeShouldBeUTC
 	}
 
-	query := `select title, start, end, notes
+	cond, filterParams := filterCond(filter)
+	query := `select ` + activityColumns + `
 		from clocking
-		where end is not null
+		where end is not null and user_id = ?
 		and start >= ? and start <= ?
-		%s
+		` + cond + `
 		order by start`
-	params := []any{start.Format(time.RFC3339), end.Format(time.RFC3339)}
+	params := []any{s.user, start.Format(time.RFC3339), end.Format(time.RFC3339)}
+	params = append(params, filterParams...)
 
-	if filter.Empty() {
-		query = fmt.Sprintf(query, "")
-	} else {
-		marks := make([]string, 0, len(filter.Values()))
-		var mark string
-		var valueF func(string) string
-		if filter.IsTag() {
-			mark = `title like ?`
-			valueF = func(t string) string { return t + ": %" }
-		} else {
-			mark = "title = ?"
-			valueF = func(t string) string { return t }
-		}
-		for _, t := range filter.Values() {
-			marks = append(marks, mark)
-			params = append(params, valueF(t))
+	return s.queryActivities(query, params)
+}
+
+// queryActivities returns activities of the query which selects activityColumns, with details loaded.
+func (s *sqlite) queryActivities(query string, params []any) ([]ClosedActivity, error) {
+	activities := make([]ClosedActivity, 0)
+	err := s.queryEach(query, params, func(rows *sql.Rows) error {
+		activity, err := scanActivity(rows)
+		if err != nil {
+			return err
 		}
-		query = fmt.Sprintf(query, "and ("+strings.Join(marks, " or ")+")")
-	}
 
-	rows, err := s.db.Query(query, params...)
+		activities = append(activities, *activity)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	activities := make([]ClosedActivity, 0)
-	for rows.Next() {
-		var title, start, end, notes string
-		if err := rows.Scan(&title, &start, &end, &notes); err != nil {
-			return nil, err
-		}
+	return activities, s.loadDetails(openActivities(activities))
+}
 
-		startTime, err := time.Parse(time.RFC3339, start)
-		if err != nil {
-			return nil, err
-		}
+func (s *sqlite) History(before, after Cursor, filter *QueryArg, limit int) ([]ClosedActivity, error) {
+	_, boffset := before.Start.Zone()
+	_, aoffset := after.Start.Zone()
+	if boffset != 0 || aoffset != 0 {
+		return nil, errTimeShouldBeUTC
+	}
 
-		endTime, err := time.Parse(time.RFC3339, end)
-		if err != nil {
-			return nil, err
-		}
+	cond, params := filterCond(filter)
+	cond += ` and user_id = ?`
+	params = append(params, s.user)
+	if !before.IsZero() {
+		cond += ` and (start, id) < (?, ?)`
+		params = append(params, before.Start.Format(time.RFC3339), before.Id)
+	}
+	if !after.IsZero() {
+		cond += ` and (start, id) > (?, ?)`
+		params = append(params, after.Start.Format(time.RFC3339), after.Id)
+	}
+
+	// the page right after 'after' if only it is given
+	ascending := before.IsZero() && !after.IsZero()
+	order := `desc`
+	if ascending {
+		order = `asc`
+	}
+
+	if limit <= 0 {
+		limit = -1
+	}
+	params = append(params, limit)
 
-		activities = append(activities, ClosedActivity{
-			&OpenActivity{title, startTime, notes},
-			endTime,
-		})
+	activities, err := s.queryActivities(`select `+activityColumns+`
+		from clocking
+		where end is not null
+		`+cond+`
+		order by start `+order+`, id `+order+`
+		limit ?`, params)
+	if err != nil {
+		return nil, err
 	}
 
+	if ascending {
+		for i, j := 0, len(activities)-1; i < j; i, j = i+1, j-1 {
+			activities[i], activities[j] = activities[j], activities[i]
+		}
+	}
 	return activities, nil
 }
 
-func (s *sqlite) Add(activity *ClosedActivity) error {
-	start := activity.Start.Format(time.RFC3339)
-	var exists uint
-	row := s.db.QueryRow(`select count(1) from clocking
-		where title = ? and start = ?`,
-		activity.Title,
-		start)
-	if err := row.Scan(&exists); err != nil {
-		return err
+// snippetWords is the max number of words of search snippets.
+const snippetWords = 16
+
+func (s *sqlite) Search(query string, start, end time.Time, filter *QueryArg, limit int) ([]SearchResult, error) {
+	_, soffset := start.Zone()
+	_, eoffset := end.Zone()
+	if soffset != 0 || eoffset != 0 {
+		return nil, errTimeShouldBeUTC
 	}
-	if exists > 0 {
-		return ErrDuplicateActivity
+
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
 	}
 
-	end := activity.End.Format(time.RFC3339)
-	_, err := s.db.Exec(`INSERT INTO clocking (title, start, end, notes)
-	VALUES(?,?,?,?)`,
-		activity.Title,
-		start,
-		end,
-		activity.Notes)
+	var match string
+	params := []any{}
+	if s.fts {
+		phrases := make([]string, 0, len(words))
+		for _, w := range words {
+			// quoted as phrases so that user input is never parsed as FTS5 query syntax
+			phrases = append(phrases, `"`+strings.ReplaceAll(w, `"`, `""`)+`"*`)
+		}
+		match = `join (select rowid,
+				snippet(activity_fts, -1, char(2), char(3), '...', ?) as snippet
+				from activity_fts where activity_fts match ?) m
+			on m.rowid = clocking.id
+			where end is not null`
+		params = append(params, snippetWords, strings.Join(phrases, " "))
+	} else {
+		var cond strings.Builder
+		for _, w := range words {
+			cond.WriteString(` and (title like ? escape '\' or ifnull(notes, '') like ? escape '\')`)
+			pattern := "%" + likeEscaper.Replace(w) + "%"
+			params = append(params, pattern, pattern)
+		}
+		match = `where end is not null` + cond.String()
+	}
 
-	return err
-}
+	match += ` and user_id = ?`
+	params = append(params, s.user)
+	if !start.IsZero() {
+		match += ` and start >= ?`
+		params = append(params, start.Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		match += ` and start <= ?`
+		params = append(params, end.Format(time.RFC3339))
+	}
 
-func newSqlite(db string) (sqlite, error) {
-	pool, err := sql.Open("sqlite3", db)
+	if limit <= 0 {
+		limit = -1
+	}
+	cond, filterParams := filterCond(filter)
+	params = append(params, filterParams...)
+	params = append(params, limit)
+
+	snippetColumn := `''`
+	if s.fts {
+		snippetColumn = `m.snippet`
+	}
+	rows, err := s.conn().Query(`select `+activityColumns+`, `+snippetColumn+`
+		from clocking
+		`+match+cond+`
+		order by start desc
+		limit ?`, params...)
 	if err != nil {
-		return sqlite{}, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	initSql := `CREATE TABLE IF NOT EXISTS clocking (
-                id INTEGER PRIMARY KEY,
-                title TEXT NOT NULL,
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var result SearchResult
+		activity, err := scanActivity(scannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &result.Snippet)...)
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		result.ClosedActivity = *activity
+		if !s.fts {
+			result.Snippet = snippet(result.Notes, words)
+			if result.Snippet == "" {
+				result.Snippet = snippet(result.Title, words)
+			}
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	activities := make([]*OpenActivity, 0, len(results))
+	for i := range results {
+		activities = append(activities, results[i].OpenActivity)
+	}
+	return results, s.loadDetails(activities)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// snippet returns at most snippetWords words of text around the first matched word, with
+// matched words enclosed by SnippetOpen and SnippetClose. Returns empty string if no word matches.
+func snippet(text string, words []string) string {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		quoted = append(quoted, regexp.QuoteMeta(w))
+	}
+	re := regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		if !re.MatchString(field) {
+			continue
+		}
+
+		from := i - snippetWords/2
+		if from < 0 {
+			from = 0
+		}
+		to := from + snippetWords
+		if to > len(fields) {
+			to = len(fields)
+		}
+
+		fragment := strings.Join(fields[from:to], " ")
+		if from > 0 {
+			fragment = "..." + fragment
+		}
+		if to < len(fields) {
+			fragment += "..."
+		}
+		return re.ReplaceAllString(fragment, SnippetOpen+"$0"+SnippetClose)
+	}
+
+	return ""
+}
+
+func openActivities(activities []ClosedActivity) []*OpenActivity {
+	result := make([]*OpenActivity, 0, len(activities))
+	for _, activity := range activities {
+		result = append(result, activity.OpenActivity)
+	}
+
+	return result
+}
+
+func (s *sqlite) Add(activity *ClosedActivity) error {
+	if err := s.checkDuplicate(0, activity.Title, activity.Start); err != nil {
+		return err
+	}
+
+	return s.insert(activity.OpenActivity, activity.End, nil)
+}
+
+func (s *sqlite) Get(id int64) (*ClosedActivity, error) {
+	row := s.conn().QueryRow(`SELECT `+activityColumns+`
+		FROM clocking
+		WHERE id = ? AND user_id = ?`,
+		id,
+		s.user)
+
+	activity, err := scanActivity(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return activity, s.loadDetails([]*OpenActivity{activity.OpenActivity})
+}
+
+func (s *sqlite) Update(activity *ClosedActivity) error {
+	if activity.End.IsZero() {
+		if err := s.checkOngoing(activity.Id); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkDuplicate(activity.Id, activity.Title, activity.Start); err != nil {
+		return err
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.Exec(`UPDATE clocking
+		SET title = ?, start = ?, end = ?, notes = ?, billable = ?, updated = ?
+		WHERE id = ? AND user_id = ?`,
+		activity.Title,
+		activity.Start.Format(time.RFC3339),
+		nullTime(activity.End),
+		activity.Notes,
+		activity.Billable,
+		time.Now().UnixNano(),
+		activity.Id,
+		s.user)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM activity_meta WHERE activity_id = ?`, activity.Id); err != nil {
+		return err
+	}
+	if err := insertMeta(tx, activity.Id, activity.Meta); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM activity_tags WHERE activity_id = ?`, activity.Id); err != nil {
+		return err
+	}
+	if err := insertTags(tx, activity.Id, activity.OpenActivity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlite) Delete(id int64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.Exec(`DELETE FROM clocking WHERE id = ? AND user_id = ?`, id, s.user)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM activity_meta WHERE activity_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM activity_tags WHERE activity_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlite) Tags() ([]TagUsage, error) {
+	rows, err := s.conn().Query(`SELECT t.name, count(c.id),
+			IFNULL(sum(strftime('%s', c.end) - strftime('%s', c.start)), 0)
+		FROM tags t
+			JOIN activity_tags at ON at.tag_id = t.id
+			JOIN clocking c ON c.id = at.activity_id
+		WHERE c.end IS NOT NULL AND c.user_id = ?
+		GROUP BY t.name
+		ORDER BY 3 DESC, t.name`, s.user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]TagUsage, 0)
+	for rows.Next() {
+		var tag TagUsage
+		var seconds int64
+		if err := rows.Scan(&tag.Name, &tag.Count, &seconds); err != nil {
+			return nil, err
+		}
+
+		tag.Total = time.Duration(seconds) * time.Second
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *sqlite) SetBillableDefault(tag string, billable bool) error {
+	_, err := s.conn().Exec(`INSERT INTO billable_tags (tag, billable)
+		VALUES(?,?)
+		ON CONFLICT (tag) DO UPDATE SET billable = excluded.billable`,
+		tag,
+		billable)
+
+	return err
+}
+
+func (s *sqlite) BillableDefaults() (map[string]bool, error) {
+	rows, err := s.conn().Query(`SELECT tag, billable FROM billable_tags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defaults := make(map[string]bool)
+	for rows.Next() {
+		var tag string
+		var billable bool
+		if err := rows.Scan(&tag, &billable); err != nil {
+			return nil, err
+		}
+
+		defaults[tag] = billable
+	}
+
+	return defaults, rows.Err()
+}
+
+func (s *sqlite) SetGoal(goal *Goal) error {
+	_, err := s.conn().Exec(`INSERT INTO goals (tag, period, target)
+		VALUES(?,?,?)
+		ON CONFLICT (tag, period) DO UPDATE SET target = excluded.target`,
+		goal.Tag,
+		goal.Period,
+		int64(goal.Target/time.Second))
+
+	return err
+}
+
+func (s *sqlite) Goals() ([]Goal, error) {
+	rows, err := s.conn().Query(`SELECT tag, period, target
+		FROM goals
+		ORDER BY tag, period`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := make([]Goal, 0)
+	for rows.Next() {
+		var goal Goal
+		var target int64
+		if err := rows.Scan(&goal.Tag, &goal.Period, &target); err != nil {
+			return nil, err
+		}
+
+		goal.Target = time.Duration(target) * time.Second
+		goals = append(goals, goal)
+	}
+
+	return goals, rows.Err()
+}
+
+func (s *sqlite) RemoveGoal(tag string, period GoalPeriod) error {
+	r, err := s.conn().Exec(`DELETE FROM goals WHERE tag = ? and period = ?`, tag, period)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlite) SetBudget(budget *Budget) error {
+	_, err := s.conn().Exec(`INSERT INTO budgets (name, is_tag, budget, start, end)
+		VALUES(?,?,?,?,?)
+		ON CONFLICT (name, is_tag) DO UPDATE
+		SET budget = excluded.budget, start = excluded.start, end = excluded.end`,
+		budget.Name,
+		budget.IsTag,
+		int64(budget.Limit/time.Second),
+		nullTime(budget.From),
+		nullTime(budget.To))
+
+	return err
+}
+
+func (s *sqlite) Budgets() ([]Budget, error) {
+	rows, err := s.conn().Query(`SELECT name, is_tag, budget, start, end
+		FROM budgets
+		ORDER BY name, is_tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := make([]Budget, 0)
+	for rows.Next() {
+		var budget Budget
+		var limit int64
+		var start, end sql.NullString
+		if err := rows.Scan(&budget.Name, &budget.IsTag, &limit, &start, &end); err != nil {
+			return nil, err
+		}
+
+		budget.Limit = time.Duration(limit) * time.Second
+		if budget.From, err = parseNullTime(start); err != nil {
+			return nil, err
+		}
+		if budget.To, err = parseNullTime(end); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, budget)
+	}
+
+	return budgets, rows.Err()
+}
+
+func (s *sqlite) RemoveBudget(name string, isTag bool) error {
+	r, err := s.conn().Exec(`DELETE FROM budgets WHERE name = ? and is_tag = ?`, name, isTag)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlite) BudgetUsage(budget *Budget) (time.Duration, error) {
+	query := `SELECT IFNULL(sum(strftime('%%s', end) - strftime('%%s', start)), 0)
+		FROM clocking
+		WHERE end IS NOT NULL and %s`
+	params := []any{s.user}
+	conds := []string{"user_id = ?"}
+	if budget.IsTag {
+		tagCond, tagParams := tagsCond([]string{budget.Name}, AnyTag)
+		conds = append(conds, tagCond)
+		params = append(params, tagParams...)
+	} else {
+		conds = append(conds, "title = ?")
+		params = append(params, budget.Name)
+	}
+	if !budget.From.IsZero() {
+		conds = append(conds, "start >= ?")
+		params = append(params, budget.From.UTC().Format(time.RFC3339))
+	}
+	if !budget.To.IsZero() {
+		conds = append(conds, "start <= ?")
+		params = append(params, budget.To.UTC().Format(time.RFC3339))
+	}
+
+	var seconds int64
+	row := s.conn().QueryRow(fmt.Sprintf(query, strings.Join(conds, " and ")), params...)
+	if err := row.Scan(&seconds); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func (s *sqlite) SetRate(rate *Rate) error {
+	_, err := s.conn().Exec(`INSERT INTO rates (name, is_tag, amount, currency, since)
+		VALUES(?,?,?,?,?)
+		ON CONFLICT (name, is_tag, since) DO UPDATE
+		SET amount = excluded.amount, currency = excluded.currency`,
+		rate.Name,
+		rate.IsTag,
+		rate.Amount,
+		rate.Currency,
+		sinceString(rate.Since))
+
+	return err
+}
+
+func (s *sqlite) Rates() ([]Rate, error) {
+	rows, err := s.conn().Query(`SELECT name, is_tag, amount, currency, since
+		FROM rates
+		ORDER BY name, is_tag, since`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make([]Rate, 0)
+	for rows.Next() {
+		var rate Rate
+		var since string
+		if err := rows.Scan(&rate.Name, &rate.IsTag, &rate.Amount, &rate.Currency, &since); err != nil {
+			return nil, err
+		}
+
+		if since != "" {
+			if rate.Since, err = time.Parse(time.RFC3339, since); err != nil {
+				return nil, err
+			}
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (s *sqlite) RemoveRate(name string, isTag bool, since time.Time) error {
+	r, err := s.conn().Exec(`DELETE FROM rates WHERE name = ? and is_tag = ? and since = ?`,
+		name, isTag, sinceString(since))
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlite) AddToken(token *Token, hash string) error {
+	var exists bool
+	if err := s.conn().QueryRow(`SELECT EXISTS (SELECT 1 FROM tokens WHERE name = ? AND user_id = ?)`, token.Name, s.user).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("token %s: %w", token.Name, ErrDuplicateToken)
+	}
+
+	r, err := s.conn().Exec(`INSERT INTO tokens (name, hash, scope, created, user_id) VALUES(?,?,?,?,?)`,
+		token.Name, hash, token.Scope, token.Created.UTC().Format(time.RFC3339), s.user)
+	if err != nil {
+		return err
+	}
+
+	token.Id, err = r.LastInsertId()
+	token.User = s.user
+	return err
+}
+
+func (s *sqlite) Tokens() ([]Token, error) {
+	rows, err := s.conn().Query(`SELECT `+tokenColumns+` FROM tokens WHERE user_id = ? ORDER BY name`, s.user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]Token, 0)
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *sqlite) FindToken(hash string) (*Token, error) {
+	token, err := scanToken(s.conn().QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE hash = ?`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return token, err
+}
+
+func (s *sqlite) RevokeToken(name string) error {
+	r, err := s.conn().Exec(`DELETE FROM tokens WHERE name = ? AND user_id = ?`, name, s.user)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// tokenColumns are columns scanned by scanToken
+const tokenColumns = `id, name, scope, created, user_id`
+
+func scanToken(row scanner) (*Token, error) {
+	var token Token
+	var created string
+	if err := row.Scan(&token.Id, &token.Name, &token.Scope, &created, &token.User); err != nil {
+		return nil, err
+	}
+
+	var err error
+	token.Created, err = time.Parse(time.RFC3339, created)
+	return &token, err
+}
+
+// sinceString formats t for storing in primary keys, where NULL is not comparable.
+func sinceString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nullTime formats t for storing, zero time is stored as NULL.
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: t.UTC().Format(time.RFC3339), Valid: true}
+}
+
+func parseNullTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s.String)
+}
+
+// migrations are applied in order on opening, each one at most once.
+// The number of applied migrations is tracked by 'PRAGMA user_version'.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS clocking (
+                id INTEGER PRIMARY KEY,
+                title TEXT NOT NULL,
                 start TEXT NOT NULL,
                 end TEXT NULL,
                 notes TEXT NULL
-             )`
+             )`,
+	`CREATE TABLE goals (
+                tag TEXT NOT NULL,
+                period TEXT NOT NULL,
+                target INTEGER NOT NULL,
+                PRIMARY KEY (tag, period)
+             )`,
+	`CREATE TABLE budgets (
+                name TEXT NOT NULL,
+                is_tag INTEGER NOT NULL,
+                budget INTEGER NOT NULL,
+                start TEXT NULL,
+                end TEXT NULL,
+                PRIMARY KEY (name, is_tag)
+             )`,
+	`CREATE TABLE rates (
+                name TEXT NOT NULL,
+                is_tag INTEGER NOT NULL,
+                amount INTEGER NOT NULL,
+                currency TEXT NOT NULL,
+                since TEXT NOT NULL,
+                PRIMARY KEY (name, is_tag, since)
+             )`,
+	`ALTER TABLE clocking ADD COLUMN billable INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE billable_tags (
+                tag TEXT PRIMARY KEY,
+                billable INTEGER NOT NULL
+             )`,
+	`CREATE TABLE activity_meta (
+                activity_id INTEGER NOT NULL,
+                key TEXT NOT NULL,
+                value TEXT NOT NULL,
+                PRIMARY KEY (activity_id, key)
+             )`,
+	`CREATE TABLE tags (
+                id INTEGER PRIMARY KEY,
+                name TEXT NOT NULL UNIQUE
+             )`,
+	`CREATE TABLE activity_tags (
+                activity_id INTEGER NOT NULL,
+                tag_id INTEGER NOT NULL,
+                PRIMARY KEY (activity_id, tag_id)
+             )`,
+	// derives tags of existing activities from titles, same as OpenActivity.Tag()
+	`INSERT INTO tags (name)
+                SELECT DISTINCT ` + titleTagExpr + ` FROM clocking`,
+	`INSERT INTO activity_tags (activity_id, tag_id)
+                SELECT c.id, t.id FROM clocking c JOIN tags t ON t.name = ` + titleTagExpr,
+	`CREATE INDEX clocking_start ON clocking (start)`,
+	`CREATE TABLE tokens (
+                id INTEGER PRIMARY KEY,
+                name TEXT NOT NULL UNIQUE,
+                hash TEXT NOT NULL UNIQUE,
+                scope TEXT NOT NULL,
+                created TEXT NOT NULL
+             )`,
+	// origin is the device where an activity was created and origin_id is its id there, both NULL if
+	// created on this device. updated is when it was last changed here, in unix nanoseconds.
+	`ALTER TABLE clocking ADD COLUMN origin TEXT NULL`,
+	`ALTER TABLE clocking ADD COLUMN origin_id INTEGER NULL`,
+	`ALTER TABLE clocking ADD COLUMN updated INTEGER NOT NULL DEFAULT 0`,
+	`CREATE UNIQUE INDEX clocking_origin ON clocking (origin, origin_id)`,
+	`CREATE INDEX clocking_updated ON clocking (updated)`,
+	`CREATE TABLE device AS SELECT lower(hex(randomblob(16))) AS id`,
+	// pulled is the latest updated of activities merged from the device, synced is when they were merged.
+	`CREATE TABLE sync_peers (
+                device TEXT PRIMARY KEY,
+                pulled INTEGER NOT NULL,
+                synced INTEGER NOT NULL
+             )`,
+	// activities and tokens are owned by users, 0 is the owner of the db, see Store.ForUser
+	`ALTER TABLE clocking ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX clocking_user ON clocking (user_id, end)`,
+	`CREATE TABLE users (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                name TEXT NOT NULL UNIQUE,
+                password TEXT NOT NULL,
+                admin INTEGER NOT NULL,
+                created TEXT NOT NULL
+             )`,
+	// names of tokens are unique per user
+	`CREATE TABLE tokens_by_user (
+                id INTEGER PRIMARY KEY,
+                user_id INTEGER NOT NULL DEFAULT 0,
+                name TEXT NOT NULL,
+                hash TEXT NOT NULL UNIQUE,
+                scope TEXT NOT NULL,
+                created TEXT NOT NULL,
+                UNIQUE (user_id, name)
+             )`,
+	`INSERT INTO tokens_by_user (id, name, hash, scope, created)
+                SELECT id, name, hash, scope, created FROM tokens`,
+	`DROP TABLE tokens`,
+	`ALTER TABLE tokens_by_user RENAME TO tokens`,
+	// explicit is 0 for the tag derived from the title only, see insertTags
+	`ALTER TABLE activity_tags ADD COLUMN explicit INTEGER NOT NULL DEFAULT 1`,
+	`UPDATE activity_tags SET explicit = 0
+                WHERE tag_id = (SELECT t.id FROM clocking c JOIN tags t ON t.name = ` + titleTagExpr + `
+                        WHERE c.id = activity_tags.activity_id)`,
+}
+
+// titleTagExpr is the tag derived from title in SQL, see OpenActivity.Tag()
+const titleTagExpr = `CASE WHEN instr(title, ': ') > 0
+                THEN substr(title, 1, instr(title, ': ') - 1)
+                ELSE title END`
+
+func migrate(pool *sql.DB) error {
+	var version int
+	if err := pool.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := pool.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+		// PRAGMA does not accept parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
 
-	if _, err := pool.Exec(initSql); err != nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// searchDDL creates the full-text index of titles and notes, and triggers keeping it up to date.
+// It is not in migrations since FTS5 is only available when built with tag 'sqlite_fts5'.
+var searchDDL = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS activity_fts USING fts5(
+		title, notes, content='clocking', content_rowid='id'
+	)`,
+	`INSERT INTO activity_fts(activity_fts) VALUES ('rebuild')`,
+	`CREATE TRIGGER IF NOT EXISTS clocking_fts_insert AFTER INSERT ON clocking BEGIN
+		INSERT INTO activity_fts(rowid, title, notes) VALUES (new.id, new.title, new.notes);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS clocking_fts_delete AFTER DELETE ON clocking BEGIN
+		INSERT INTO activity_fts(activity_fts, rowid, title, notes) VALUES ('delete', old.id, old.title, old.notes);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS clocking_fts_update AFTER UPDATE OF title, notes ON clocking BEGIN
+		INSERT INTO activity_fts(activity_fts, rowid, title, notes) VALUES ('delete', old.id, old.title, old.notes);
+		INSERT INTO activity_fts(rowid, title, notes) VALUES (new.id, new.title, new.notes);
+	END`,
+}
+
+var searchTriggers = []string{"clocking_fts_insert", "clocking_fts_delete", "clocking_fts_update"}
+
+// setupSearch creates the full-text index if FTS5 is available, and returns whether it is.
+// Without FTS5, triggers created by a build with it are dropped since they fail every write, and
+// the index is rebuilt when the db is opened by such a build again.
+func setupSearch(pool *sql.DB) (bool, error) {
+	var fts bool
+	if err := pool.QueryRow(`select sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts); err != nil {
+		return false, err
+	}
+
+	if fts {
+		var count int
+		if err := pool.QueryRow(`select count(*) from sqlite_master where type = 'trigger' and name in (?, ?, ?)`,
+			searchTriggers[0], searchTriggers[1], searchTriggers[2]).Scan(&count); err != nil {
+			return false, err
+		}
+		if count == len(searchTriggers) {
+			return true, nil
+		}
+	}
+
+	tx, err := pool.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, trigger := range searchTriggers {
+		if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + trigger); err != nil {
+			return false, err
+		}
+	}
+	if fts {
+		for _, ddl := range searchDDL {
+			if _, err := tx.Exec(ddl); err != nil {
+				return false, fmt.Errorf("setup search: %w", err)
+			}
+		}
+	}
+
+	return fts, tx.Commit()
+}
+
+func (s *sqlite) ForUser(id int64) Store {
+	return &sqlite{db: s.db, tx: s.tx, fts: s.fts, user: id}
+}
+
+func (s *sqlite) Ping() error {
+	var version int
+	return s.conn().QueryRow(`PRAGMA user_version`).Scan(&version)
+}
+
+func (s *sqlite) Close() error {
+	return s.db.Close()
+}
+
+func newSqlite(db string) (sqlite, error) {
+	pool, err := sql.Open("sqlite3", db)
+	if err != nil {
+		return sqlite{}, err
+	}
+
+	if err := migrate(pool); err != nil {
+		return sqlite{}, err
+	}
+
+	fts, err := setupSearch(pool)
+	if err != nil {
 		return sqlite{}, err
 	}
 
-	return sqlite{pool}, nil
+	return sqlite{db: pool, fts: fts}, nil
 }
@@ -1,16 +1,48 @@
 package store
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type OpenActivity struct {
-	Title string
-	Start time.Time
-	Notes string
+	// Id is assigned by Store when the activity is started or added.
+	Id       int64
+	Title    string
+	Start    time.Time
+	Notes    string
+	Billable bool
+	// Meta is arbitrary key/value metadata, nil if there is none.
+	Meta map[string]string
+	// Tags are tags given to the activity in addition to the one derived from Title, see Tag() and
+	// AllTags(). The derived tag is not one of them unless it is given too.
+	Tags []string
+}
+
+// MetaString returns metadata as "key1=value1, key2=value2" ordered by key.
+func (activity *OpenActivity) MetaString() string {
+	keys := make([]string, 0, len(activity.Meta))
+	for key := range activity.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+activity.Meta[key])
+	}
+
+	return strings.Join(pairs, ", ")
 }
 
 type ClosedActivity struct {
@@ -24,23 +56,545 @@ func (activity *ClosedActivity) String() string {
 		fmt.Fprintf(&notes, "    %s\n", s)
 	}
 
-	return fmt.Sprintf("%s\n%s ~ %s\n%s",
+	billable := "non-billable"
+	if activity.Billable {
+		billable = "billable"
+	}
+
+	meta := ""
+	if tags := activity.AllTags(); len(tags) > 1 {
+		meta = " | tags: " + strings.Join(tags, ", ")
+	}
+	if len(activity.Meta) > 0 {
+		meta += " | " + activity.MetaString()
+	}
+
+	return fmt.Sprintf("%s\n%s ~ %s | #%d | %s%s\n%s",
 		activity.Title,
 		activity.Start.Local().Format(time.DateTime),
 		activity.End.Local().Format(time.DateTime),
+		activity.Id,
+		billable,
+		meta,
 		strings.TrimRight(notes.String(), "\n"))
 }
 
-func (activity *ClosedActivity) Tag() string {
+// Tag returns the part of title before the first ": ", or the title itself if there is none.
+func (activity *OpenActivity) Tag() string {
 	return strings.SplitN(activity.Title, ": ", 2)[0]
 }
 
+// AllTags returns distinct tags of the activity including Tag(), sorted.
+func (activity *OpenActivity) AllTags() []string {
+	tags := []string{activity.Tag()}
+	for _, tag := range activity.Tags {
+		if tag != "" && !activity.hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// HasTag reports whether tag is Tag() or one of Tags.
+func (activity *OpenActivity) HasTag(tag string) bool {
+	return tag == activity.Tag() || activity.hasTag(activity.Tags, tag)
+}
+
+func (activity *OpenActivity) hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matched words in SearchResult.Snippet are enclosed by SnippetOpen and SnippetClose.
+const (
+	SnippetOpen  = "\x02"
+	SnippetClose = "\x03"
+)
+
+// SearchResult is an activity matched by Store.Search.
+type SearchResult struct {
+	ClosedActivity
+	// Snippet is a fragment of the notes (or title) around matched words.
+	Snippet string
+}
+
+// Highlight returns Snippet with matched words enclosed by open and close.
+func (r *SearchResult) Highlight(open, close string) string {
+	return strings.NewReplacer(SnippetOpen, open, SnippetClose, close).Replace(r.Snippet)
+}
+
+// TitleRank is the order of titles returned by Store.Titles.
+type TitleRank string
+
+const (
+	// RankRecent orders titles by their latest Start.
+	RankRecent TitleRank = "recent"
+	// RankFrecency orders titles by the sum of FrecencyScore of their activities.
+	RankFrecency TitleRank = "frecency"
+)
+
+// frecencyDecayDays is the age in days which halves the score of an activity.
+const frecencyDecayDays = 7
+
+// affinityWindow is how close time of day of two activities are considered similar.
+const affinityWindow = time.Hour
+
+// FrecencyScore returns the score of an activity started at 'start' for ranking its title at 'at'.
+// The score decays with age of the activity, and is doubled if it started at similar time of day
+// as 'at', and multiplied by 1.5 if on the same weekday.
+func FrecencyScore(start, at time.Time) float64 {
+	age := at.Sub(start).Hours() / 24
+	if age < 0 {
+		age = 0
+	}
+	score := 1 / (1 + age/frecencyDecayDays)
+
+	start, at = start.Local(), at.Local()
+	sinceMidnight := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	diff := sinceMidnight(start) - sinceMidnight(at)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > 12*time.Hour {
+		diff = 24*time.Hour - diff
+	}
+
+	if diff <= affinityWindow {
+		score *= 2
+	}
+	if start.Weekday() == at.Weekday() {
+		score *= 1.5
+	}
+	return score
+}
+
+// MatchTitle returns whether title matches pattern case-insensitively, and whether it is a prefix
+// match. Otherwise it is a fuzzy match: all characters of pattern appear in title in order.
+func MatchTitle(title, pattern string) (matched bool, prefix bool) {
+	title, pattern = strings.ToLower(title), strings.ToLower(pattern)
+	if strings.HasPrefix(title, pattern) {
+		return true, true
+	}
+
+	rest := title
+	for _, r := range pattern {
+		i := strings.IndexRune(rest, r)
+		if i < 0 {
+			return false, false
+		}
+		rest = rest[i+utf8.RuneLen(r):]
+	}
+	return true, false
+}
+
+// FilterTitles keeps titles matching pattern, prefix matches ahead of fuzzy matches and otherwise
+// in the original order, then returns at most 'limit' of them. limit <= 0 means no limit.
+func FilterTitles(titles []string, pattern string, limit int) []string {
+	result := titles
+	if pattern != "" {
+		result = make([]string, 0)
+		fuzzy := make([]string, 0)
+		for _, title := range titles {
+			if matched, prefix := MatchTitle(title, pattern); prefix {
+				result = append(result, title)
+			} else if matched {
+				fuzzy = append(fuzzy, title)
+			}
+		}
+		result = append(result, fuzzy...)
+	}
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Cursor is a position in activities ordered by Start then Id, for paging through history.
+// Cursor{Start: t} is right before all activities starting at t.
+type Cursor struct {
+	Start time.Time
+	Id    int64
+}
+
+// CursorOf returns the position of the activity.
+func CursorOf(activity *OpenActivity) Cursor {
+	return Cursor{activity.Start, activity.Id}
+}
+
+func (c Cursor) IsZero() bool {
+	return c.Start.IsZero()
+}
+
+// TagUsage is the number and total time of closed activities of a tag.
+type TagUsage struct {
+	Name  string
+	Count int
+	Total time.Duration
+}
+
 var ErrOngoingExists = errors.New("ongoing activity exists")
 var ErrDuplicateActivity = errors.New("activity already started")
+var ErrNotFound = errors.New("not found")
+var ErrEmptyQuery = errors.New("empty search query")
+var ErrDuplicateToken = errors.New("token already exists")
+var ErrDuplicateUser = errors.New("user already exists")
+
+type GoalPeriod string
+
+const (
+	PerDay  GoalPeriod = "day"
+	PerWeek GoalPeriod = "week"
+)
+
+// Start returns the local start time of the period which t falls in. Weeks start from Monday.
+func (p GoalPeriod) Start(t time.Time) time.Time {
+	t = t.Local()
+	day := t.Day()
+	if p == PerWeek {
+		day -= int((t.Weekday() + 7 - 1) % 7)
+	}
+
+	return time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.Local)
+}
+
+// Shift returns the start of the n-th period before (n < 0) or after (n > 0) the period starts at start.
+func (p GoalPeriod) Shift(start time.Time, n int) time.Time {
+	if p == PerWeek {
+		n *= 7
+	}
+
+	return start.AddDate(0, 0, n)
+}
+
+// Goal is the target time to spend on activities of Tag in every Period.
+type Goal struct {
+	Tag    string
+	Period GoalPeriod
+	Target time.Duration
+}
+
+// Budget limits time to spend on activities of a title, or of a tag if IsTag,
+// whose Start is within [From, To]. Zero From or To means unbounded.
+type Budget struct {
+	Name  string
+	IsTag bool
+	Limit time.Duration
+	From  time.Time
+	To    time.Time
+}
+
+// Matches reports whether the activity is counted in the budget.
+func (b *Budget) Matches(activity *OpenActivity) bool {
+	if b.IsTag {
+		if !activity.HasTag(b.Name) {
+			return false
+		}
+	} else if activity.Title != b.Name {
+		return false
+	}
+
+	return (b.From.IsZero() || !activity.Start.Before(b.From)) &&
+		(b.To.IsZero() || !activity.Start.After(b.To))
+}
+
+func (b *Budget) String() string {
+	kind := "title"
+	if b.IsTag {
+		kind = "tag"
+	}
+
+	if b.From.IsZero() && b.To.IsZero() {
+		return fmt.Sprintf("%s (%s)", b.Name, kind)
+	}
+
+	from, to := "", ""
+	if !b.From.IsZero() {
+		from = b.From.Local().Format(time.DateOnly)
+	}
+	if !b.To.IsZero() {
+		to = b.To.Local().Format(time.DateOnly)
+	}
+	return fmt.Sprintf("%s (%s, %s ~ %s)", b.Name, kind, from, to)
+}
+
+// Thresholds of used/limit ratio to warn about a budget.
+const (
+	BudgetWarnRatio     = 0.8
+	BudgetExceededRatio = 1.0
+)
+
+type BudgetStatus struct {
+	Budget
+	Used time.Duration
+}
+
+func (s *BudgetStatus) Ratio() float64 {
+	if s.Limit <= 0 {
+		return BudgetExceededRatio
+	}
+
+	return float64(s.Used) / float64(s.Limit)
+}
+
+func (s *BudgetStatus) Remaining() time.Duration {
+	if s.Used > s.Limit {
+		return 0
+	}
+
+	return s.Limit - s.Used
+}
+
+// Warning returns a message if the budget crossed BudgetWarnRatio, otherwise empty string.
+func (s *BudgetStatus) Warning() string {
+	ratio := s.Ratio()
+	used, limit := Hours(s.Used), Hours(s.Limit)
+	switch {
+	case ratio >= BudgetExceededRatio:
+		return fmt.Sprintf("Budget %s exceeded: %s of %s used (%.0f%%)", &s.Budget, used, limit, ratio*100)
+	case ratio >= BudgetWarnRatio:
+		return fmt.Sprintf("Budget %s: %s of %s used (%.0f%%)", &s.Budget, used, limit, ratio*100)
+	default:
+		return ""
+	}
+}
+
+// Hours returns string represention of d in hours with at most 1 decimal, as "12.5h"
+func Hours(d time.Duration) string {
+	return strconv.FormatFloat(math.Round(d.Hours()*10)/10, 'f', -1, 64) + "h"
+}
+
+// ActivityBudgets returns status of budgets which the activity is counted in. Time 'running'
+// is counted as used in addition, for example, the time since an ongoing activity started.
+func ActivityBudgets(ss Store, activity *OpenActivity, running time.Duration) ([]BudgetStatus, error) {
+	budgets, err := ss.Budgets()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]BudgetStatus, 0)
+	for i := range budgets {
+		if !budgets[i].Matches(activity) {
+			continue
+		}
+
+		used, err := ss.BudgetUsage(&budgets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, BudgetStatus{budgets[i], used + running})
+	}
+
+	return statuses, nil
+}
+
+// level returns 2 if the budget is exceeded, 1 if it crossed BudgetWarnRatio, otherwise 0.
+func (s *BudgetStatus) level() int {
+	switch ratio := s.Ratio(); {
+	case ratio >= BudgetExceededRatio:
+		return 2
+	case ratio >= BudgetWarnRatio:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// BudgetWarnings returns warnings of budgets which the activity is counted in. An ongoing activity
+// (zero End) warns of every budget at or over a threshold, counting the time since it started
+// until now, so that it warns at start of a budget used up already. A closed activity, whose time
+// is counted in usage of budgets already, only warns of thresholds crossed by it: the budget is
+// below the threshold without the time of the activity, and reaches it with the time.
+func BudgetWarnings(ss Store, activity *ClosedActivity, now time.Time) ([]string, error) {
+	ongoing := activity.End.IsZero()
+	running := time.Duration(0)
+	if ongoing {
+		running = now.Sub(activity.Start)
+	}
+
+	statuses, err := ActivityBudgets(ss, activity.OpenActivity, running)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := make([]string, 0)
+	for i := range statuses {
+		before := statuses[i]
+		if !ongoing {
+			before.Used -= activity.End.Sub(activity.Start)
+		}
+		if ongoing && statuses[i].level() > 0 || !ongoing && statuses[i].level() > before.level() {
+			warnings = append(warnings, statuses[i].Warning())
+		}
+	}
+
+	return warnings, nil
+}
+
+// TokenScope is what a token allows to do with the server.
+type TokenScope string
+
+const (
+	// ScopeRead allows reading only.
+	ScopeRead TokenScope = "read"
+	// ScopeWrite allows reading and changing activities.
+	ScopeWrite TokenScope = "write"
+)
+
+// Allows reports whether the scope allows what scope 'required' allows.
+func (scope TokenScope) Allows(required TokenScope) bool {
+	return scope == ScopeWrite || scope == required
+}
+
+// Token is an API token of the server. Only hashes of secrets are stored, see HashToken.
+type Token struct {
+	Id      int64
+	Name    string
+	Scope   TokenScope
+	Created time.Time
+	// User is the id of the user who owns the token, 0 for the owner of the db.
+	User int64
+}
+
+// tokenPrefix is the prefix of token secrets, which makes them easy to recognize.
+const tokenPrefix = "tt_"
+
+// NewTokenSecret returns a random secret of 256 bits.
+func NewTokenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of the secret to store. Secrets are random, so a plain SHA-256 is enough.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Rate is the hourly rate of a title, or of a tag if IsTag, effective since Since.
+// Amount is in minor units of Currency, e.g. cents.
+type Rate struct {
+	Name     string
+	IsTag    bool
+	Amount   int64
+	Currency string
+	Since    time.Time
+}
+
+// FindRate returns the rate applies to the activity, nil if none. A title rate takes precedence
+// over tag rates, of which the one of Tag() takes precedence over the ones of Tags, see FindTagRate.
+func FindRate(rates []Rate, activity *OpenActivity) *Rate {
+	for _, tag := range append([]string{activity.Tag()}, activity.AllTags()...) {
+		if r := FindTagRate(rates, activity, tag); r != nil {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// FindTagRate returns the rate of the title of the activity, or the one of the tag if there is
+// none, nil if neither. The one with the latest Since not after the activity's Start wins.
+func FindTagRate(rates []Rate, activity *OpenActivity, tag string) *Rate {
+	var found *Rate
+	for i := range rates {
+		r := &rates[i]
+		if r.IsTag && r.Name != tag || !r.IsTag && r.Name != activity.Title {
+			continue
+		}
+		if r.Since.After(activity.Start) {
+			continue
+		}
+
+		if found == nil ||
+			found.IsTag && !r.IsTag ||
+			found.IsTag == r.IsTag && r.Since.After(found.Since) {
+			found = r
+		}
+	}
+
+	return found
+}
+
+// BillableDefault returns whether the activity is billable by default: the billable default of
+// Tag() if any, otherwise whether one of Tags is billable by default.
+func BillableDefault(defaults map[string]bool, activity *OpenActivity) bool {
+	if billable, ok := defaults[activity.Tag()]; ok {
+		return billable
+	}
+	for _, tag := range activity.Tags {
+		if defaults[tag] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAmount parses decimal amount with at most 2 decimals, e.g. "120.5", into minor units.
+func ParseAmount(s string) (int64, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %s: at most 2 decimals", s)
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s: %w", s, err)
+	}
+
+	var f uint64
+	if frac != "" {
+		if f, err = strconv.ParseUint(frac+strings.Repeat("0", 2-len(frac)), 10, 8); err != nil {
+			return 0, fmt.Errorf("invalid amount %s: %w", s, err)
+		}
+	}
+
+	return int64(w*100 + f), nil
+}
+
+// FormatAmount formats minor units amount as decimal, e.g. "120.50"
+func FormatAmount(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// TagMatch is how activities are matched by tags of a QueryArg.
+type TagMatch int
+
+const (
+	// AnyTag matches activities with any of the tags.
+	AnyTag TagMatch = iota
+	// AllTags matches activities with all of the tags.
+	AllTags
+	// NoneTags matches activities with none of the tags.
+	NoneTags
+)
 
 type QueryArg struct {
-	values []string
-	asTag  bool
+	values   []string
+	asTag    bool
+	match    TagMatch
+	billable *bool
+	meta     [][2]string
 }
 
 func NewTitleArg(titles []string) *QueryArg {
@@ -54,7 +608,12 @@ func NewTitleArg(titles []string) *QueryArg {
 	}
 }
 
+// NewTagArg matches activities with any of the tags.
 func NewTagArg(tags []string) *QueryArg {
+	return NewTagMatchArg(tags, AnyTag)
+}
+
+func NewTagMatchArg(tags []string, match TagMatch) *QueryArg {
 	if tags == nil {
 		return nil
 	}
@@ -62,9 +621,51 @@ func NewTagArg(tags []string) *QueryArg {
 	return &QueryArg{
 		values: tags,
 		asTag:  true,
+		match:  match,
+	}
+}
+
+// WithBillable filters activities further by billable. If q is nil, returns a new QueryArg
+// filters by billable only.
+func (q *QueryArg) WithBillable(billable bool) *QueryArg {
+	if q == nil {
+		q = &QueryArg{}
+	}
+
+	q.billable = &billable
+	return q
+}
+
+// Billable returns the billable filter, ok is false if not filtered by billable.
+func (q *QueryArg) Billable() (billable bool, ok bool) {
+	if q == nil || q.billable == nil {
+		return false, false
+	}
+
+	return *q.billable, true
+}
+
+// WithMeta filters activities further by metadata key=value, multiple metadata filters are all
+// required to match. If q is nil, returns a new QueryArg filters by metadata only.
+func (q *QueryArg) WithMeta(key, value string) *QueryArg {
+	if q == nil {
+		q = &QueryArg{}
+	}
+
+	q.meta = append(q.meta, [2]string{key, value})
+	return q
+}
+
+// Meta returns metadata filters as key, value pairs.
+func (q *QueryArg) Meta() [][2]string {
+	if q == nil {
+		return nil
 	}
+
+	return q.meta
 }
 
+// Empty reports whether there is no title or tag to filter by.
 func (q *QueryArg) Empty() bool {
 	return q == nil || len(q.values) == 0
 }
@@ -81,6 +682,15 @@ func (q *QueryArg) IsTag() bool {
 	return q != nil && q.asTag
 }
 
+// Match returns how to match activities by tags, only meaningful if IsTag().
+func (q *QueryArg) Match() TagMatch {
+	if q == nil {
+		return AnyTag
+	}
+
+	return q.match
+}
+
 type Store interface {
 	// Start an activity.
 	//  1. No new activity allowed if there is already an open activity exists.
@@ -89,6 +699,7 @@ type Store interface {
 	Start(*OpenActivity) error
 
 	// StartTitle starts an activity with given title and notes, and 'now' as Start.
+	// The activity is billable if its tag is billable by default.
 	StartTitle(title, note string) error
 
 	// CloseActivity closes the open activity (if any).
@@ -99,6 +710,10 @@ type Store interface {
 	// RecentTitles returns at most 'limit' number of distinct titles of recent closed activities.
 	RecentTitles(limit uint8) ([]string, error)
 
+	// Titles returns at most 'limit' distinct titles of closed activities ordered by 'rank' at time 'at',
+	// filtered by pattern as FilterTitles if it is not empty. limit <= 0 means no limit.
+	Titles(rank TitleRank, pattern string, at time.Time, limit int) ([]string, error)
+
 	// Ongoing returns the open activity (if any), otherwise return nil.
 	Ongoing() (*OpenActivity, error)
 
@@ -110,11 +725,137 @@ type Store interface {
 	// Both queryStart and queryEnd must be UTC time
 	// If filter is not nil:
 	//   if filter is title filter, only returns activities with 'title in filter.values'.
-	//   if filter is tag filter, returns activities with 'ClosedActivity.Tag() in filter.values'.
+	//   if filter is tag filter, returns activities with any/all/none (by filter.Match()) of Tags in filter.values.
+	//   if filter has billable filter, only returns activities with the same Billable.
+	//   if filter has metadata filters, only returns activities with all of the metadata.
 	Closed(queryStart, queryEnd time.Time, filter *QueryArg) ([]ClosedActivity, error)
 
+	// Search returns at most 'limit' closed activities, newest first, whose title or notes contain
+	// all words of query. queryStart, queryEnd and filter are the same as Closed, except that zero
+	// queryStart or queryEnd means no bound, and limit <= 0 means no limit.
+	// Returns ErrEmptyQuery if query has no words.
+	Search(query string, queryStart, queryEnd time.Time, filter *QueryArg, limit int) ([]SearchResult, error)
+
+	// History returns at most 'limit' closed activities, newest first, positioned between 'after' and
+	// 'before' (both excluded). Zero cursor means no bound, and limit <= 0 means no limit. If only
+	// 'after' is given, returns the ones right after it, so that pages can be turned both ways.
+	// Start of cursors must be UTC time. filter is the same as Closed.
+	History(before, after Cursor, filter *QueryArg, limit int) ([]ClosedActivity, error)
+
 	// Add adds a ClosedActivity. Returns error when there is already an activity with the same Title and Start.
 	Add(activity *ClosedActivity) error
+
+	// Get returns the activity of given id, End is zero if it is open. Returns ErrNotFound if no such activity.
+	Get(id int64) (*ClosedActivity, error)
+
+	// Update replaces Title, Start, End, Notes, Billable, Meta and Tags of the activity with the same Id.
+	// Zero End makes the activity open.
+	//  1. Returns ErrOngoingExists if to open it while there is another open activity.
+	//  2. Returns ErrDuplicateActivity if there is another activity with the same Title and Start.
+	//  3. Returns ErrNotFound if no such activity.
+	Update(activity *ClosedActivity) error
+
+	// Delete deletes the activity of given id, open or closed. Returns ErrNotFound if no such activity.
+	Delete(id int64) error
+
+	// Tags returns usage of all tags of closed activities, ordered by total time descending.
+	Tags() ([]TagUsage, error)
+
+	// SetBillableDefault sets whether activities of the tag are billable by default.
+	SetBillableDefault(tag string, billable bool) error
+
+	// BillableDefaults returns billable defaults keyed by tag. StartTitle uses them to set Billable,
+	// see BillableDefault.
+	BillableDefaults() (map[string]bool, error)
+
+	// SetGoal creates the goal, or replaces the target of existing goal with the same Tag and Period.
+	SetGoal(goal *Goal) error
+
+	// Goals returns all goals ordered by Tag and Period.
+	Goals() ([]Goal, error)
+
+	// RemoveGoal removes the goal of given tag and period. Returns ErrNotFound if there is no such goal.
+	RemoveGoal(tag string, period GoalPeriod) error
+
+	// SetBudget creates the budget, or replaces the existing one with the same Name and IsTag.
+	SetBudget(budget *Budget) error
+
+	// Budgets returns all budgets ordered by Name.
+	Budgets() ([]Budget, error)
+
+	// RemoveBudget removes the budget of given name. Returns ErrNotFound if there is no such budget.
+	RemoveBudget(name string, isTag bool) error
+
+	// BudgetUsage returns the total time of closed activities counted in the budget.
+	BudgetUsage(budget *Budget) (time.Duration, error)
+
+	// SetRate creates the rate, or replaces the existing one with the same Name, IsTag and Since.
+	SetRate(rate *Rate) error
+
+	// Rates returns all rates ordered by Name and Since.
+	Rates() ([]Rate, error)
+
+	// RemoveRate removes the rate of given name effective since 'since'. Returns ErrNotFound if there is no such rate.
+	RemoveRate(name string, isTag bool, since time.Time) error
+
+	// AddToken adds the token of the user of the store with hash of its secret, sets Id and User of it.
+	// Returns ErrDuplicateToken if the user has a token with the same Name.
+	AddToken(token *Token, hash string) error
+
+	// Tokens returns all tokens of the user of the store ordered by Name.
+	Tokens() ([]Token, error)
+
+	// FindToken returns the token of the hash, of any user. Returns ErrNotFound if there is no such token.
+	FindToken(hash string) (*Token, error)
+
+	// RevokeToken deletes the token of given name of the user of the store. Returns ErrNotFound if
+	// there is no such token.
+	RevokeToken(name string) error
+
+	// AddUser adds the user with the password, sets Id of it. Returns ErrDuplicateUser if there is
+	// a user with the same Name.
+	AddUser(user *User, password string) error
+
+	// Users returns all users ordered by Name.
+	Users() ([]User, error)
+
+	// GetUser returns the user of given id. Returns ErrNotFound if there is no such user.
+	GetUser(id int64) (*User, error)
+
+	// CheckPassword returns the user of given name if the password is right. Returns ErrNotFound if
+	// there is no such user or the password is wrong.
+	CheckPassword(name, password string) (*User, error)
+
+	// SetPassword changes the password of the user. Returns ErrNotFound if there is no such user.
+	SetPassword(name, password string) error
+
+	// RemoveUser removes the user and its tokens, its activities are kept. Returns ErrNotFound if
+	// there is no such user.
+	RemoveUser(name string) error
+
+	// ForUser returns the Store of activities and tokens of the user of given id, the one of user 0
+	// is the Store opened, which is owned by the owner of the db. Users, goals, budgets, rates and
+	// billable defaults are shared by all users. It shares the db with the Store, closing either
+	// one closes both.
+	ForUser(id int64) Store
+
+	// Merge merges closed activities changed in the ticktock database file 'other' since the last
+	// merge from it. Activities are matched by the device where they were created and their id
+	// there, then by Title and Start. The ones edited here since the last merge and differ are
+	// conflicts, which are kept as here. Deleted activities are not merged. 'other' is opened
+	// read-only, and must be of the same version of the schema. All changes are made in a
+	// transaction, so nothing is merged if it fails.
+	Merge(other string) (*MergeReport, error)
+
+	// NewDeviceId assigns a new random id to the device of the database, so that a copy of a
+	// database file can be merged with the original one.
+	NewDeviceId() error
+
+	// Ping checks that the database can be read.
+	Ping() error
+
+	// Close closes the store, it should not be used any more.
+	Close() error
 }
 
 func NewSqliteStore(db string) (Store, error) {
@@ -125,3 +866,18 @@ func NewSqliteStore(db string) (Store, error) {
 
 	return &s, nil
 }
+
+// OpenSqliteStore opens the existing sqlite database file at path without migrating it, so it must
+// be of the same version of ticktock.
+func OpenSqliteStore(path string) (Store, error) {
+	s, err := openChecked(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.fts, err = setupSearch(s.db); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &s, nil
+}
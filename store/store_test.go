@@ -3,7 +3,11 @@ package store
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,8 +27,10 @@ func assertStoreSetup(t *testing.T) Store {
 		t.Fatal(err)
 	}
 
-	if _, err := db.Exec("delete from clocking"); err != nil {
-		t.Fatalf("Error while cleanup db: %v", err)
+	for _, table := range []string{"clocking", "goals", "budgets", "rates", "billable_tags", "activity_meta", "tags", "activity_tags", "tokens", "users"} {
+		if _, err := db.Exec("delete from " + table); err != nil {
+			t.Fatalf("Error while cleanup db: %v", err)
+		}
 	}
 
 	return ss
@@ -171,3 +177,1133 @@ func TestClosedQueryByNoneUTCTime(t *testing.T) {
 		t.Fatal("Should fail on none UTC time query.")
 	}
 }
+
+func TestGoals(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	if err := ss.SetGoal(&Goal{Tag: "en", Period: PerDay, Target: 30 * time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.SetGoal(&Goal{Tag: "gym", Period: PerWeek, Target: 3 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.SetGoal(&Goal{Tag: "en", Period: PerDay, Target: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	goals, err := ss.Goals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Goal{{"en", PerDay, time.Hour}, {"gym", PerWeek, 3 * time.Hour}}
+	if len(goals) != len(want) || goals[0] != want[0] || goals[1] != want[1] {
+		t.Fatalf("Got %v, want %v", goals, want)
+	}
+
+	if err := ss.RemoveGoal("en", PerWeek); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+	if err := ss.RemoveGoal("en", PerDay); err != nil {
+		t.Fatal(err)
+	}
+	if goals, err = ss.Goals(); err != nil || len(goals) != 1 {
+		t.Fatalf("Expects 1 goal left, got: %v, %v", goals, err)
+	}
+}
+
+func TestGoalPeriodStart(t *testing.T) {
+	// 2023-03-29 is a Wednesday
+	at := time.Date(2023, time.March, 29, 15, 4, 5, 0, time.Local)
+
+	if got, want := PerDay.Start(at), time.Date(2023, time.March, 29, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("PerDay: got %v, want %v", got, want)
+	}
+	if got, want := PerWeek.Start(at), time.Date(2023, time.March, 27, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("PerWeek: got %v, want %v", got, want)
+	}
+	if got, want := PerWeek.Shift(PerWeek.Start(at), -1), time.Date(2023, time.March, 20, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("PerWeek.Shift: got %v, want %v", got, want)
+	}
+}
+
+func TestBudgetUsage(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	day := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for _, e := range []struct {
+		title string
+		start time.Time
+		dur   time.Duration
+	}{
+		{"clientA: design", day, time.Hour},
+		{"clientA: meeting", day.AddDate(0, 0, 1), 30 * time.Minute},
+		{"clientA", day.AddDate(0, 0, 2), 2 * time.Hour},
+		{"clientB: design", day, time.Hour},
+	} {
+		activity := ClosedActivity{&OpenActivity{Title: e.title, Start: e.start}, e.start.Add(e.dur)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	budget := Budget{Name: "clientA", IsTag: true, Limit: 4 * time.Hour}
+	if err := ss.SetBudget(&budget); err != nil {
+		t.Fatal(err)
+	}
+	budgets, err := ss.Budgets()
+	if err != nil || len(budgets) != 1 || budgets[0] != budget {
+		t.Fatalf("Got (%v, %v), want [%v]", budgets, err, budget)
+	}
+
+	for _, c := range []struct {
+		budget Budget
+		want   time.Duration
+	}{
+		{budget, 3*time.Hour + 30*time.Minute},
+		{Budget{Name: "clientA", IsTag: false}, 2 * time.Hour},
+		{Budget{Name: "clientA", IsTag: true, From: day.AddDate(0, 0, 1)}, 2*time.Hour + 30*time.Minute},
+		{Budget{Name: "clientA", IsTag: true, To: day.AddDate(0, 0, 1)}, time.Hour + 30*time.Minute},
+	} {
+		used, err := ss.BudgetUsage(&c.budget)
+		if err != nil || used != c.want {
+			t.Errorf("Budget %v: got (%v, %v), want %v", &c.budget, used, err, c.want)
+		}
+	}
+
+	// 3.5h of 4h used, each of the activities crossed the warning threshold
+	last, err := ss.LastClosed("clientA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings, err := BudgetWarnings(ss, last, time.Now())
+	if err != nil || len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Budget clientA (tag): 3.5h of 4h") {
+		t.Fatalf("Expects 1 warning, got: (%v, %v)", warnings, err)
+	}
+	// an ongoing activity warns while the budget is over a threshold, at start as well
+	now := day.AddDate(0, 0, 3)
+	for _, c := range []struct {
+		title   string
+		running time.Duration
+		want    string
+	}{
+		{"clientA: design", 0, "Budget clientA (tag): 3.5h of 4h"},
+		{"clientA: design", 10 * time.Minute, "Budget clientA (tag): 3.7h of 4h"},
+		{"clientA: design", 30 * time.Minute, "Budget clientA (tag) exceeded: 4h of 4h"},
+		{"clientB: design", 0, ""},
+	} {
+		ongoing := ClosedActivity{OpenActivity: &OpenActivity{Title: c.title, Start: now.Add(-c.running)}}
+		warnings, err := BudgetWarnings(ss, &ongoing, now)
+		if err != nil || c.want == "" && len(warnings) != 0 || c.want != "" && (len(warnings) != 1 || !strings.HasPrefix(warnings[0], c.want)) {
+			t.Errorf("%s running %v: expects warning %q, got: (%v, %v)", c.title, c.running, c.want, warnings, err)
+		}
+	}
+
+	if err := ss.RemoveBudget("clientA", false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+}
+
+func TestBillableDefault(t *testing.T) {
+	defaults := map[string]bool{"work": true, "chore": false}
+	for _, c := range []struct {
+		activity OpenActivity
+		want     bool
+	}{
+		{OpenActivity{Title: "work: a"}, true},
+		{OpenActivity{Title: "design", Tags: []string{"work"}}, true},
+		{OpenActivity{Title: "chore: a", Tags: []string{"work"}}, false},
+		{OpenActivity{Title: "design", Tags: []string{"chore"}}, false},
+	} {
+		if got := BillableDefault(defaults, &c.activity); got != c.want {
+			t.Errorf("%s %v: got %v, want %v", c.activity.Title, c.activity.Tags, got, c.want)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	march := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for _, r := range []Rate{
+		{Name: "clientA", IsTag: true, Amount: 10000, Currency: "USD"},
+		{Name: "clientA", IsTag: true, Amount: 12000, Currency: "USD", Since: march},
+		{Name: "clientA: meeting", Amount: 5000, Currency: "USD"},
+	} {
+		if err := ss.SetRate(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rates, err := ss.Rates()
+	if err != nil || len(rates) != 3 {
+		t.Fatalf("Expects 3 rates, got: (%v, %v)", rates, err)
+	}
+
+	for _, c := range []struct {
+		activity OpenActivity
+		want     int64
+	}{
+		{OpenActivity{Title: "clientA: design", Start: march.AddDate(0, 0, -1)}, 10000},
+		{OpenActivity{Title: "clientA: design", Start: march}, 12000},
+		{OpenActivity{Title: "clientA: meeting", Start: march}, 5000},
+		{OpenActivity{Title: "clientB: design", Start: march}, 0},
+		{OpenActivity{Title: "design", Start: march, Tags: []string{"clientA"}}, 12000},
+	} {
+		var got int64
+		if r := FindRate(rates, &c.activity); r != nil {
+			got = r.Amount
+		}
+		if got != c.want {
+			t.Errorf("%s at %v: got %d, want %d", c.activity.Title, c.activity.Start, got, c.want)
+		}
+	}
+
+	if err := ss.RemoveRate("clientA", true, march); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.RemoveRate("clientA", true, march); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for s, want := range map[string]int64{"120": 12000, "120.5": 12050, "0.05": 5} {
+		if got, err := ParseAmount(s); err != nil || got != want {
+			t.Errorf("%s: got (%d, %v), want %d", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "1.234", "-1", "a"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("%s: expects error", s)
+		}
+	}
+
+	if got := FormatAmount(12050); got != "120.50" {
+		t.Errorf("Got %s, want 120.50", got)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for _, title := range []string{"a", "b"} {
+		activity := ClosedActivity{&OpenActivity{Title: title, Start: start}, start.Add(time.Hour)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := ss.LastClosed("b")
+	if err != nil || last == nil {
+		t.Fatalf("Expects activity b, got: (%v, %v)", last, err)
+	}
+
+	last.Title = "a"
+	if err := ss.Update(last); !errors.Is(err, ErrDuplicateActivity) {
+		t.Fatalf("Expects ErrDuplicateActivity, got: %v", err)
+	}
+
+	last.Title, last.Notes, last.Billable = "c", "notes", true
+	if err := ss.Update(last); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ss.Get(last.Id)
+	if err != nil || got.Title != "c" || got.Notes != "notes" || !got.Billable || !got.End.Equal(last.End) {
+		t.Fatalf("Got (%v, %v), want %v", got, err, last)
+	}
+
+	if _, err := ss.Get(last.Id + 100); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	if err := ss.StartTitle("d", ""); err != nil {
+		t.Fatal(err)
+	}
+	got.End = time.Time{}
+	if err := ss.Update(got); !errors.Is(err, ErrOngoingExists) {
+		t.Fatalf("Expects ErrOngoingExists, got: %v", err)
+	}
+}
+
+func TestBillable(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	if err := ss.SetBillableDefault("clientA", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.StartTitle("clientA: design", ""); err != nil {
+		t.Fatal(err)
+	}
+	ongoing, err := ss.Ongoing()
+	if err != nil || ongoing == nil || !ongoing.Billable {
+		t.Fatalf("Expects billable ongoing activity, got: (%v, %v)", ongoing, err)
+	}
+	if _, err := ss.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC().Add(-time.Hour)
+	activity := ClosedActivity{&OpenActivity{Title: "clientA: lunch", Start: start}, start.Add(time.Minute)}
+	if err := ss.Add(&activity); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, billable := range []bool{true, false} {
+		activities, err := ss.Closed(start.Add(-time.Hour), time.Now().UTC(), NewTagArg([]string{"clientA"}).WithBillable(billable))
+		if err != nil || len(activities) != 1 || activities[0].Billable != billable {
+			t.Fatalf("Billable %t: got (%v, %v)", billable, activities, err)
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, meta := range []map[string]string{
+		{"ticket": "ABC-1", "location": "home"},
+		{"ticket": "ABC-2", "location": "home"},
+		nil,
+	} {
+		activity := ClosedActivity{&OpenActivity{Title: "work", Start: start.Add(time.Duration(i) * time.Hour), Meta: meta}, start.Add(time.Duration(i)*time.Hour + time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+		if activity.Id == 0 {
+			t.Fatal("Expects Id set by Add")
+		}
+	}
+
+	query := func(filter *QueryArg) []ClosedActivity {
+		t.Helper()
+		activities, err := ss.Closed(start, start.Add(24*time.Hour), filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return activities
+	}
+
+	if activities := query(nil); len(activities) != 3 || activities[0].Meta["ticket"] != "ABC-1" || activities[2].Meta != nil {
+		t.Fatalf("Got %v", activities)
+	}
+
+	activities := query(NewTitleArg([]string{"work"}).WithMeta("location", "home").WithMeta("ticket", "ABC-2"))
+	if len(activities) != 1 || activities[0].Meta["ticket"] != "ABC-2" {
+		t.Fatalf("Expects activity of ABC-2, got %v", activities)
+	}
+
+	activity := activities[0]
+	activity.Meta = map[string]string{"ticket": "ABC-3"}
+	if err := ss.Update(&activity); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ss.Get(activity.Id); err != nil || got.MetaString() != "ticket=ABC-3" {
+		t.Fatalf("Got (%v, %v), want ticket=ABC-3", got, err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, e := range []struct {
+		title string
+		tags  []string
+	}{
+		{"clientA: design", nil},
+		{"clientA: standup", []string{"meeting"}},
+		{"meeting", nil},
+	} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		activity := ClosedActivity{&OpenActivity{Title: e.title, Start: s, Tags: e.tags}, s.Add(time.Duration(i+1) * time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titles := func(tags []string, match TagMatch) []string {
+		t.Helper()
+		activities, err := ss.Closed(start, start.Add(24*time.Hour), NewTagMatchArg(tags, match))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		titles := make([]string, 0)
+		for _, activity := range activities {
+			titles = append(titles, activity.Title)
+		}
+		return titles
+	}
+
+	for _, c := range []struct {
+		tags  []string
+		match TagMatch
+		want  string
+	}{
+		{[]string{"meeting"}, AnyTag, "clientA: standup,meeting"},
+		{[]string{"clientA", "meeting"}, AnyTag, "clientA: design,clientA: standup,meeting"},
+		{[]string{"clientA", "meeting"}, AllTags, "clientA: standup"},
+		{[]string{"meeting"}, NoneTags, "clientA: design"},
+	} {
+		if got := strings.Join(titles(c.tags, c.match), ","); got != c.want {
+			t.Errorf("%v (%d): got %s, want %s", c.tags, c.match, got, c.want)
+		}
+	}
+
+	usages, err := ss.Tags()
+	if err != nil || len(usages) != 2 {
+		t.Fatalf("Expects 2 tags, got: (%v, %v)", usages, err)
+	}
+	if usages[0] != (TagUsage{"meeting", 2, 5 * time.Minute}) || usages[1] != (TagUsage{"clientA", 2, 3 * time.Minute}) {
+		t.Fatalf("Got %v", usages)
+	}
+}
+
+func TestRetitleTags(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, e := range []struct {
+		title, retitle string
+		tags           []string
+		want           string
+	}{
+		{"work: a", "home: a", []string{"x"}, "home,x"},
+		// given tags are kept even if they are the derived one
+		{"work: b", "home: b", []string{"work"}, "home,work"},
+	} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		activity := ClosedActivity{&OpenActivity{Title: e.title, Start: s, Tags: e.tags}, s.Add(time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ss.Get(activity.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got.Tags, ",") != strings.Join(e.tags, ",") {
+			t.Fatalf("%s: expects given tags %v, got %v", e.title, e.tags, got.Tags)
+		}
+		got.Title = e.retitle
+		if err := ss.Update(got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err = ss.Get(activity.Id); err != nil {
+			t.Fatal(err)
+		}
+		if tags := strings.Join(got.AllTags(), ","); tags != e.want {
+			t.Errorf("%s: got tags %s, want %s", e.retitle, tags, e.want)
+		}
+	}
+
+	activities, err := ss.Closed(start, start.Add(24*time.Hour), NewTagArg([]string{"work"}))
+	if err != nil || len(activities) != 1 || activities[0].Title != "home: b" {
+		t.Fatalf("Expects only home: b tagged work, got (%v, %v)", activities, err)
+	}
+}
+
+func TestMigrateTagsFromTitles(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "db")
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// db created by versions without migrations
+	if _, err := db.Exec(migrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO clocking (title, start, end, notes)
+		VALUES ('en: grammar', '2023-03-01T09:00:00Z', '2023-03-01T10:00:00Z', ''),
+		('gym', '2023-03-01T11:00:00Z', '2023-03-01T12:00:00Z', '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := NewSqliteStore(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for _, tag := range []string{"en", "gym"} {
+		activities, err := ss.Closed(start, start.Add(24*time.Hour), NewTagArg([]string{tag}))
+		if err != nil || len(activities) != 1 || !activities[0].HasTag(tag) || len(activities[0].Tags) != 0 {
+			t.Errorf("Tag %s: got (%v, %v)", tag, activities, err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, e := range [][2]string{
+		{"en: grammar", "past perfect tense"},
+		{"en: vocabulary", "words about weather"},
+		{"clientA: design", "discussed the Weather widget with Bob"},
+	} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		activity := ClosedActivity{&OpenActivity{Title: e[0], Start: s, Notes: e[1]}, s.Add(30 * time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(query string, start, end time.Time, filter *QueryArg) []SearchResult {
+		t.Helper()
+		results, err := ss.Search(query, start, end, filter, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	titles := func(results []SearchResult) string {
+		titles := make([]string, 0, len(results))
+		for _, r := range results {
+			titles = append(titles, r.Title)
+		}
+		return strings.Join(titles, ",")
+	}
+
+	results := search("weather", time.Time{}, time.Time{}, nil)
+	if got := titles(results); got != "clientA: design,en: vocabulary" {
+		t.Fatalf("Got %s", got)
+	}
+	if got := results[1].Highlight("[", "]"); got != "words about [weather]" {
+		t.Errorf("Got snippet %q", got)
+	}
+
+	for _, c := range []struct {
+		query  string
+		start  time.Time
+		filter *QueryArg
+		want   string
+	}{
+		{"weather bob", time.Time{}, nil, "clientA: design"},
+		{"grammar", time.Time{}, nil, "en: grammar"},
+		{"weather", start.Add(2 * time.Hour), nil, "clientA: design"},
+		{"weather", time.Time{}, NewTagArg([]string{"en"}), "en: vocabulary"},
+		{"100%", time.Time{}, nil, ""},
+	} {
+		if got := titles(search(c.query, c.start, time.Time{}, c.filter)); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.query, got, c.want)
+		}
+	}
+
+	// index follows updates of notes
+	activity := results[1].ClosedActivity
+	activity.Notes = "words about seasons"
+	if err := ss.Update(&activity); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(search("weather", time.Time{}, time.Time{}, nil)); got != "clientA: design" {
+		t.Errorf("Got %s after update", got)
+	}
+	if got := titles(search("seasons", time.Time{}, time.Time{}, nil)); got != "en: vocabulary" {
+		t.Errorf("Got %s after update", got)
+	}
+
+	if _, err := ss.Search("  ", time.Time{}, time.Time{}, nil, 0); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("Expects ErrEmptyQuery, got %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		s := start.Add(time.Duration(i) * time.Hour)
+		activity := ClosedActivity{&OpenActivity{Title: fmt.Sprintf("a%d", i), Start: s}, s.Add(30 * time.Minute)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// same start as a4
+	activity := ClosedActivity{&OpenActivity{Title: "b4", Start: start.Add(4 * time.Hour)}, start.Add(5 * time.Hour)}
+	if err := ss.Add(&activity); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := func(before, after Cursor, filter *QueryArg, limit int) ([]ClosedActivity, string) {
+		t.Helper()
+		activities, err := ss.History(before, after, filter, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		titles := make([]string, 0, len(activities))
+		for _, activity := range activities {
+			titles = append(titles, activity.Title)
+		}
+		return activities, strings.Join(titles, ",")
+	}
+
+	page, got := titles(Cursor{}, Cursor{}, nil, 2)
+	if got != "b4,a4" {
+		t.Fatalf("First page: got %s", got)
+	}
+	page, got = titles(CursorOf(page[1].OpenActivity), Cursor{}, nil, 2)
+	if got != "a3,a2" {
+		t.Fatalf("Second page: got %s", got)
+	}
+	if _, got = titles(Cursor{}, CursorOf(page[0].OpenActivity), nil, 2); got != "b4,a4" {
+		t.Fatalf("Previous page: got %s", got)
+	}
+	if _, got = titles(Cursor{}, CursorOf(page[1].OpenActivity), nil, 0); got != "b4,a4,a3" {
+		t.Fatalf("After: got %s", got)
+	}
+	if _, got = titles(Cursor{Start: start.Add(3 * time.Hour)}, Cursor{Start: start.Add(time.Hour)}, nil, 0); got != "a2,a1" {
+		t.Fatalf("Between: got %s", got)
+	}
+	if _, got = titles(Cursor{}, Cursor{}, NewTitleArg([]string{"a1", "b4"}), 0); got != "b4,a1" {
+		t.Fatalf("Filtered: got %s", got)
+	}
+}
+
+func TestTitles(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	// a Friday
+	at := time.Date(2023, time.March, 10, 9, 0, 0, 0, time.Local)
+	for _, e := range []struct {
+		title string
+		start time.Time
+	}{
+		{"en: grammar", at.AddDate(0, 0, -14)},
+		{"en: grammar", at.AddDate(0, 0, -7)},
+		{"gym", at.AddDate(0, 0, -4).Add(9 * time.Hour)},
+		{"gym", at.AddDate(0, 0, -3).Add(9 * time.Hour)},
+		{"gym", at.AddDate(0, 0, -2).Add(9 * time.Hour)},
+		{"odd", at.AddDate(0, 0, -1).Add(11 * time.Hour)},
+	} {
+		activity := ClosedActivity{&OpenActivity{Title: e.title, Start: e.start.UTC()}, e.start.Add(time.Hour).UTC()}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		rank    TitleRank
+		pattern string
+		limit   int
+		want    string
+	}{
+		{RankRecent, "", 0, "odd,gym,en: grammar"},
+		{RankFrecency, "", 0, "en: grammar,gym,odd"},
+		{RankFrecency, "", 2, "en: grammar,gym"},
+		{RankFrecency, "G", 0, "gym,en: grammar"},
+		{RankFrecency, "egr", 0, "en: grammar"},
+		{RankRecent, "x", 0, ""},
+	} {
+		titles, err := ss.Titles(c.rank, c.pattern, at, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(titles, ","); got != c.want {
+			t.Errorf("%s %q: got %s, want %s", c.rank, c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	activity := ClosedActivity{&OpenActivity{Title: "a", Start: start, Meta: map[string]string{"k": "v"}}, start.Add(time.Hour)}
+	if err := ss.Add(&activity); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.Delete(activity.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Get(activity.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+	if err := ss.Delete(activity.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+}
+
+func TestWithEvents(t *testing.T) {
+	events := make([]string, 0)
+	ss := WithEvents(assertStoreSetup(t), nil, func(e *Event) {
+		end := "open"
+		if !e.Activity.End.IsZero() {
+			end = "closed"
+		}
+		events = append(events, fmt.Sprintf("%s %s %s", e.Type, e.Activity.Title, end))
+	})
+
+	if err := ss.StartTitle("a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StartTitle("b", ""); !errors.Is(err, ErrOngoingExists) {
+		t.Fatalf("Expects ErrOngoingExists, got: %v", err)
+	}
+	if _, err := ss.CloseActivity("done"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	activity := ClosedActivity{&OpenActivity{Title: "c", Start: start}, start.Add(time.Hour)}
+	if err := ss.Add(&activity); err != nil {
+		t.Fatal(err)
+	}
+	activity.Title = "d"
+	if err := ss.Update(&activity); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Delete(activity.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.StartTitle("e", ""); err != nil {
+		t.Fatal(err)
+	}
+	ongoing, err := ss.Ongoing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Update(&ClosedActivity{ongoing, ongoing.Start.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "start a open|close a closed|add c closed|edit d closed|delete d closed|start e open|close e closed"
+	if got := strings.Join(events, "|"); got != want {
+		t.Fatalf("Got events %q, want %q", got, want)
+	}
+}
+
+// failingGet is a Store which fails to get activities.
+type failingGet struct {
+	Store
+}
+
+func (s failingGet) Get(int64) (*ClosedActivity, error) {
+	return nil, errors.New("get failed")
+}
+
+func TestWithEventsReadError(t *testing.T) {
+	var events, errs []string
+	ss := WithEvents(failingGet{assertStoreSetup(t)}, func(err error) {
+		errs = append(errs, err.Error())
+	}, func(e *Event) {
+		events = append(events, string(e.Type))
+	})
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	activity := ClosedActivity{&OpenActivity{Title: "a", Start: start}, start.Add(time.Hour)}
+	if err := ss.Add(&activity); err != nil || activity.Id == 0 {
+		t.Fatalf("Expects the activity added, got: %v", err)
+	}
+	if err := ss.StartTitle("b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if title, err := ss.CloseActivity(""); err != nil || title != "b" {
+		t.Fatalf("Got (%q, %v)", title, err)
+	}
+
+	if got, want := strings.Join(events, "|"), "start"; got != want {
+		t.Fatalf("Got events %q, want %q", got, want)
+	}
+	want := "skipped add event: get failed|skipped close event: get failed"
+	if got := strings.Join(errs, "|"); got != want {
+		t.Fatalf("Got errors %q, want %q", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	secret, err := NewTokenSecret()
+	if err != nil || !strings.HasPrefix(secret, "tt_") {
+		t.Fatalf("Got secret (%s, %v)", secret, err)
+	}
+	if other, _ := NewTokenSecret(); other == secret || HashToken(other) == HashToken(secret) {
+		t.Fatal("Expects different secrets and hashes")
+	}
+
+	created := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	token := Token{Name: "phone", Scope: ScopeRead, Created: created}
+	if err := ss.AddToken(&token, HashToken(secret)); err != nil || token.Id == 0 {
+		t.Fatalf("Got (%v, %v)", token, err)
+	}
+	if err := ss.AddToken(&Token{Name: "phone", Scope: ScopeWrite, Created: created}, "x"); !errors.Is(err, ErrDuplicateToken) {
+		t.Fatalf("Expects ErrDuplicateToken, got: %v", err)
+	}
+	if err := ss.AddToken(&Token{Name: "ci", Scope: ScopeWrite, Created: created}, "y"); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ss.FindToken(HashToken(secret))
+	if err != nil || *found != token {
+		t.Fatalf("Got (%v, %v), want %v", found, err, token)
+	}
+	if _, err := ss.FindToken(secret); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	tokens, err := ss.Tokens()
+	if err != nil || len(tokens) != 2 || tokens[0].Name != "ci" || tokens[1].Name != "phone" {
+		t.Fatalf("Got tokens (%v, %v)", tokens, err)
+	}
+
+	if err := ss.RevokeToken("phone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.RevokeToken("phone"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+	if _, err := ss.FindToken(HashToken(secret)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	if !ScopeWrite.Allows(ScopeRead) || ScopeRead.Allows(ScopeWrite) || !ScopeRead.Allows(ScopeRead) {
+		t.Fatal("Wrong scope allows")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ss := assertStoreSetup(t)
+
+	alice := User{Name: "alice", Admin: true}
+	if err := ss.AddUser(&alice, "secret"); err != nil || alice.Id == 0 || alice.Created.IsZero() {
+		t.Fatalf("Got (%+v, %v)", alice, err)
+	}
+	if err := ss.AddUser(&User{Name: "alice"}, "x"); !errors.Is(err, ErrDuplicateUser) {
+		t.Fatalf("Expects ErrDuplicateUser, got: %v", err)
+	}
+	bob := User{Name: "bob"}
+	if err := ss.AddUser(&bob, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := ss.CheckPassword("alice", "secret"); err != nil || *user != alice {
+		t.Fatalf("Got (%+v, %v), want %+v", user, err, alice)
+	}
+	for _, login := range [][2]string{{"alice", "hunter2"}, {"carol", "secret"}, {"alice", ""}} {
+		if _, err := ss.CheckPassword(login[0], login[1]); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expects ErrNotFound for %v, got: %v", login, err)
+		}
+	}
+	if err := ss.SetPassword("bob", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.CheckPassword("bob", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.SetPassword("carol", "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+
+	// activities and tokens are of each user, the ongoing activity too
+	as, bs := ss.ForUser(alice.Id), ss.ForUser(bob.Id)
+	for _, s := range []Store{ss, as, bs} {
+		if err := s.StartTitle("work: a", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bs.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+	if ongoing, err := as.Ongoing(); err != nil || ongoing == nil {
+		t.Fatalf("Expects ongoing activity of alice, got: (%+v, %v)", ongoing, err)
+	}
+	if ongoing, err := bs.Ongoing(); err != nil || ongoing != nil {
+		t.Fatalf("Expects no ongoing activity of bob, got: (%+v, %v)", ongoing, err)
+	}
+	closed, err := bs.Closed(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+	if err != nil || len(closed) != 1 {
+		t.Fatalf("Expects closed activity of bob, got: (%+v, %v)", closed, err)
+	}
+	if _, err := as.Get(closed[0].Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound for activity of another user, got: %v", err)
+	}
+
+	token := Token{Name: "phone", Scope: ScopeRead, Created: time.Now().UTC().Truncate(time.Second)}
+	if err := as.AddToken(&token, "a"); err != nil || token.User != alice.Id {
+		t.Fatalf("Got (%+v, %v)", token, err)
+	}
+	if err := bs.AddToken(&Token{Name: "phone", Scope: ScopeRead, Created: token.Created}, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := ss.FindToken("a"); err != nil || *found != token {
+		t.Fatalf("Got (%+v, %v), want %+v", found, err, token)
+	}
+	if tokens, err := ss.Tokens(); err != nil || len(tokens) != 0 {
+		t.Fatalf("Expects no tokens of the owner, got: (%+v, %v)", tokens, err)
+	}
+
+	if err := ss.RemoveUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.RemoveUser("bob"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects ErrNotFound, got: %v", err)
+	}
+	if _, err := ss.FindToken("b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expects token of bob removed, got: %v", err)
+	}
+	if _, err := bs.Get(closed[0].Id); err != nil {
+		t.Fatalf("Expects activity of bob kept, got: %v", err)
+	}
+	users, err := ss.Users()
+	if err != nil || len(users) != 1 || users[0] != alice {
+		t.Fatalf("Got users (%+v, %v)", users, err)
+	}
+	if user, err := ss.GetUser(alice.Id); err != nil || *user != alice {
+		t.Fatalf("Got (%+v, %v)", user, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	here, err := NewSqliteStore(filepath.Join(dir, "here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer here.Close()
+	otherDb := filepath.Join(dir, "other")
+	other, err := NewSqliteStore(otherDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	add := func(ss Store, title string, offset time.Duration, notes string) *ClosedActivity {
+		t.Helper()
+		activity := ClosedActivity{&OpenActivity{Title: title, Start: start.Add(offset), Notes: notes}, start.Add(offset + time.Hour)}
+		if err := ss.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+		return &activity
+	}
+	a := add(here, "work: a", 0, "x")
+	add(other, "work: a", 0, "x")
+	b := add(other, "gym", 30*time.Minute, "")
+	if err := other.StartTitle("ongoing", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := here.Merge(otherDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || report.Added[0].Title != "gym" || report.Unchanged != 1 || !report.LastSynced.IsZero() {
+		t.Fatalf("Expects gym added and work: a deduplicated, got: %+v", report)
+	}
+	if len(report.Overlaps) != 1 || report.Overlaps[0].Existing.Id != a.Id {
+		t.Fatalf("Expects gym overlaps with work: a, got: %+v", report.Overlaps)
+	}
+	if ongoing, err := here.Ongoing(); err != nil || ongoing != nil {
+		t.Fatalf("Expects ongoing activity not merged, got: %v, %v", ongoing, err)
+	}
+
+	report, err = here.Merge(otherDb)
+	if err != nil || len(report.Added)+len(report.Updated)+report.Unchanged != 0 || report.LastSynced.IsZero() {
+		t.Fatalf("Expects nothing merged again, got: %+v, %v", report, err)
+	}
+	hereDb := filepath.Join(dir, "here")
+	if report, err := other.Merge(hereDb); err != nil || len(report.Added)+len(report.Updated) != 0 || report.Unchanged != 2 {
+		t.Fatalf("Expects nothing merged back, got: %+v, %v", report, err)
+	}
+
+	// edited there only
+	b.Notes, b.End = "legs", b.End.Add(time.Hour)
+	if err := other.Update(b); err != nil {
+		t.Fatal(err)
+	}
+	// work: a created on both is matched by origin even if its title is changed
+	a.Title = "work: b"
+	if err := here.Update(a); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := other.Merge(hereDb); err != nil || len(report.Updated) != 1 || report.Updated[0].Title != "work: b" {
+		t.Fatalf("Expects work: a renamed, got: %+v, %v", report, err)
+	}
+	report, err = here.Merge(otherDb)
+	if err != nil || len(report.Updated) != 1 || report.Updated[0].Notes != "legs" || len(report.Conflicts) != 0 {
+		t.Fatalf("Expects gym updated, got: %+v, %v", report, err)
+	}
+	if closed, err := here.Closed(start, start.Add(24*time.Hour), nil); err != nil || len(closed) != 2 {
+		t.Fatalf("Expects 2 activities, got: %+v, %v", closed, err)
+	}
+
+	// edited on both
+	gym := report.Updated[0]
+	gym.Notes = "here"
+	if err := here.Update(&gym); err != nil {
+		t.Fatal(err)
+	}
+	b.Notes = "there"
+	if err := other.Update(b); err != nil {
+		t.Fatal(err)
+	}
+	report, err = here.Merge(otherDb)
+	if err != nil || len(report.Conflicts) != 1 || report.Conflicts[0].Here.Notes != "here" || report.Conflicts[0].Other.Notes != "there" {
+		t.Fatalf("Expects conflict, got: %+v, %v", report, err)
+	}
+	if kept, err := here.Get(gym.Id); err != nil || kept.Notes != "here" {
+		t.Fatalf("Expects the one here kept, got: %+v, %v", kept, err)
+	}
+
+	if _, err := here.Merge(hereDb); err == nil {
+		t.Fatal("Expects error merging itself")
+	}
+	if _, err := here.Merge(filepath.Join(dir, "none")); err == nil {
+		t.Fatal("Expects error merging a db which does not exist")
+	}
+}
+
+func TestMergeCopy(t *testing.T) {
+	dir := t.TempDir()
+	hereDb, copyDb := filepath.Join(dir, "here"), filepath.Join(dir, "copy")
+	here, err := NewSqliteStore(hereDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer here.Close()
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	a := ClosedActivity{&OpenActivity{Title: "work: a", Start: start}, start.Add(time.Hour)}
+	if err := here.Add(&a); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(hereDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(copyDb, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := NewSqliteStore(copyDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+
+	if _, err := copied.Merge(hereDb); err == nil {
+		t.Fatal("Expects error merging the original")
+	}
+	if err := copied.NewDeviceId(); err != nil {
+		t.Fatal(err)
+	}
+	b := ClosedActivity{&OpenActivity{Title: "gym", Start: start.Add(2 * time.Hour)}, start.Add(3 * time.Hour)}
+	if err := copied.Add(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := here.Merge(copyDb)
+	if err != nil || len(report.Added) != 1 || report.Added[0].Title != "gym" || report.Unchanged != 1 {
+		t.Fatalf("Expects gym added and work: a unchanged, got: %+v, %v", report, err)
+	}
+	if report, err := copied.Merge(hereDb); err != nil || len(report.Added)+len(report.Updated) != 0 || report.Unchanged != 2 {
+		t.Fatalf("Expects nothing merged back, got: %+v, %v", report, err)
+	}
+
+	// a is matched by its origin, even if retitled in the copy
+	a.Title = "work: b"
+	if err := copied.Update(&a); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := here.Merge(copyDb); err != nil || len(report.Updated) != 1 || report.Updated[0].Id != a.Id || report.Updated[0].Title != "work: b" {
+		t.Fatalf("Expects work: a updated, got: %+v, %v", report, err)
+	}
+}
+
+func TestMergeReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	here, err := NewSqliteStore(filepath.Join(dir, "here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer here.Close()
+
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	otherDb := filepath.Join(dir, "other db")
+	other, err := NewSqliteStore(otherDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, title := range []string{"a", "b"} {
+		activity := ClosedActivity{&OpenActivity{Title: title, Start: start.Add(time.Duration(i) * time.Hour)}, start.Add(time.Duration(i+1) * time.Hour)}
+		if err := other.Add(&activity); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b fails to be merged, a is not merged either
+	if _, err := here.(*sqlite).db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON clocking WHEN new.title = 'b'
+		BEGIN SELECT RAISE(ABORT, 'failed'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := here.Merge(otherDb); err == nil {
+		t.Fatal("Expects error of the failed insert")
+	}
+	if closed, err := here.Closed(start, start.Add(24*time.Hour), nil); err != nil || len(closed) != 0 {
+		t.Fatalf("Expects nothing merged, got: %+v, %v", closed, err)
+	}
+
+	// an older db is not migrated
+	if _, err := other.(*sqlite).db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, err := here.Merge(otherDb); err == nil || !strings.Contains(err.Error(), "schema version 1") {
+		t.Fatalf("Expects error of the schema version, got: %v", err)
+	}
+	db, err := sql.Open("sqlite3", otherDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != 1 {
+		t.Fatalf("Expects version 1 kept, got: %d, %v", version, err)
+	}
+}
+
+func TestOpenSqliteStore(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "db")
+	if _, err := OpenSqliteStore(db); err == nil {
+		t.Fatal("Expects error of the missing db file")
+	}
+	if _, err := os.Stat(db); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expects the db file not created, got: %v", err)
+	}
+
+	created, err := NewSqliteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	ss, err := OpenSqliteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	if err := ss.Add(&ClosedActivity{&OpenActivity{Title: "a", Start: start}, start.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an older db is not migrated
+	if _, err := ss.(*sqlite).db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	ss.Close()
+	if _, err := OpenSqliteStore(db); err == nil || !strings.Contains(err.Error(), "open it with this version of ticktock first") {
+		t.Fatalf("Expects error of the schema version, got: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package store
+
+import "time"
+
+// timedStore calls observe with the duration of each operation.
+type timedStore struct {
+	Store
+	observe func(op string, d time.Duration)
+}
+
+// WithTiming returns a Store which calls observe with the name and duration of each operation made
+// through it, the name is of the method, such as "Start". Stores of users returned by ForUser are
+// timed as well.
+func WithTiming(ss Store, observe func(op string, d time.Duration)) Store {
+	return &timedStore{ss, observe}
+}
+
+func (s *timedStore) done(op string, start time.Time) {
+	s.observe(op, time.Since(start))
+}
+
+func (s *timedStore) ForUser(id int64) Store {
+	return &timedStore{s.Store.ForUser(id), s.observe}
+}
+
+func (s *timedStore) Start(activity *OpenActivity) error {
+	defer s.done("Start", time.Now())
+	return s.Store.Start(activity)
+}
+
+func (s *timedStore) StartTitle(title, notes string) error {
+	defer s.done("StartTitle", time.Now())
+	return s.Store.StartTitle(title, notes)
+}
+
+func (s *timedStore) CloseActivity(notes string) (string, error) {
+	defer s.done("CloseActivity", time.Now())
+	return s.Store.CloseActivity(notes)
+}
+
+func (s *timedStore) RecentTitles(limit uint8) ([]string, error) {
+	defer s.done("RecentTitles", time.Now())
+	return s.Store.RecentTitles(limit)
+}
+
+func (s *timedStore) Titles(rank TitleRank, pattern string, at time.Time, limit int) ([]string, error) {
+	defer s.done("Titles", time.Now())
+	return s.Store.Titles(rank, pattern, at, limit)
+}
+
+func (s *timedStore) Ongoing() (*OpenActivity, error) {
+	defer s.done("Ongoing", time.Now())
+	return s.Store.Ongoing()
+}
+
+func (s *timedStore) LastClosed(title string) (*ClosedActivity, error) {
+	defer s.done("LastClosed", time.Now())
+	return s.Store.LastClosed(title)
+}
+
+func (s *timedStore) Closed(queryStart, queryEnd time.Time, filter *QueryArg) ([]ClosedActivity, error) {
+	defer s.done("Closed", time.Now())
+	return s.Store.Closed(queryStart, queryEnd, filter)
+}
+
+func (s *timedStore) Search(query string, queryStart, queryEnd time.Time, filter *QueryArg, limit int) ([]SearchResult, error) {
+	defer s.done("Search", time.Now())
+	return s.Store.Search(query, queryStart, queryEnd, filter, limit)
+}
+
+func (s *timedStore) History(before, after Cursor, filter *QueryArg, limit int) ([]ClosedActivity, error) {
+	defer s.done("History", time.Now())
+	return s.Store.History(before, after, filter, limit)
+}
+
+func (s *timedStore) Add(activity *ClosedActivity) error {
+	defer s.done("Add", time.Now())
+	return s.Store.Add(activity)
+}
+
+func (s *timedStore) Get(id int64) (*ClosedActivity, error) {
+	defer s.done("Get", time.Now())
+	return s.Store.Get(id)
+}
+
+func (s *timedStore) Update(activity *ClosedActivity) error {
+	defer s.done("Update", time.Now())
+	return s.Store.Update(activity)
+}
+
+func (s *timedStore) Delete(id int64) error {
+	defer s.done("Delete", time.Now())
+	return s.Store.Delete(id)
+}
+
+func (s *timedStore) Tags() ([]TagUsage, error) {
+	defer s.done("Tags", time.Now())
+	return s.Store.Tags()
+}
+
+func (s *timedStore) SetBillableDefault(tag string, billable bool) error {
+	defer s.done("SetBillableDefault", time.Now())
+	return s.Store.SetBillableDefault(tag, billable)
+}
+
+func (s *timedStore) BillableDefaults() (map[string]bool, error) {
+	defer s.done("BillableDefaults", time.Now())
+	return s.Store.BillableDefaults()
+}
+
+func (s *timedStore) SetGoal(goal *Goal) error {
+	defer s.done("SetGoal", time.Now())
+	return s.Store.SetGoal(goal)
+}
+
+func (s *timedStore) Goals() ([]Goal, error) {
+	defer s.done("Goals", time.Now())
+	return s.Store.Goals()
+}
+
+func (s *timedStore) RemoveGoal(tag string, period GoalPeriod) error {
+	defer s.done("RemoveGoal", time.Now())
+	return s.Store.RemoveGoal(tag, period)
+}
+
+func (s *timedStore) SetBudget(budget *Budget) error {
+	defer s.done("SetBudget", time.Now())
+	return s.Store.SetBudget(budget)
+}
+
+func (s *timedStore) Budgets() ([]Budget, error) {
+	defer s.done("Budgets", time.Now())
+	return s.Store.Budgets()
+}
+
+func (s *timedStore) RemoveBudget(name string, isTag bool) error {
+	defer s.done("RemoveBudget", time.Now())
+	return s.Store.RemoveBudget(name, isTag)
+}
+
+func (s *timedStore) BudgetUsage(budget *Budget) (time.Duration, error) {
+	defer s.done("BudgetUsage", time.Now())
+	return s.Store.BudgetUsage(budget)
+}
+
+func (s *timedStore) SetRate(rate *Rate) error {
+	defer s.done("SetRate", time.Now())
+	return s.Store.SetRate(rate)
+}
+
+func (s *timedStore) Rates() ([]Rate, error) {
+	defer s.done("Rates", time.Now())
+	return s.Store.Rates()
+}
+
+func (s *timedStore) RemoveRate(name string, isTag bool, since time.Time) error {
+	defer s.done("RemoveRate", time.Now())
+	return s.Store.RemoveRate(name, isTag, since)
+}
+
+func (s *timedStore) AddToken(token *Token, hash string) error {
+	defer s.done("AddToken", time.Now())
+	return s.Store.AddToken(token, hash)
+}
+
+func (s *timedStore) Tokens() ([]Token, error) {
+	defer s.done("Tokens", time.Now())
+	return s.Store.Tokens()
+}
+
+func (s *timedStore) FindToken(hash string) (*Token, error) {
+	defer s.done("FindToken", time.Now())
+	return s.Store.FindToken(hash)
+}
+
+func (s *timedStore) RevokeToken(name string) error {
+	defer s.done("RevokeToken", time.Now())
+	return s.Store.RevokeToken(name)
+}
+
+func (s *timedStore) AddUser(user *User, password string) error {
+	defer s.done("AddUser", time.Now())
+	return s.Store.AddUser(user, password)
+}
+
+func (s *timedStore) Users() ([]User, error) {
+	defer s.done("Users", time.Now())
+	return s.Store.Users()
+}
+
+func (s *timedStore) GetUser(id int64) (*User, error) {
+	defer s.done("GetUser", time.Now())
+	return s.Store.GetUser(id)
+}
+
+func (s *timedStore) CheckPassword(name, password string) (*User, error) {
+	defer s.done("CheckPassword", time.Now())
+	return s.Store.CheckPassword(name, password)
+}
+
+func (s *timedStore) SetPassword(name, password string) error {
+	defer s.done("SetPassword", time.Now())
+	return s.Store.SetPassword(name, password)
+}
+
+func (s *timedStore) RemoveUser(name string) error {
+	defer s.done("RemoveUser", time.Now())
+	return s.Store.RemoveUser(name)
+}
+
+func (s *timedStore) Merge(other string) (*MergeReport, error) {
+	defer s.done("Merge", time.Now())
+	return s.Store.Merge(other)
+}
+
+func (s *timedStore) NewDeviceId() error {
+	defer s.done("NewDeviceId", time.Now())
+	return s.Store.NewDeviceId()
+}
+
+func (s *timedStore) Ping() error {
+	defer s.done("Ping", time.Now())
+	return s.Store.Ping()
+}
+
+func (s *timedStore) Close() error {
+	defer s.done("Close", time.Now())
+	return s.Store.Close()
+}
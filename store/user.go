@@ -0,0 +1,216 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is an account of the server in multi-user mode, who owns activities and tokens.
+type User struct {
+	Id      int64
+	Name    string
+	Admin   bool
+	Created time.Time
+}
+
+// passwordIterations is the number of PBKDF2 iterations of new password hashes.
+const passwordIterations = 210000
+
+// hashPassword returns the PBKDF2-HMAC-SHA256 hash of the password with a random salt, as
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := pbkdf2([]byte(password), salt, passwordIterations, sha256.Size)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether the password matches the hash of hashPassword.
+func checkPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	key := pbkdf2([]byte(password), salt, iterations, len(want))
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// pbkdf2 derives a key of keyLen bytes from the password, see RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLen)
+	u := make([]byte, prf.Size())
+	t := make([]byte, prf.Size())
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// userColumns are columns scanned by scanUser
+const userColumns = `id, name, admin, created`
+
+func scanUser(row scanner) (*User, error) {
+	var user User
+	var created string
+	if err := row.Scan(&user.Id, &user.Name, &user.Admin, &created); err != nil {
+		return nil, err
+	}
+
+	var err error
+	user.Created, err = time.Parse(time.RFC3339, created)
+	return &user, err
+}
+
+func (s *sqlite) AddUser(user *User, password string) error {
+	var exists bool
+	if err := s.conn().QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE name = ?)`, user.Name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("user %s: %w", user.Name, ErrDuplicateUser)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if user.Created.IsZero() {
+		user.Created = time.Now().UTC().Truncate(time.Second)
+	}
+	r, err := s.conn().Exec(`INSERT INTO users (name, password, admin, created) VALUES(?,?,?,?)`,
+		user.Name, hash, user.Admin, user.Created.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	user.Id, err = r.LastInsertId()
+	return err
+}
+
+func (s *sqlite) Users() ([]User, error) {
+	rows, err := s.conn().Query(`SELECT ` + userColumns + ` FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *sqlite) GetUser(id int64) (*User, error) {
+	user, err := scanUser(s.conn().QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+func (s *sqlite) CheckPassword(name, password string) (*User, error) {
+	var hash string
+	err := s.conn().QueryRow(`SELECT password FROM users WHERE name = ?`, name).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// spend the same time as for a wrong password, so that unknown users can't be told
+		pbkdf2([]byte(password), nil, passwordIterations, sha256.Size)
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !checkPassword(password, hash) {
+		return nil, ErrNotFound
+	}
+
+	return scanUser(s.conn().QueryRow(`SELECT `+userColumns+` FROM users WHERE name = ?`, name))
+}
+
+func (s *sqlite) SetPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	r, err := s.conn().Exec(`UPDATE users SET password = ? WHERE name = ?`, hash, name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlite) RemoveUser(name string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
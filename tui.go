@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"github.com/cranej/ticktock/view"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// tuiRefresh is how often the tui reloads data, to show changes made elsewhere.
+const tuiRefresh = 5 * time.Second
+
+// tuiRecent is the number of recent titles which can be restarted with one key.
+const tuiRecent = 9
+
+type tuiMode int
+
+const (
+	tuiNormal tuiMode = iota
+	tuiPick
+	tuiNotes
+)
+
+// editor is a minimal multi-line text editor.
+type editor struct {
+	text   []rune
+	cursor int
+}
+
+// lineStart returns the index of the first rune of the line containing index i.
+func (e *editor) lineStart(i int) int {
+	for i > 0 && e.text[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+// lineEnd returns the index of the line break (or end of text) of the line containing index i.
+func (e *editor) lineEnd(i int) int {
+	for i < len(e.text) && e.text[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// position returns line and column of the cursor, both start from 0.
+func (e *editor) position() (line, col int) {
+	for _, r := range e.text[:e.cursor] {
+		if r == '\n' {
+			line++
+		}
+	}
+	return line, e.cursor - e.lineStart(e.cursor)
+}
+
+// moveLine moves the cursor to the same column (or the line end) of the previous or next line.
+func (e *editor) moveLine(up bool) {
+	start := e.lineStart(e.cursor)
+	col := e.cursor - start
+	var target int
+	if up {
+		if start == 0 {
+			return
+		}
+		target = e.lineStart(start - 1)
+	} else {
+		end := e.lineEnd(e.cursor)
+		if end == len(e.text) {
+			return
+		}
+		target = end + 1
+	}
+
+	if end := e.lineEnd(target); target+col > end {
+		e.cursor = end
+	} else {
+		e.cursor = target + col
+	}
+}
+
+func (e *editor) handle(ev keyEvent) {
+	insert := func(r rune) {
+		e.text = append(e.text[:e.cursor], append([]rune{r}, e.text[e.cursor:]...)...)
+		e.cursor++
+	}
+
+	switch ev.key {
+	case keyRune:
+		insert(ev.rune)
+	case keyEnter:
+		insert('\n')
+	case keyBackspace:
+		if e.cursor > 0 {
+			e.text = append(e.text[:e.cursor-1], e.text[e.cursor:]...)
+			e.cursor--
+		}
+	case keyDelete:
+		if e.cursor < len(e.text) {
+			e.text = append(e.text[:e.cursor], e.text[e.cursor+1:]...)
+		}
+	case keyLeft:
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case keyRight:
+		if e.cursor < len(e.text) {
+			e.cursor++
+		}
+	case keyHome:
+		e.cursor = e.lineStart(e.cursor)
+	case keyEnd:
+		e.cursor = e.lineEnd(e.cursor)
+	case keyUp:
+		e.moveLine(true)
+	case keyDown:
+		e.moveLine(false)
+	}
+}
+
+type tui struct {
+	ss      store.Store
+	ongoing *store.OpenActivity
+	today   []store.ClosedActivity
+	titles  []string
+	// message is the result of the last action, or error
+	message string
+
+	mode   tuiMode
+	picker *picker
+	editor *editor
+}
+
+// refresh reloads the ongoing activity, today's activities and titles.
+func (t *tui) refresh() error {
+	ongoing, err := t.ss.Ongoing()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).UTC()
+	end := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, time.Local).UTC()
+	today, err := t.ss.Closed(start, end, nil)
+	if err != nil {
+		return err
+	}
+
+	titles, err := t.ss.Titles(store.RankFrecency, "", now, 0)
+	if err != nil {
+		return err
+	}
+
+	t.ongoing, t.today, t.titles = ongoing, today, titles
+	return nil
+}
+
+// do runs the action, then refreshes data and shows the result in message line.
+func (t *tui) do(action func() (string, error)) {
+	message, err := action()
+	if err == nil {
+		err = t.refresh()
+	}
+	if err != nil {
+		message = "Error: " + err.Error()
+	}
+	t.message = message
+}
+
+func (t *tui) start(title string) {
+	t.do(func() (string, error) {
+		if err := t.ss.StartTitle(title, ""); err != nil {
+			return "", err
+		}
+
+		message := "Started: " + title
+		activity := store.OpenActivity{Title: title, Start: time.Now()}
+		warnings, err := store.BudgetWarnings(t.ss, &store.ClosedActivity{OpenActivity: &activity}, time.Now())
+		for _, warning := range warnings {
+			message += " (Warning: " + warning + ")"
+		}
+		return message, err
+	})
+}
+
+// saveNotes saves notes of the editor to the ongoing activity, which is read again so that
+// changes made elsewhere are kept. Notes are not saved if the activity is not ongoing any more.
+func (t *tui) saveNotes() (string, error) {
+	ongoing, err := t.ss.Ongoing()
+	if err != nil {
+		return "", err
+	}
+	if ongoing == nil || t.ongoing == nil || ongoing.Id != t.ongoing.Id {
+		return "", errors.New("the activity is not ongoing any more, notes are not saved")
+	}
+
+	ongoing.Notes = string(t.editor.text)
+	return "Notes saved.", t.ss.Update(&store.ClosedActivity{OpenActivity: ongoing})
+}
+
+// handle handles the key event, returns true to quit.
+func (t *tui) handle(e keyEvent) bool {
+	switch t.mode {
+	case tuiPick:
+		done, title, err := t.picker.handle(e)
+		if done {
+			t.mode = tuiNormal
+			if err == nil {
+				t.start(title)
+			}
+		}
+	case tuiNotes:
+		switch e.key {
+		case keySave:
+			t.mode = tuiNormal
+			t.do(t.saveNotes)
+		case keyCancel:
+			t.mode = tuiNormal
+		default:
+			t.editor.handle(e)
+		}
+	default:
+		if e.key == keyCancel {
+			return true
+		}
+		if e.key != keyRune {
+			break
+		}
+
+		switch r := e.rune; {
+		case r == 'q':
+			return true
+		case r >= '1' && r <= '9':
+			if i := int(r - '1'); i < len(t.titles) && i < tuiRecent {
+				t.start(t.titles[i])
+			}
+		case r == 's' || r == '/':
+			t.picker = newPicker(t.titles)
+			t.mode = tuiPick
+		case r == 'c':
+			t.do(func() (string, error) {
+				title, err := t.ss.CloseActivity("")
+				if title == "" {
+					return "No ongoing activity.", err
+				}
+				return "Closed: " + title, err
+			})
+		case r == 'n':
+			if t.ongoing == nil {
+				t.message = "No ongoing activity."
+				break
+			}
+			notes := []rune(t.ongoing.Notes)
+			t.editor = &editor{text: notes, cursor: len(notes)}
+			t.mode = tuiNotes
+		case r == 'r':
+			t.do(func() (string, error) { return "", nil })
+		}
+	}
+
+	return false
+}
+
+// elapsed returns d as "01:02:03".
+func elapsed(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// screen returns lines of the screen at 'now' with at most 'height' lines, and position of
+// the cursor, row is -1 if the cursor should be hidden.
+func (t *tui) screen(now time.Time, height int) (lines []string, row, col int) {
+	row = -1
+	lines = append(lines, "ticktock  "+now.Format(time.DateTime), "")
+
+	if t.ongoing != nil {
+		lines = append(lines, fmt.Sprintf("Ongoing: %s | %s", t.ongoing.Title, elapsed(now.Sub(t.ongoing.Start))))
+	} else {
+		lines = append(lines, "No ongoing activity.")
+	}
+
+	switch t.mode {
+	case tuiNotes:
+		lines = append(lines, "Notes (Ctrl-S to save, Esc to cancel):")
+		line, c := t.editor.position()
+		row, col = len(lines)+line, 4+c
+		for _, s := range strings.Split(string(t.editor.text), "\n") {
+			lines = append(lines, "    "+s)
+		}
+	case tuiPick:
+		lines = append(lines, "", "Start (Enter to start, Esc to cancel):")
+		row, col = len(lines), utf8.RuneCountInString(pickerPrompt)+t.picker.cursor
+		lines = append(lines, pickerPrompt+string(t.picker.query))
+		rows := height - len(lines) - 1
+		if rows > pickerRows {
+			rows = pickerRows
+		}
+		if rows > 0 {
+			lines = append(lines, t.picker.lines(rows)...)
+		}
+	default:
+		if t.ongoing != nil && t.ongoing.Notes != "" {
+			for _, s := range strings.Split(strings.TrimRight(t.ongoing.Notes, "\n"), "\n") {
+				lines = append(lines, "    "+s)
+			}
+		}
+
+		activities := t.today
+		if t.ongoing != nil {
+			activities = append(activities[:len(activities):len(activities)],
+				store.ClosedActivity{OpenActivity: t.ongoing, End: now})
+		}
+		lines = append(lines, "")
+		if len(activities) > 0 {
+			dist, _ := view.Render(activities, "dist", nil)
+			lines = append(lines, strings.Split(dist, "\n")...)
+		} else {
+			lines = append(lines, "No activity today.")
+		}
+
+		lines = append(lines, "", "Recent:")
+		for i, title := range t.titles {
+			if i == tuiRecent {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("  %d %s", i+1, title))
+		}
+	}
+
+	footer := []string{"", t.message}
+	switch t.mode {
+	case tuiNormal:
+		footer = append(footer, "[1-9] restart  [s] start  [c] close  [n] notes  [r] refresh  [q] quit")
+	default:
+		footer = append(footer, "")
+	}
+	if len(lines)+len(footer) > height {
+		keep := height - len(footer)
+		if keep < 0 {
+			keep = 0
+		}
+		lines = lines[:keep]
+		if row >= len(lines) {
+			row = -1
+		}
+	}
+
+	return append(lines, footer...), row, col
+}
+
+func (t *tui) render(w io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	lines, row, col := t.screen(time.Now(), height)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		// keep a column for the cursor, escape sequences in picker lines are not counted
+		if n := utf8.RuneCountInString(line); n >= width && !strings.Contains(line, "\x1b") {
+			line = string([]rune(line)[:width-1])
+		}
+		b.WriteString(line + "\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+
+	if row >= 0 {
+		fmt.Fprintf(&b, "\x1b[%d;%dH\x1b[?25h", row+1, col+1)
+	} else {
+		b.WriteString("\x1b[?25l")
+	}
+	io.WriteString(w, b.String())
+}
+
+// run shows the full-screen interface until user quits.
+func (t *tui) run() error {
+	if !isTerminal() {
+		return fmt.Errorf("tui requires a terminal")
+	}
+	if err := t.refresh(); err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	// alternate screen
+	io.WriteString(os.Stdout, "\x1b[?1049h")
+	defer io.WriteString(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	input := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			input <- buf[:n]
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastRefresh := time.Now()
+	for {
+		t.render(os.Stdout)
+
+		select {
+		case b := <-input:
+			for _, e := range parseKeys(b) {
+				if t.handle(e) {
+					return nil
+				}
+			}
+		case err := <-errs:
+			return err
+		case now := <-ticker.C:
+			if t.mode == tuiNormal && now.Sub(lastRefresh) >= tuiRefresh {
+				if err := t.refresh(); err != nil {
+					t.message = "Error: " + err.Error()
+				}
+				lastRefresh = now
+			}
+		}
+	}
+}
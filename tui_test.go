@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/cranej/ticktock/store"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditor(t *testing.T) {
+	for _, c := range []struct {
+		text  string
+		input string
+		want  string
+	}{
+		{"", "ab\rcd", "ab\ncd"},
+		{"abc\nd", "\x1b[AX", "aXbc\nd"},
+		{"abc\nd", "\x1b[A\x1b[A\x01X\x1b[BY", "Xabc\ndY"},
+		{"abc\ndef", "\x1b[D\x1b[D\x1b[AX\x05Y", "aXbcY\ndef"},
+		{"abc", "\x7f\x1b[D\x1b[3~", "a"},
+	} {
+		e := editor{text: []rune(c.text), cursor: len([]rune(c.text))}
+		for _, ev := range parseKeys([]byte(c.input)) {
+			e.handle(ev)
+		}
+		if got := string(e.text); got != c.want {
+			t.Errorf("%q with input %q: got %q, want %q", c.text, c.input, got, c.want)
+		}
+	}
+}
+
+func TestTuiScreen(t *testing.T) {
+	now := time.Now()
+	tui := tui{
+		ongoing: &store.OpenActivity{Title: "en: grammar", Start: now.Add(-(time.Hour + 2*time.Minute + 3*time.Second)), Notes: "verbs"},
+		titles:  []string{"gym", "en: grammar"},
+	}
+
+	lines, row, _ := tui.screen(now, 100)
+	screen := strings.Join(lines, "\n")
+	for _, want := range []string{"Ongoing: en: grammar | 01:02:03\n    verbs\n", "\n  1 gym\n  2 en: grammar\n"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("Expects %q in screen:\n%s", want, screen)
+		}
+	}
+	if row != -1 {
+		t.Errorf("Expects hidden cursor, got row %d", row)
+	}
+
+	tui.handle(keyEvent{key: keyRune, rune: 'n'})
+	tui.handle(keyEvent{key: keyEnter})
+	lines, row, col := tui.screen(now, 100)
+	if lines[row] != "    " || col != 4 {
+		t.Errorf("Got cursor at %d:%d of %q", row, col, lines)
+	}
+
+	lines, _, _ = tui.screen(now, 5)
+	if len(lines) != 5 {
+		t.Errorf("Expects 5 lines, got %q", lines)
+	}
+}
+
+func TestTuiSaveNotes(t *testing.T) {
+	ss, err := store.NewSqliteStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err := ss.StartTitle("en: grammar", "verbs"); err != nil {
+		t.Fatal(err)
+	}
+	tui := tui{ss: ss}
+	if err := tui.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	edit := func() {
+		tui.handle(keyEvent{key: keyRune, rune: 'n'})
+		for _, ev := range parseKeys([]byte(" and nouns")) {
+			tui.handle(ev)
+		}
+	}
+
+	// changed elsewhere while editing
+	edit()
+	ongoing, err := ss.Ongoing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ongoing.Billable = true
+	if err := ss.Update(&store.ClosedActivity{OpenActivity: ongoing}); err != nil {
+		t.Fatal(err)
+	}
+	tui.handle(keyEvent{key: keySave})
+	if ongoing, err = ss.Ongoing(); err != nil || ongoing.Notes != "verbs and nouns" || !ongoing.Billable {
+		t.Fatalf("Expects notes saved and billable kept, got (%+v, %v), message %q", ongoing, err, tui.message)
+	}
+
+	// closed elsewhere while editing
+	edit()
+	if _, err := ss.CloseActivity(""); err != nil {
+		t.Fatal(err)
+	}
+	tui.handle(keyEvent{key: keySave})
+	if !strings.HasPrefix(tui.message, "Error: ") {
+		t.Errorf("Expects an error, got message %q", tui.message)
+	}
+	if ongoing, err := ss.Ongoing(); err != nil || ongoing != nil {
+		t.Errorf("Expects the activity kept closed, got (%+v, %v)", ongoing, err)
+	}
+	last, err := ss.LastClosed("")
+	if err != nil || last.Notes != "verbs and nouns" {
+		t.Errorf("Expects notes not changed, got (%+v, %v)", last, err)
+	}
+}
@@ -0,0 +1,241 @@
+package view
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BillingItem is a billed activity, Rate is nil if no rate applies to it.
+type BillingItem struct {
+	*store.ClosedActivity
+	Client string
+	// Billed is the duration of the activity rounded up.
+	Billed time.Duration
+	Rate   *store.Rate
+	Amount int64
+}
+
+// Billing items are ordered by Client then Start.
+type Billing []BillingItem
+
+// NewBilling bills billable activities by rates, non-billable ones are skipped. Durations of
+// activities are rounded up to multiple of 'round' before multiplied by rates, no rounding if
+// round <= 0. The client of an activity is the first of its tags in clients if not empty, or the
+// tag of its rate, or Tag(), see billingClient.
+func NewBilling(activities []store.ClosedActivity, rates []store.Rate, clients []string, round time.Duration) Billing {
+	billing := make(Billing, 0, len(activities))
+	for i := range activities {
+		e := &activities[i]
+		if !e.Billable {
+			continue
+		}
+
+		billed := e.End.Sub(e.Start)
+		if round > 0 && billed%round != 0 {
+			billed = billed.Truncate(round) + round
+		}
+
+		client := billingClient(e.OpenActivity, rates, clients)
+		item := BillingItem{ClosedActivity: e, Client: client, Billed: billed}
+		if rate := store.FindTagRate(rates, e.OpenActivity, client); rate != nil {
+			item.Rate = rate
+			// round half up to minor unit
+			item.Amount = (rate.Amount*int64(billed/time.Second) + 1800) / 3600
+		}
+		billing = append(billing, item)
+	}
+
+	sort.SliceStable(billing, func(i, j int) bool {
+		if billing[i].Client != billing[j].Client {
+			return billing[i].Client < billing[j].Client
+		}
+		return billing[i].Start.Before(billing[j].Start)
+	})
+	return billing
+}
+
+// billingClient returns the first of tags of the activity in clients, Tag() first, or the tag of
+// its rate if clients is empty, or Tag() if the rate is not of a tag.
+func billingClient(activity *store.OpenActivity, rates []store.Rate, clients []string) string {
+	if len(clients) == 0 {
+		if rate := store.FindRate(rates, activity); rate != nil && rate.IsTag {
+			return rate.Name
+		}
+		return activity.Tag()
+	}
+
+	for _, tag := range append([]string{activity.Tag()}, activity.AllTags()...) {
+		for _, client := range clients {
+			if tag == client {
+				return tag
+			}
+		}
+	}
+	return activity.Tag()
+}
+
+// Clients returns the distinct clients of items, in order.
+func (billing Billing) Clients() []string {
+	clients := make([]string, 0)
+	for _, item := range billing {
+		if len(clients) == 0 || clients[len(clients)-1] != item.Client {
+			clients = append(clients, item.Client)
+		}
+	}
+
+	return clients
+}
+
+// Client returns items of the client.
+func (billing Billing) Client(client string) Billing {
+	items := make(Billing, 0)
+	for _, item := range billing {
+		if item.Client == client {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// totals returns total amount by currency, in order of currency.
+func (billing Billing) totals() [][2]string {
+	byCurrency := make(map[string]int64)
+	for _, item := range billing {
+		if item.Rate != nil {
+			byCurrency[item.Rate.Currency] += item.Amount
+		}
+	}
+
+	totals := make([][2]string, 0, len(byCurrency))
+	for currency, amount := range byCurrency {
+		totals = append(totals, [2]string{store.FormatAmount(amount), currency})
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i][1] < totals[j][1] })
+	return totals
+}
+
+func (item *BillingItem) rateS() string {
+	if item.Rate == nil {
+		return "no rate"
+	}
+
+	return fmt.Sprintf("%s %s/h", store.FormatAmount(item.Rate.Amount), item.Rate.Currency)
+}
+
+func (item *BillingItem) amountS() string {
+	if item.Rate == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s %s", store.FormatAmount(item.Amount), item.Rate.Currency)
+}
+
+func (billing Billing) String() string {
+	var b strings.Builder
+	for _, client := range billing.Clients() {
+		fmt.Fprintln(&b, client)
+
+		// aggregate by title and rate
+		type key struct {
+			title string
+			rate  *store.Rate
+		}
+		keys := make([]key, 0)
+		items := make(map[key]*BillingItem)
+		for _, item := range billing.Client(client) {
+			k := key{item.Title, item.Rate}
+			if agg, ok := items[k]; ok {
+				agg.Billed += item.Billed
+				agg.Amount += item.Amount
+			} else {
+				keys = append(keys, k)
+				items[k] = &BillingItem{Rate: item.Rate, Billed: item.Billed, Amount: item.Amount}
+			}
+		}
+
+		for _, k := range keys {
+			item := items[k]
+			fmt.Fprintf(&b, "  %s | %s | %s | %s\n", k.title, DurS(item.Billed), item.rateS(), item.amountS())
+		}
+		for _, total := range billing.Client(client).totals() {
+			fmt.Fprintf(&b, "(Total): %s %s\n", total[0], total[1])
+		}
+		fmt.Fprintln(&b)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+var invoiceHeader = []string{"Date", "Client", "Title", "Hours", "Rate", "Currency", "Amount", "Meta"}
+
+func (item *BillingItem) invoiceRecord() []string {
+	rate, currency, amount := "", "", ""
+	if item.Rate != nil {
+		rate = store.FormatAmount(item.Rate.Amount)
+		currency = item.Rate.Currency
+		amount = store.FormatAmount(item.Amount)
+	}
+
+	return []string{
+		item.Start.Local().Format(time.DateOnly),
+		item.Client,
+		item.Title,
+		fmt.Sprintf("%.2f", item.Billed.Hours()),
+		rate,
+		currency,
+		amount,
+		item.MetaString(),
+	}
+}
+
+// WriteCSV writes invoice line items, one activity a line, to w.
+func (billing Billing) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(invoiceHeader); err != nil {
+		return err
+	}
+
+	for i := range billing {
+		if err := cw.Write(billing[i].invoiceRecord()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown writes invoice line items as markdown tables, one table a client.
+func (billing Billing) WriteMarkdown(w io.Writer) error {
+	row := func(cells []string) string {
+		escaped := make([]string, 0, len(cells))
+		for _, c := range cells {
+			escaped = append(escaped, strings.ReplaceAll(c, "|", "\\|"))
+		}
+		return "| " + strings.Join(escaped, " | ") + " |\n"
+	}
+
+	var b strings.Builder
+	for _, client := range billing.Clients() {
+		items := billing.Client(client)
+		fmt.Fprintf(&b, "## %s\n\n", client)
+		b.WriteString(row(invoiceHeader))
+		b.WriteString(strings.Repeat("| --- ", len(invoiceHeader)) + "|\n")
+		for i := range items {
+			b.WriteString(row(items[i].invoiceRecord()))
+		}
+		for _, total := range items.totals() {
+			fmt.Fprintf(&b, "\n**Total: %s %s**\n", total[0], total[1])
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,184 @@
+package view
+
+import (
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+var march = time.Date(2023, time.March, 1, 12, 0, 0, 0, time.Local)
+
+// closed returns a billable activity.
+func closed(title string, start time.Time, d time.Duration) store.ClosedActivity {
+	return store.ClosedActivity{OpenActivity: &store.OpenActivity{Title: title, Start: start, Billable: true}, End: start.Add(d)}
+}
+
+var testRates = []store.Rate{
+	{Name: "clientA", IsTag: true, Amount: 10000, Currency: "USD"},
+	{Name: "clientA: meeting", Amount: 5000, Currency: "USD"},
+	{Name: "clientB", IsTag: true, Amount: 8000, Currency: "EUR", Since: march.AddDate(0, 0, 1)},
+	{Name: "clientB", IsTag: true, Amount: 9000, Currency: "EUR", Since: march.AddDate(0, 0, 3)},
+}
+
+func TestBillingRounding(t *testing.T) {
+	for _, c := range []struct {
+		d      time.Duration
+		round  time.Duration
+		billed time.Duration
+		amount int64
+	}{
+		{50 * time.Minute, 15 * time.Minute, time.Hour, 10000},
+		{50 * time.Minute, 10 * time.Minute, 50 * time.Minute, 8333},
+		{50 * time.Minute, time.Hour, time.Hour, 10000},
+		{50 * time.Minute, 0, 50 * time.Minute, 8333},
+		{50 * time.Minute, -time.Minute, 50 * time.Minute, 8333},
+		// 1166.67 rounded half up
+		{7 * time.Minute, 0, 7 * time.Minute, 1167},
+		{time.Second, 15 * time.Minute, 15 * time.Minute, 2500},
+	} {
+		billing := NewBilling([]store.ClosedActivity{closed("clientA: design", march, c.d)}, testRates, nil, c.round)
+		if len(billing) != 1 || billing[0].Billed != c.billed || billing[0].Amount != c.amount {
+			t.Errorf("%v rounded to %v: got %+v, want billed %v and amount %d", c.d, c.round, billing, c.billed, c.amount)
+		}
+	}
+}
+
+func TestBillingRates(t *testing.T) {
+	for _, c := range []struct {
+		title  string
+		start  time.Time
+		amount int64
+	}{
+		{"clientA: design", march, 10000},
+		// a title rate takes precedence over the tag one
+		{"clientA: meeting", march, 5000},
+		{"clientB: design", march, 0},
+		{"clientB: design", march.AddDate(0, 0, 1), 8000},
+		{"clientB: design", march.AddDate(0, 0, 2), 8000},
+		{"clientB: design", march.AddDate(0, 0, 4), 9000},
+		{"other", march, 0},
+	} {
+		billing := NewBilling([]store.ClosedActivity{closed(c.title, c.start, time.Hour)}, testRates, nil, 0)
+		item := billing[0]
+		if c.amount == 0 {
+			if item.Rate != nil || item.Amount != 0 {
+				t.Errorf("%s at %v: expects no rate, got %+v", c.title, c.start, item.Rate)
+			}
+			continue
+		}
+		if item.Rate == nil || item.Rate.Amount != c.amount || item.Amount != c.amount {
+			t.Errorf("%s at %v: got %+v, want rate %d", c.title, c.start, item.Rate, c.amount)
+		}
+	}
+}
+
+func TestBillingTags(t *testing.T) {
+	tagged := func(title string, tags ...string) store.ClosedActivity {
+		e := closed(title, march.AddDate(0, 0, 1), time.Hour)
+		e.Tags = tags
+		return e
+	}
+	for _, c := range []struct {
+		activity store.ClosedActivity
+		clients  []string
+		client   string
+		amount   int64
+	}{
+		{tagged("design: logo", "clientA"), nil, "clientA", 10000},
+		{tagged("design: logo", "clientA"), []string{"clientA"}, "clientA", 10000},
+		// the rate of the title tag takes precedence, unless another tag is the client
+		{tagged("clientB: logo", "clientA"), nil, "clientB", 8000},
+		{tagged("clientB: logo", "clientA"), []string{"clientA"}, "clientA", 10000},
+		{tagged("design: logo", "other"), nil, "design", 0},
+	} {
+		billing := NewBilling([]store.ClosedActivity{c.activity}, testRates, c.clients, 0)
+		if item := billing[0]; item.Client != c.client || item.Amount != c.amount {
+			t.Errorf("%s %v for %v: got client %s and amount %d, want %s and %d",
+				c.activity.Title, c.activity.Tags, c.clients, item.Client, item.Amount, c.client, c.amount)
+		}
+	}
+}
+
+func testBilling() Billing {
+	meeting := closed("clientA: meeting", march, 30*time.Minute)
+	meeting.Meta = map[string]string{"project": "x|y"}
+	unbilled := closed("clientA: support", march, time.Hour)
+	unbilled.Billable = false
+	return NewBilling([]store.ClosedActivity{
+		unbilled,
+		closed("clientB: design", march.AddDate(0, 0, 1), 2*time.Hour),
+		closed("clientA: design", march.AddDate(0, 0, 1), 50*time.Minute),
+		meeting,
+		closed("clientA: design", march, 90*time.Minute),
+		closed("other", march, time.Hour),
+	}, testRates, nil, 15*time.Minute)
+}
+
+func TestBillingOutput(t *testing.T) {
+	billing := testBilling()
+	if len(billing) != 5 {
+		t.Fatalf("Expects the non-billable activity skipped, got %d items", len(billing))
+	}
+	if clients := strings.Join(billing.Clients(), ","); clients != "clientA,clientB,other" {
+		t.Fatalf("Got clients %s", clients)
+	}
+
+	for _, c := range []struct {
+		name  string
+		write func(*strings.Builder) error
+		want  string
+	}{
+		{"csv", func(b *strings.Builder) error { return billing.WriteCSV(b) }, `Date,Client,Title,Hours,Rate,Currency,Amount,Meta
+2023-03-01,clientA,clientA: meeting,0.50,50.00,USD,25.00,project=x|y
+2023-03-01,clientA,clientA: design,1.50,100.00,USD,150.00,
+2023-03-02,clientA,clientA: design,1.00,100.00,USD,100.00,
+2023-03-02,clientB,clientB: design,2.00,80.00,EUR,160.00,
+2023-03-01,other,other,1.00,,,,
+`},
+		{"markdown", func(b *strings.Builder) error { return billing.WriteMarkdown(b) }, `## clientA
+
+| Date | Client | Title | Hours | Rate | Currency | Amount | Meta |
+| --- | --- | --- | --- | --- | --- | --- | --- |
+| 2023-03-01 | clientA | clientA: meeting | 0.50 | 50.00 | USD | 25.00 | project=x\|y |
+| 2023-03-01 | clientA | clientA: design | 1.50 | 100.00 | USD | 150.00 |  |
+| 2023-03-02 | clientA | clientA: design | 1.00 | 100.00 | USD | 100.00 |  |
+
+**Total: 275.00 USD**
+
+## clientB
+
+| Date | Client | Title | Hours | Rate | Currency | Amount | Meta |
+| --- | --- | --- | --- | --- | --- | --- | --- |
+| 2023-03-02 | clientB | clientB: design | 2.00 | 80.00 | EUR | 160.00 |  |
+
+**Total: 160.00 EUR**
+
+## other
+
+| Date | Client | Title | Hours | Rate | Currency | Amount | Meta |
+| --- | --- | --- | --- | --- | --- | --- | --- |
+| 2023-03-01 | other | other | 1.00 |  |  |  |  |
+
+`},
+		{"text", func(b *strings.Builder) error { _, err := b.WriteString(billing.String()); return err }, `clientA
+  clientA: meeting | 30m | 50.00 USD/h | 25.00 USD
+  clientA: design | 2h30m | 100.00 USD/h | 250.00 USD
+(Total): 275.00 USD
+
+clientB
+  clientB: design | 2h0m | 80.00 EUR/h | 160.00 EUR
+(Total): 160.00 EUR
+
+other
+  other | 1h0m | no rate | -`},
+	} {
+		var b strings.Builder
+		if err := c.write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, b.String(), c.want)
+		}
+	}
+}
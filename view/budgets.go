@@ -0,0 +1,24 @@
+package view
+
+import (
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"strings"
+)
+
+type Budgets []store.BudgetStatus
+
+func (budgets Budgets) String() string {
+	var b strings.Builder
+	for i := range budgets {
+		s := &budgets[i]
+		fmt.Fprintf(&b, "%s: %s used, %s remaining of %s %s\n",
+			&s.Budget,
+			store.Hours(s.Used),
+			store.Hours(s.Remaining()),
+			store.Hours(s.Limit),
+			ProgressBar(s.Used, s.Limit))
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
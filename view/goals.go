@@ -0,0 +1,102 @@
+package view
+
+import (
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"time"
+)
+
+// GoalLookback is how far Goals looks back for streaks.
+const GoalLookback = 366 * 24 * time.Hour
+
+const barWidth = 20
+
+type GoalStatus struct {
+	store.Goal
+	// Done is the time spent in the period of the reference time.
+	Done time.Duration
+	// Streak is the number of consecutive periods reached the target, counting back from the
+	// period of the reference time, or from the previous one if the target is not reached yet.
+	Streak int
+}
+
+type Goals []GoalStatus
+
+// NewGoals calculates status of each goal at time 'at', against activities within
+// [at - GoalLookback, at].
+func NewGoals(goals []store.Goal, activities []store.ClosedActivity, at time.Time) Goals {
+	result := make(Goals, 0, len(goals))
+	for _, goal := range goals {
+		// spent time of each period, keyed by period start
+		periods := make(map[time.Time]time.Duration)
+		for _, e := range activities {
+			if e.HasTag(goal.Tag) && !e.Start.After(at) {
+				start := goal.Period.Start(e.Start)
+				periods[start] += e.End.Sub(e.Start)
+			}
+		}
+
+		current := goal.Period.Start(at)
+		status := GoalStatus{Goal: goal, Done: periods[current]}
+
+		period := current
+		if status.Done < goal.Target {
+			period = goal.Period.Shift(current, -1)
+		}
+		for periods[period] >= goal.Target && at.Sub(period) <= GoalLookback {
+			status.Streak++
+			period = goal.Period.Shift(period, -1)
+		}
+
+		result = append(result, status)
+	}
+
+	return result
+}
+
+// Progress returns the time spent on activities with goal's tag during the period which 'at' falls in.
+func Progress(goal *store.Goal, activities []store.ClosedActivity, at time.Time) time.Duration {
+	current := goal.Period.Start(at)
+	var done time.Duration
+	for _, e := range activities {
+		if e.HasTag(goal.Tag) && goal.Period.Start(e.Start).Equal(current) {
+			done += e.End.Sub(e.Start)
+		}
+	}
+
+	return done
+}
+
+// ProgressBar returns string represention of done/target as "[#####---------------]  25%"
+func ProgressBar(done, target time.Duration) string {
+	percent := 100
+	if target > 0 {
+		percent = int(done * 100 / target)
+	}
+
+	filled := percent * barWidth / 100
+	if filled > barWidth {
+		filled = barWidth
+	}
+
+	return fmt.Sprintf("[%s%s] %3d%%",
+		strings.Repeat("#", filled),
+		strings.Repeat("-", barWidth-filled),
+		percent)
+}
+
+func (g Goals) String() string {
+	var b strings.Builder
+	for _, s := range g {
+		fmt.Fprintf(&b, "%s (per %s): %s / %s %s | streak: %d\n",
+			s.Tag,
+			s.Period,
+			DurS(s.Done),
+			DurS(s.Target),
+			ProgressBar(s.Done, s.Target),
+			s.Streak)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
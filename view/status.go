@@ -0,0 +1,159 @@
+package view
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"time"
+)
+
+// Classes of Status, used as CSS class by waybar.
+const (
+	StatusIdle       = "idle"
+	StatusRunning    = "running"
+	StatusOverBudget = "over-budget"
+)
+
+// Colors of classes in i3blocks format.
+var statusColors = map[string]string{
+	StatusIdle:       "#888888",
+	StatusRunning:    "#50fa7b",
+	StatusOverBudget: "#ff5555",
+}
+
+// Status is the state shown in status bars.
+type Status struct {
+	// Ongoing is nil if there is no ongoing activity.
+	Ongoing *store.OpenActivity
+	// IdleSince is the End of the last closed activity, zero if not known.
+	IdleSince time.Time
+	// Today is the total time tracked today, including the ongoing activity.
+	Today time.Duration
+	// Budgets are the budgets the ongoing activity is counted in.
+	Budgets []store.BudgetStatus
+	At      time.Time
+}
+
+func (s *Status) Class() string {
+	if s.Ongoing == nil {
+		return StatusIdle
+	}
+
+	for i := range s.Budgets {
+		if s.Budgets[i].Ratio() >= store.BudgetExceededRatio {
+			return StatusOverBudget
+		}
+	}
+	return StatusRunning
+}
+
+// Text returns a short text as "en: grammar 1h2m", or "idle 15m".
+func (s *Status) Text() string {
+	if s.Ongoing == nil {
+		if s.IdleSince.IsZero() {
+			return StatusIdle
+		}
+		return StatusIdle + " " + DurS(s.At.Sub(s.IdleSince))
+	}
+
+	return s.Ongoing.Title + " " + DurS(s.At.Sub(s.Ongoing.Start))
+}
+
+// Tooltip returns the details: start time, notes, today's total and budget warnings.
+func (s *Status) Tooltip() string {
+	var b strings.Builder
+	if s.Ongoing != nil {
+		fmt.Fprintf(&b, "%s\nStarted at %s\n", s.Ongoing.Title, s.Ongoing.Start.Local().Format(time.TimeOnly))
+		if notes := strings.TrimSpace(s.Ongoing.Notes); notes != "" {
+			fmt.Fprintf(&b, "%s\n", notes)
+		}
+	} else if !s.IdleSince.IsZero() {
+		fmt.Fprintf(&b, "Idle since %s\n", s.IdleSince.Local().Format(time.TimeOnly))
+	}
+
+	fmt.Fprintf(&b, "Today: %s", DurS(s.Today))
+	for i := range s.Budgets {
+		if w := s.Budgets[i].Warning(); w != "" {
+			fmt.Fprintf(&b, "\n%s", w)
+		}
+	}
+
+	return b.String()
+}
+
+// Advance returns the status at 'now', later than At, counting the time since At as tracked by the
+// ongoing activity, in Today and in its budgets, so that it is updated without loading it again.
+func (s *Status) Advance(now time.Time) *Status {
+	advanced := *s
+	advanced.At = now
+	if s.Ongoing == nil {
+		return &advanced
+	}
+
+	elapsed := now.Sub(s.At)
+	advanced.Today += elapsed
+	advanced.Budgets = make([]store.BudgetStatus, len(s.Budgets))
+	for i, budget := range s.Budgets {
+		budget.Used += elapsed
+		advanced.Budgets[i] = budget
+	}
+	return &advanced
+}
+
+// Format returns the status in the format of waybar, i3blocks, plain text, or json.
+// If oneLine, the i3blocks format is json as well, for blocks with 'format=json'.
+func (s *Status) Format(format string, oneLine bool) (string, error) {
+	switch format {
+	case "plain":
+		return s.Text(), nil
+	case "waybar":
+		return marshal(struct {
+			Text    string `json:"text"`
+			Tooltip string `json:"tooltip"`
+			Class   string `json:"class"`
+			Alt     string `json:"alt"`
+		}{s.Text(), s.Tooltip(), s.Class(), s.Class()})
+	case "i3blocks":
+		short := StatusIdle
+		if s.Ongoing != nil {
+			short = DurS(s.At.Sub(s.Ongoing.Start))
+		}
+		if oneLine {
+			return marshal(struct {
+				FullText  string `json:"full_text"`
+				ShortText string `json:"short_text"`
+				Color     string `json:"color"`
+			}{s.Text(), short, statusColors[s.Class()]})
+		}
+		return strings.Join([]string{s.Text(), short, statusColors[s.Class()]}, "\n"), nil
+	case "json":
+		status := struct {
+			Class     string
+			Text      string
+			Ongoing   *store.OpenActivity
+			Elapsed   int64
+			IdleSince *time.Time
+			Today     int64
+			Warnings  []string
+		}{Class: s.Class(), Text: s.Text(), Ongoing: s.Ongoing, Today: int64(s.Today.Seconds()), Warnings: []string{}}
+		if s.Ongoing != nil {
+			status.Elapsed = int64(s.At.Sub(s.Ongoing.Start).Seconds())
+		} else if !s.IdleSince.IsZero() {
+			status.IdleSince = &s.IdleSince
+		}
+		for i := range s.Budgets {
+			if w := s.Budgets[i].Warning(); w != "" {
+				status.Warnings = append(status.Warnings, w)
+			}
+		}
+		return marshal(status)
+	default:
+		return "", fmt.Errorf("unknown status format %s", format)
+	}
+}
+
+func marshal(v any) (string, error) {
+	j, err := json.Marshal(v)
+	return string(j), err
+}
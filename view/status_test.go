@@ -0,0 +1,122 @@
+package view
+
+import (
+	"encoding/json"
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusFormat(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 10, 2, 3, 0, time.Local)
+	ongoing := &store.OpenActivity{Title: "en: grammar", Start: at.Add(-(time.Hour + 2*time.Minute + 3*time.Second)), Notes: "verbs\n"}
+	warned := store.BudgetStatus{Budget: store.Budget{Name: "en", IsTag: true, Limit: 10 * time.Hour}, Used: 9 * time.Hour}
+	exceeded := store.BudgetStatus{Budget: store.Budget{Name: "en: grammar", Limit: time.Hour}, Used: 2 * time.Hour}
+
+	statuses := map[string]*Status{
+		"none":     {At: at},
+		"idle":     {IdleSince: at.Add(-15 * time.Minute), Today: 90 * time.Minute, At: at},
+		"ongoing":  {Ongoing: ongoing, Today: 2 * time.Hour, At: at},
+		"budgets":  {Ongoing: ongoing, Today: 2 * time.Hour, At: at, Budgets: []store.BudgetStatus{warned}},
+		"exceeded": {Ongoing: ongoing, Today: 2 * time.Hour, At: at, Budgets: []store.BudgetStatus{warned, exceeded}},
+	}
+	for _, c := range []struct {
+		status  string
+		format  string
+		oneLine bool
+		want    string
+	}{
+		{"none", "plain", false, "idle"},
+		{"idle", "plain", false, "idle 15m"},
+		{"ongoing", "plain", false, "en: grammar 1h2m"},
+
+		{"none", "waybar", false, `{"text":"idle","tooltip":"Today: 0m","class":"idle","alt":"idle"}`},
+		{"idle", "waybar", false, `{"text":"idle 15m","tooltip":"Idle since 09:47:03\nToday: 1h30m","class":"idle","alt":"idle"}`},
+		{"ongoing", "waybar", false, `{"text":"en: grammar 1h2m","tooltip":"en: grammar\nStarted at 09:00:00\nverbs\nToday: 2h0m","class":"running","alt":"running"}`},
+		{"budgets", "waybar", false, `{"text":"en: grammar 1h2m","tooltip":"en: grammar\nStarted at 09:00:00\nverbs\nToday: 2h0m\nBudget en (tag): 9h of 10h used (90%)","class":"running","alt":"running"}`},
+		{"exceeded", "waybar", false, `{"text":"en: grammar 1h2m","tooltip":"en: grammar\nStarted at 09:00:00\nverbs\nToday: 2h0m\nBudget en (tag): 9h of 10h used (90%)\nBudget en: grammar (title) exceeded: 2h of 1h used (200%)","class":"over-budget","alt":"over-budget"}`},
+
+		{"none", "i3blocks", false, "idle\nidle\n#888888"},
+		{"ongoing", "i3blocks", false, "en: grammar 1h2m\n1h2m\n#50fa7b"},
+		{"exceeded", "i3blocks", false, "en: grammar 1h2m\n1h2m\n#ff5555"},
+		{"idle", "i3blocks", true, `{"full_text":"idle 15m","short_text":"idle","color":"#888888"}`},
+		{"ongoing", "i3blocks", true, `{"full_text":"en: grammar 1h2m","short_text":"1h2m","color":"#50fa7b"}`},
+	} {
+		got, err := statuses[c.status].Format(c.format, c.oneLine)
+		if err != nil || got != c.want {
+			t.Errorf("%s in %s (one line %v): got (%s, %v), want %s", c.status, c.format, c.oneLine, got, err, c.want)
+		}
+	}
+
+	if _, err := statuses["none"].Format("xml", false); err == nil {
+		t.Error("Expects an error of unknown format")
+	}
+}
+
+func TestStatusJson(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 10, 2, 3, 0, time.UTC)
+	ongoing := &store.OpenActivity{Title: "en: grammar", Start: at.Add(-time.Hour)}
+	exceeded := store.BudgetStatus{Budget: store.Budget{Name: "en: grammar", Limit: time.Hour}, Used: 2 * time.Hour}
+
+	type status struct {
+		Class     string
+		Text      string
+		Ongoing   *store.OpenActivity
+		Elapsed   int64
+		IdleSince *time.Time
+		Today     int64
+		Warnings  []string
+	}
+	for _, c := range []struct {
+		status Status
+		want   status
+	}{
+		{Status{At: at}, status{Class: "idle", Text: "idle", Warnings: []string{}}},
+		{Status{IdleSince: at.Add(-15 * time.Minute), Today: time.Hour, At: at},
+			status{Class: "idle", Text: "idle 15m", Today: 3600, Warnings: []string{}}},
+		{Status{Ongoing: ongoing, Today: 2 * time.Hour, At: at, Budgets: []store.BudgetStatus{exceeded}},
+			status{Class: "over-budget", Text: "en: grammar 1h0m", Elapsed: 3600, Today: 7200,
+				Warnings: []string{"Budget en: grammar (title) exceeded: 2h of 1h used (200%)"}}},
+	} {
+		out, err := c.status.Format("json", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got status
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("Invalid JSON %s: %v", out, err)
+		}
+
+		if got.Class != c.want.Class || got.Text != c.want.Text || got.Elapsed != c.want.Elapsed || got.Today != c.want.Today ||
+			strings.Join(got.Warnings, "\n") != strings.Join(c.want.Warnings, "\n") || got.Warnings == nil {
+			t.Errorf("Got %s, want %+v", out, c.want)
+		}
+		if (got.Ongoing == nil) != (c.status.Ongoing == nil) || got.Ongoing != nil && got.Ongoing.Title != c.status.Ongoing.Title {
+			t.Errorf("Got ongoing %+v, want %+v", got.Ongoing, c.status.Ongoing)
+		}
+		if c.status.Ongoing == nil && !c.status.IdleSince.IsZero() && (got.IdleSince == nil || !got.IdleSince.Equal(c.status.IdleSince)) {
+			t.Errorf("Got idle since %v, want %v", got.IdleSince, c.status.IdleSince)
+		}
+	}
+}
+
+func TestStatusAdvance(t *testing.T) {
+	at := time.Date(2023, time.March, 1, 10, 0, 0, 0, time.UTC)
+	budget := store.BudgetStatus{Budget: store.Budget{Name: "en", IsTag: true, Limit: time.Hour}, Used: 50 * time.Minute}
+	ongoing := &Status{Ongoing: &store.OpenActivity{Title: "en: grammar", Start: at.Add(-time.Hour)}, Today: 2 * time.Hour,
+		Budgets: []store.BudgetStatus{budget}, At: at}
+
+	advanced := ongoing.Advance(at.Add(10 * time.Minute))
+	if advanced.Today != 2*time.Hour+10*time.Minute || advanced.Budgets[0].Used != time.Hour || advanced.Class() != StatusOverBudget {
+		t.Fatalf("Got %+v", advanced)
+	}
+	if ongoing.Today != 2*time.Hour || ongoing.Budgets[0].Used != 50*time.Minute || ongoing.At != at {
+		t.Fatalf("Expects the status unchanged, got %+v", ongoing)
+	}
+
+	idle := &Status{IdleSince: at.Add(-time.Hour), Today: time.Hour, At: at}
+	if got := idle.Advance(at.Add(10 * time.Minute)); got.Today != time.Hour || got.Text() != "idle 1h10m" {
+		t.Fatalf("Got %+v", got)
+	}
+}
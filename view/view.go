@@ -46,17 +46,80 @@ func Render(activities []store.ClosedActivity, viewType string, keyF KeyFunc) (s
 	return viewF(activities, keyF).String(), nil
 }
 
+// MetaKey returns a KeyFunc keys activities by value of the metadata key.
+func MetaKey(key string) KeyFunc {
+	return func(e *store.ClosedActivity) string {
+		if value, ok := e.Meta[key]; ok {
+			return value
+		}
+		return "(no " + key + ")"
+	}
+}
+
 var round time.Duration = time.Duration(time.Minute)
 
-// durS return string represention of d as "72h3m"
+// DurS returns string represention of d as "72h3m", rounded to minutes
+func DurS(d time.Duration) string {
+	d = d.Round(round)
+	if d == 0 {
+		return "0m"
+	}
+	return strings.TrimSuffix(d.String(), "0s")
+}
+
+// durS returns string represention of d as DurS, but empty string for zero as reports always did.
 func durS(d time.Duration) string {
-	return strings.TrimSuffix(d.Round(round).String(), "0s")
+	if d.Round(round) == 0 {
+		return ""
+	}
+	return DurS(d)
+}
+
+// Billables sums durations of billable and non-billable activities.
+type Billables struct {
+	Billable    time.Duration
+	NonBillable time.Duration
+}
+
+func (b *Billables) add(e *store.ClosedActivity) {
+	if e.Billable {
+		b.Billable += e.End.Sub(e.Start)
+	} else {
+		b.NonBillable += e.End.Sub(e.Start)
+	}
+}
+
+// String returns "(Billable): 3h, (Non-billable): 1h", or empty string if b is nil.
+func (b *Billables) String() string {
+	if b == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("(Billable): %s, (Non-billable): %s", DurS(b.Billable), DurS(b.NonBillable))
+}
+
+// anyBillable returns true if any of activities is billable, then views show billable totals.
+func anyBillable(activities []store.ClosedActivity) bool {
+	for i := range activities {
+		if activities[i].Billable {
+			return true
+		}
+	}
+	return false
 }
 
 type Summary map[string]map[string]time.Duration
 
+// BillableSummary is Summary with billable and non-billable totals of each day.
+type BillableSummary struct {
+	Summary
+	Billables map[string]*Billables
+}
+
+// NewSummary returns a Summary, or a BillableSummary if any of activities is billable.
 func NewSummary(activities []store.ClosedActivity, keyF KeyFunc) Impl {
 	summary := make(Summary)
+	billables := make(map[string]*Billables)
 
 	for _, e := range activities {
 		day := e.Start.Local().Format(time.DateOnly)
@@ -64,17 +127,31 @@ func NewSummary(activities []store.ClosedActivity, keyF KeyFunc) Impl {
 		if !ok {
 			dayMap = make(map[string]time.Duration)
 			summary[day] = dayMap
+			billables[day] = &Billables{}
 		}
 
 		key := keyF(&e)
 		dur := dayMap[key]
 		dayMap[key] = dur + e.End.Sub(e.Start)
+		billables[day].add(&e)
 	}
 
+	if anyBillable(activities) {
+		return BillableSummary{summary, billables}
+	}
 	return summary
 }
 
 func (s Summary) String() string {
+	return s.string(nil)
+}
+
+func (s BillableSummary) String() string {
+	return s.Summary.string(s.Billables)
+}
+
+// string returns the summary, with the billable totals of each day in billables if any.
+func (s Summary) string(billables map[string]*Billables) string {
 	var b strings.Builder
 	for day, dayMap := range s {
 		fmt.Fprintln(&b, day)
@@ -85,7 +162,11 @@ func (s Summary) String() string {
 			dayDur += dur
 		}
 
-		fmt.Fprintf(&b, "(Total): %s\n\n", durS(dayDur))
+		fmt.Fprintf(&b, "(Total): %s\n", durS(dayDur))
+		if line := billables[day].String(); line != "" {
+			fmt.Fprintln(&b, line)
+		}
+		fmt.Fprintln(&b)
 	}
 
 	return strings.TrimRight(b.String(), "\n")
@@ -132,13 +213,25 @@ func (d Detail) String() string {
 
 type Efforts map[string]time.Duration
 
+// BillableEfforts is Efforts with billable and non-billable totals.
+type BillableEfforts struct {
+	Efforts
+	Billables
+}
+
+// NewEfforts returns Efforts, or BillableEfforts if any of activities is billable.
 func NewEfforts(activities []store.ClosedActivity, keyF KeyFunc) Impl {
 	efforts := make(Efforts)
+	var billables Billables
 	for _, e := range activities {
 		key := keyF(&e)
 		efforts[key] = efforts[key] + e.End.Sub(e.Start)
+		billables.add(&e)
 	}
 
+	if anyBillable(activities) {
+		return BillableEfforts{efforts, billables}
+	}
 	return efforts
 }
 
@@ -151,6 +244,14 @@ func (eff Efforts) String() string {
 	return strings.TrimRight(b.String(), "\n")
 }
 
+func (eff BillableEfforts) String() string {
+	efforts := eff.Efforts.String()
+	if billables := eff.Billables.String(); billables != "" {
+		return efforts + "\n" + billables
+	}
+	return efforts
+}
+
 type Distribution map[string][]*store.ClosedActivity
 
 const IDLE_TITLE string = "<idle>"
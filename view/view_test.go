@@ -0,0 +1,54 @@
+package view
+
+import (
+	"github.com/cranej/ticktock/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViews(t *testing.T) {
+	billable := closed("a", march, time.Hour)
+	free := closed("b", march, 20*time.Second)
+	free.Billable = false
+	titleKey := func(e *store.ClosedActivity) string { return e.Title }
+
+	for _, c := range []struct {
+		viewType string
+		billable bool
+		want     string
+	}{
+		{"summary", false, "2023-03-01\n  b: \n(Total): "},
+		{"efforts", false, "b: "},
+		{"summary", true, "2023-03-01\n  a: 1h0m\n(Total): 1h0m\n(Billable): 1h0m, (Non-billable): 0m"},
+		{"efforts", true, "a: 1h0m\n(Billable): 1h0m, (Non-billable): 0m"},
+	} {
+		activities := []store.ClosedActivity{free}
+		if c.billable {
+			activities[0] = billable
+		}
+		got, err := Render(activities, c.viewType, nil)
+		if err != nil || got != c.want {
+			t.Errorf("%s (billable %v): got (%q, %v), want %q", c.viewType, c.billable, got, err, c.want)
+		}
+	}
+
+	// days without billable activities show zero billable when any other is billable
+	next := free
+	next.Start, next.End = march.AddDate(0, 0, 1), march.AddDate(0, 0, 1).Add(time.Hour)
+	summary, err := Render([]store.ClosedActivity{billable, next}, "summary", nil)
+	if err != nil || !strings.Contains(summary, "2023-03-02\n  b: 1h0m\n(Total): 1h0m\n(Billable): 0m, (Non-billable): 1h0m") {
+		t.Errorf("Got (%q, %v)", summary, err)
+	}
+	efforts, err := Render([]store.ClosedActivity{billable, next}, "efforts", MetaKey("client"))
+	if err != nil || efforts != "(no client): 2h0m\n(Billable): 1h0m, (Non-billable): 1h0m" {
+		t.Errorf("Got (%q, %v)", efforts, err)
+	}
+
+	if _, ok := NewSummary([]store.ClosedActivity{free}, titleKey).(Summary); !ok {
+		t.Error("Expects a Summary without billable activities")
+	}
+	if _, ok := NewEfforts([]store.ClosedActivity{free}, titleKey).(Efforts); !ok {
+		t.Error("Expects Efforts without billable activities")
+	}
+}